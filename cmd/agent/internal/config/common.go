@@ -27,6 +27,7 @@ func NewBuilder() *Builder {
 			PollInterval:   time.Second * 2,
 			ReportInterval: time.Second * 10,
 			GRPCServer:     ":3200",
+			SpoolSize:      10 << 20,
 		},
 	}
 	return &b
@@ -38,6 +39,7 @@ func (b *Builder) MergeDefaults() *Builder {
 	b.partial.PollInterval = b.defaultConfig.PollInterval
 	b.partial.ReportInterval = b.defaultConfig.ReportInterval
 	b.partial.GRPCServer = b.defaultConfig.GRPCServer
+	b.partial.SpoolSize = b.defaultConfig.SpoolSize
 
 	return b
 }
@@ -55,13 +57,24 @@ func (b *Builder) ReportEnvVars() *Builder {
 
 // ReportFlags prints passed flags
 func (b *Builder) ReportFlags() *Builder {
-	log.Printf("agent is invoked with flags address %v poll interval %v report interval %v key file %v use gRPC %v gRPC server %v",
+	log.Printf("agent is invoked with flags address %v poll interval %v report interval %v key file %v use gRPC %v gRPC server %v gRPC TLS %v gRPC CA %v gRPC cert %v gRPC key %v labels %v host label %v spool dir %v spool size %v collectors %v StatsD address %v agent ID %v",
 		b.flags.address,
 		b.flags.pollInterval,
 		b.flags.reportInterval,
 		b.flags.cryptoKey,
 		b.flags.useGRPC,
 		b.flags.gRPCServer,
+		b.flags.gRPCTLS,
+		b.flags.gRPCCAFile,
+		b.flags.gRPCCertFile,
+		b.flags.gRPCKeyFile,
+		b.flags.labels,
+		b.flags.hostLabel,
+		b.flags.spoolDir,
+		b.flags.spoolSize,
+		b.flags.collectors,
+		b.flags.statsDAddress,
+		b.flags.agentID,
 	)
 
 	return b
@@ -82,3 +95,15 @@ func (b Builder) Final() agent.ConfigType {
 	cfg := b.partial
 	return cfg
 }
+
+// parseList splits the comma separated list, empty items are skipped
+func parseList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -16,6 +16,18 @@ type envVarConfig struct {
 	CryptoKey      *string        `env:"CRYPTO_KEY"`
 	UseGRPC        *bool          `env:"USE_GRPC"`
 	GRPCServer     *string        `env:"GRPC_SERVER"`
+	Labels         *string        `env:"LABELS"`
+	HostLabel      *bool          `env:"HOST_LABEL"`
+	GRPCTLS        *bool          `env:"GRPC_TLS"`
+	GRPCCAFile     *string        `env:"GRPC_CA"`
+	GRPCCertFile   *string        `env:"GRPC_CERT"`
+	GRPCKeyFile    *string        `env:"GRPC_KEY"`
+	SpoolDir       *string        `env:"SPOOL_DIR"`
+	SpoolSize      *int64         `env:"SPOOL_SIZE"`
+	Collectors     *string        `env:"COLLECTORS"`
+	StatsDAddress  *string        `env:"STATSD_ADDRESS"`
+	AgentID        *string        `env:"AGENT_ID"`
+	APIToken       *string        `env:"API_TOKEN"`
 }
 
 // ProcessEnvVars scans environment variables and store them in temporal struct
@@ -36,6 +48,13 @@ func (b *Builder) MergeEnvVars() *Builder {
 	common.CopyIfNotNil(&b.partial.Key, b.envVars.Key)
 	common.CopyIfNotNil(&b.partial.CryptoKey, b.envVars.CryptoKey)
 	common.CopyIfNotNil(&b.partial.GRPCServer, b.envVars.GRPCServer)
+	common.CopyIfNotNil(&b.partial.GRPCCAFile, b.envVars.GRPCCAFile)
+	common.CopyIfNotNil(&b.partial.GRPCCertFile, b.envVars.GRPCCertFile)
+	common.CopyIfNotNil(&b.partial.GRPCKeyFile, b.envVars.GRPCKeyFile)
+	common.CopyIfNotNil(&b.partial.SpoolDir, b.envVars.SpoolDir)
+	common.CopyIfNotNil(&b.partial.StatsDAddress, b.envVars.StatsDAddress)
+	common.CopyIfNotNil(&b.partial.AgentID, b.envVars.AgentID)
+	common.CopyIfNotNil(&b.partial.APIToken, b.envVars.APIToken)
 
 	if b.envVars.PollInterval != nil {
 		b.partial.PollInterval = *b.envVars.PollInterval
@@ -49,5 +68,30 @@ func (b *Builder) MergeEnvVars() *Builder {
 		b.partial.UseGRPC = *b.envVars.UseGRPC
 	}
 
+	if b.envVars.GRPCTLS != nil {
+		b.partial.GRPCTLS = *b.envVars.GRPCTLS
+	}
+
+	if b.envVars.SpoolSize != nil {
+		b.partial.SpoolSize = *b.envVars.SpoolSize
+	}
+
+	if b.envVars.Collectors != nil {
+		b.partial.EnabledCollectors = parseList(*b.envVars.Collectors)
+	}
+
+	if b.envVars.HostLabel != nil {
+		b.partial.HostLabel = *b.envVars.HostLabel
+	}
+
+	if b.envVars.Labels != nil {
+		labels, err := common.ParseLabels(*b.envVars.Labels)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.Labels = labels
+	}
+
 	return b
 }
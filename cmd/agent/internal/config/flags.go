@@ -15,6 +15,18 @@ type flags struct {
 	cryptoKey      common.StringFlag
 	useGRPC        common.BoolFlag
 	gRPCServer     common.StringFlag
+	labels         common.StringFlag
+	hostLabel      common.BoolFlag
+	gRPCTLS        common.BoolFlag
+	gRPCCAFile     common.StringFlag
+	gRPCCertFile   common.StringFlag
+	gRPCKeyFile    common.StringFlag
+	spoolDir       common.StringFlag
+	spoolSize      common.Int64Flag
+	collectors     common.StringFlag
+	statsDAddress  common.StringFlag
+	agentID        common.StringFlag
+	apiToken       common.StringFlag
 }
 
 // ProcessFlags sets command-line flags to use
@@ -43,6 +55,42 @@ func (b *Builder) ProcessFlags() *Builder {
 	b.flags.gRPCServer.Option = "grpc-server"
 	b.flags.gRPCServer.Value = flag.String(b.flags.gRPCServer.Option, "", "gRPC server")
 
+	b.flags.labels.Option = "labels"
+	b.flags.labels.Value = flag.String(b.flags.labels.Option, "", "labels to add to every metric, name=value,...")
+
+	b.flags.hostLabel.Option = "host-label"
+	b.flags.hostLabel.Value = flag.Bool(b.flags.hostLabel.Option, false, "add host label to every metric")
+
+	b.flags.gRPCTLS.Option = "grpc-tls"
+	b.flags.gRPCTLS.Value = flag.Bool(b.flags.gRPCTLS.Option, false, "use TLS for gRPC")
+
+	b.flags.gRPCCAFile.Option = "grpc-ca"
+	b.flags.gRPCCAFile.Value = flag.String(b.flags.gRPCCAFile.Option, "", "gRPC server CA file, enables TLS")
+
+	b.flags.gRPCCertFile.Option = "grpc-cert"
+	b.flags.gRPCCertFile.Value = flag.String(b.flags.gRPCCertFile.Option, "", "gRPC client certificate file")
+
+	b.flags.gRPCKeyFile.Option = "grpc-key"
+	b.flags.gRPCKeyFile.Value = flag.String(b.flags.gRPCKeyFile.Option, "", "gRPC client key file")
+
+	b.flags.spoolDir.Option = "spool-dir"
+	b.flags.spoolDir.Value = flag.String(b.flags.spoolDir.Option, "", "directory to keep batches failed to be sent")
+
+	b.flags.spoolSize.Option = "spool-size"
+	b.flags.spoolSize.Value = flag.Int64(b.flags.spoolSize.Option, b.defaultConfig.SpoolSize, "spool size limit in bytes")
+
+	b.flags.collectors.Option = "collectors"
+	b.flags.collectors.Value = flag.String(b.flags.collectors.Option, "", "the only collectors to run, name,...")
+
+	b.flags.statsDAddress.Option = "statsd-address"
+	b.flags.statsDAddress.Value = flag.String(b.flags.statsDAddress.Option, "", "UDP address to accept StatsD metrics on, e.g. :8125")
+
+	b.flags.agentID.Option = "agent-id"
+	b.flags.agentID.Value = flag.String(b.flags.agentID.Option, "", "agent ID to look up the key on the server")
+
+	b.flags.apiToken.Option = "api-token"
+	b.flags.apiToken.Value = flag.String(b.flags.apiToken.Option, "", "API token with the write scope")
+
 	flag.Parse()
 
 	b.flags.configFile.Set = common.IsFlagPassed(b.flags.configFile.Option)
@@ -53,6 +101,18 @@ func (b *Builder) ProcessFlags() *Builder {
 	b.flags.cryptoKey.Set = common.IsFlagPassed(b.flags.cryptoKey.Option)
 	b.flags.useGRPC.Set = common.IsFlagPassed(b.flags.useGRPC.Option)
 	b.flags.gRPCServer.Set = common.IsFlagPassed(b.flags.gRPCServer.Option)
+	b.flags.labels.Set = common.IsFlagPassed(b.flags.labels.Option)
+	b.flags.hostLabel.Set = common.IsFlagPassed(b.flags.hostLabel.Option)
+	b.flags.gRPCTLS.Set = common.IsFlagPassed(b.flags.gRPCTLS.Option)
+	b.flags.gRPCCAFile.Set = common.IsFlagPassed(b.flags.gRPCCAFile.Option)
+	b.flags.gRPCCertFile.Set = common.IsFlagPassed(b.flags.gRPCCertFile.Option)
+	b.flags.gRPCKeyFile.Set = common.IsFlagPassed(b.flags.gRPCKeyFile.Option)
+	b.flags.spoolDir.Set = common.IsFlagPassed(b.flags.spoolDir.Option)
+	b.flags.spoolSize.Set = common.IsFlagPassed(b.flags.spoolSize.Option)
+	b.flags.collectors.Set = common.IsFlagPassed(b.flags.collectors.Option)
+	b.flags.statsDAddress.Set = common.IsFlagPassed(b.flags.statsDAddress.Option)
+	b.flags.agentID.Set = common.IsFlagPassed(b.flags.agentID.Option)
+	b.flags.apiToken.Set = common.IsFlagPassed(b.flags.apiToken.Option)
 
 	return b
 }
@@ -80,5 +140,46 @@ func (b *Builder) MergeFlags() *Builder {
 	if b.flags.gRPCServer.Set {
 		b.partial.GRPCServer = *b.flags.gRPCServer.Value
 	}
+	if b.flags.gRPCTLS.Set {
+		b.partial.GRPCTLS = *b.flags.gRPCTLS.Value
+	}
+	if b.flags.gRPCCAFile.Set {
+		b.partial.GRPCCAFile = *b.flags.gRPCCAFile.Value
+	}
+	if b.flags.gRPCCertFile.Set {
+		b.partial.GRPCCertFile = *b.flags.gRPCCertFile.Value
+	}
+	if b.flags.gRPCKeyFile.Set {
+		b.partial.GRPCKeyFile = *b.flags.gRPCKeyFile.Value
+	}
+	if b.flags.spoolDir.Set {
+		b.partial.SpoolDir = *b.flags.spoolDir.Value
+	}
+	if b.flags.spoolSize.Set {
+		b.partial.SpoolSize = *b.flags.spoolSize.Value
+	}
+	if b.flags.collectors.Set {
+		b.partial.EnabledCollectors = parseList(*b.flags.collectors.Value)
+	}
+	if b.flags.statsDAddress.Set {
+		b.partial.StatsDAddress = *b.flags.statsDAddress.Value
+	}
+	if b.flags.agentID.Set {
+		b.partial.AgentID = *b.flags.agentID.Value
+	}
+	if b.flags.apiToken.Set {
+		b.partial.APIToken = *b.flags.apiToken.Value
+	}
+	if b.flags.hostLabel.Set {
+		b.partial.HostLabel = *b.flags.hostLabel.Value
+	}
+	if b.flags.labels.Set {
+		labels, err := common.ParseLabels(*b.flags.labels.Value)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.Labels = labels
+	}
 	return b
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/alexey-mavrin/go-musthave-devops/internal/agent"
 	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 )
 
@@ -17,6 +18,21 @@ type JSONConfig struct {
 	ReportIntervalStr *string `json:"report_interval"`
 	UseGRPC           *bool   `json:"use_grpc"`
 	GRPCServer        *string `json:"grpc_server"`
+	HostLabel         *bool   `json:"host_label"`
+	GRPCTLS           *bool   `json:"grpc_tls"`
+	GRPCCAFile        *string `json:"grpc_ca"`
+	GRPCCertFile      *string `json:"grpc_cert"`
+	GRPCKeyFile       *string `json:"grpc_key"`
+	SpoolDir          *string `json:"spool_dir"`
+	SpoolSize         *int64  `json:"spool_size"`
+	StatsDAddress     *string `json:"statsd_address"`
+	AgentID           *string `json:"agent_id"`
+	APIToken          *string `json:"api_token"`
+	// Labels are set as an object, e.g. {"dc": "east"}
+	Labels map[string]string `json:"labels"`
+	// Collectors are set by the collector name, e.g.
+	// {"psutil": {"enabled": true, "interval": "10s"}}
+	Collectors map[string]agent.CollectorConfig `json:"collectors"`
 }
 
 // ReadJSONConfig parses config file and returns parsed data in struct
@@ -50,6 +66,13 @@ func (b *Builder) MergeJSONConfig() *Builder {
 	common.CopyIfNotNil(&b.partial.Key, b.jsonConfig.Key)
 	common.CopyIfNotNil(&b.partial.CryptoKey, b.jsonConfig.CryptoKey)
 	common.CopyIfNotNil(&b.partial.GRPCServer, b.jsonConfig.GRPCServer)
+	common.CopyIfNotNil(&b.partial.GRPCCAFile, b.jsonConfig.GRPCCAFile)
+	common.CopyIfNotNil(&b.partial.GRPCCertFile, b.jsonConfig.GRPCCertFile)
+	common.CopyIfNotNil(&b.partial.GRPCKeyFile, b.jsonConfig.GRPCKeyFile)
+	common.CopyIfNotNil(&b.partial.SpoolDir, b.jsonConfig.SpoolDir)
+	common.CopyIfNotNil(&b.partial.StatsDAddress, b.jsonConfig.StatsDAddress)
+	common.CopyIfNotNil(&b.partial.AgentID, b.jsonConfig.AgentID)
+	common.CopyIfNotNil(&b.partial.APIToken, b.jsonConfig.APIToken)
 
 	if b.jsonConfig.PollIntervalStr != nil {
 		pollInterval, err := time.ParseDuration(*b.jsonConfig.PollIntervalStr)
@@ -73,5 +96,25 @@ func (b *Builder) MergeJSONConfig() *Builder {
 		b.partial.UseGRPC = *b.jsonConfig.UseGRPC
 	}
 
+	if b.jsonConfig.GRPCTLS != nil {
+		b.partial.GRPCTLS = *b.jsonConfig.GRPCTLS
+	}
+
+	if b.jsonConfig.SpoolSize != nil {
+		b.partial.SpoolSize = *b.jsonConfig.SpoolSize
+	}
+
+	if b.jsonConfig.HostLabel != nil {
+		b.partial.HostLabel = *b.jsonConfig.HostLabel
+	}
+
+	if b.jsonConfig.Collectors != nil {
+		b.partial.Collectors = b.jsonConfig.Collectors
+	}
+
+	if b.jsonConfig.Labels != nil {
+		b.partial.Labels = b.jsonConfig.Labels
+	}
+
 	return b
 }
@@ -23,10 +23,16 @@ type Builder struct {
 func NewBuilder() *Builder {
 	b := Builder{
 		defaultConfig: server.ConfigType{
-			Address:       "localhost:8080",
-			StoreInterval: time.Second * 300,
-			StoreFile:     "/tmp/devops-metrics-db.json",
-			Restore:       true,
+			Address:             "localhost:8080",
+			StoreInterval:       time.Second * 300,
+			StoreFile:           "/tmp/devops-metrics-db.json",
+			Restore:             true,
+			HistoryRetention:    time.Hour,
+			ShutdownTimeout:     time.Second * 10,
+			GRPCAddress:         ":3200",
+			GRPCEnabled:         true,
+			StatsDFlushInterval: time.Second * 10,
+			ReplayWindow:        time.Minute * 5,
 		},
 	}
 	return &b
@@ -38,6 +44,12 @@ func (b *Builder) MergeDefaults() *Builder {
 	b.partial.StoreInterval = b.defaultConfig.StoreInterval
 	b.partial.StoreFile = b.defaultConfig.StoreFile
 	b.partial.Restore = b.defaultConfig.Restore
+	b.partial.HistoryRetention = b.defaultConfig.HistoryRetention
+	b.partial.ShutdownTimeout = b.defaultConfig.ShutdownTimeout
+	b.partial.GRPCAddress = b.defaultConfig.GRPCAddress
+	b.partial.GRPCEnabled = b.defaultConfig.GRPCEnabled
+	b.partial.StatsDFlushInterval = b.defaultConfig.StatsDFlushInterval
+	b.partial.ReplayWindow = b.defaultConfig.ReplayWindow
 
 	return b
 }
@@ -56,13 +68,32 @@ func (b *Builder) ReportEnvVars() *Builder {
 
 // ReportFlags prints passed flags
 func (b *Builder) ReportFlags() *Builder {
-	log.Printf("server is invoked with flags address %s store interval %v store file %v restore %v database %v trusted subnet %v",
+	log.Printf("server is invoked with flags address %s store interval %v store file %v restore %v database %v trusted subnet %v history retention %v shutdown timeout %v prometheus runtime metrics %v gRPC enabled %v gRPC address %v gRPC cert %v gRPC key %v gRPC client CA %v cumulative counters %v Graphite address %v StatsD address %v StatsD flush interval %v replay window %v agent keys file %v agent keys in DB %v agent label %v tokens file %v tokens in DB %v require envelope %v",
 		b.flags.address,
 		b.flags.storeInterval,
 		b.flags.storeFile,
 		b.flags.restore,
 		b.flags.databaseDSN,
 		b.flags.trustedSubnetStr,
+		b.flags.historyRetention,
+		b.flags.shutdownTimeout,
+		b.flags.promRuntime,
+		b.flags.grpcEnabled,
+		b.flags.grpcAddress,
+		b.flags.grpcCertFile,
+		b.flags.grpcKeyFile,
+		b.flags.grpcClientCAFile,
+		b.flags.cumulativeCounters,
+		b.flags.graphiteAddress,
+		b.flags.statsDAddress,
+		b.flags.statsDFlush,
+		b.flags.replayWindow,
+		b.flags.agentKeysFile,
+		b.flags.agentKeysDB,
+		b.flags.agentLabel,
+		b.flags.tokensFile,
+		b.flags.tokensDB,
+		b.flags.requireEnvelope,
 	)
 
 	return b
@@ -18,10 +18,16 @@ func TestNewBuilder(t *testing.T) {
 			name: "get new builder struct with defaults",
 			want: &Builder{
 				defaultConfig: server.ConfigType{
-					Address:       "localhost:8080",
-					StoreFile:     "/tmp/devops-metrics-db.json",
-					Restore:       true,
-					StoreInterval: 300 * time.Second,
+					Address:             "localhost:8080",
+					StoreFile:           "/tmp/devops-metrics-db.json",
+					Restore:             true,
+					StoreInterval:       300 * time.Second,
+					HistoryRetention:    time.Hour,
+					ShutdownTimeout:     10 * time.Second,
+					GRPCAddress:         ":3200",
+					GRPCEnabled:         true,
+					StatsDFlushInterval: 10 * time.Second,
+					ReplayWindow:        5 * time.Minute,
 				},
 			},
 			wantErr: assert.NoError,
@@ -49,10 +55,16 @@ func TestBuilder_MergeDefaults(t *testing.T) {
 			name: "merge default fields",
 			want: &Builder{
 				partial: server.ConfigType{
-					Address:       "localhost:8080",
-					StoreFile:     "/tmp/devops-metrics-db.json",
-					Restore:       true,
-					StoreInterval: 300 * time.Second,
+					Address:             "localhost:8080",
+					StoreFile:           "/tmp/devops-metrics-db.json",
+					Restore:             true,
+					StoreInterval:       300 * time.Second,
+					HistoryRetention:    time.Hour,
+					ShutdownTimeout:     10 * time.Second,
+					GRPCAddress:         ":3200",
+					GRPCEnabled:         true,
+					StatsDFlushInterval: 10 * time.Second,
+					ReplayWindow:        5 * time.Minute,
 				},
 			},
 			wantErr: assert.NoError,
@@ -80,10 +92,16 @@ func TestBuilder_Final(t *testing.T) {
 		{
 			name: "simple test with defaults only",
 			want: &server.ConfigType{
-				Address:       "localhost:8080",
-				StoreFile:     "/tmp/devops-metrics-db.json",
-				Restore:       true,
-				StoreInterval: 300 * time.Second,
+				Address:             "localhost:8080",
+				StoreFile:           "/tmp/devops-metrics-db.json",
+				Restore:             true,
+				StoreInterval:       300 * time.Second,
+				HistoryRetention:    time.Hour,
+				ShutdownTimeout:     10 * time.Second,
+				GRPCAddress:         ":3200",
+				GRPCEnabled:         true,
+				StatsDFlushInterval: 10 * time.Second,
+				ReplayWindow:        5 * time.Minute,
 			},
 			wantErr: assert.NoError,
 		},
@@ -91,13 +109,19 @@ func TestBuilder_Final(t *testing.T) {
 			name:       "some values from defaults, others from json",
 			jsonConfig: "testdata/2.json",
 			want: &server.ConfigType{
-				Address:       "l:1",
-				StoreFile:     "/tmp/devops-metrics-db.json",
-				Key:           "",
-				CryptoKey:     "",
-				DatabaseDSN:   "",
-				StoreInterval: 33 * time.Second,
-				Restore:       false,
+				Address:             "l:1",
+				StoreFile:           "/tmp/devops-metrics-db.json",
+				Key:                 "",
+				CryptoKey:           "",
+				DatabaseDSN:         "",
+				StoreInterval:       33 * time.Second,
+				Restore:             false,
+				HistoryRetention:    time.Hour,
+				ShutdownTimeout:     10 * time.Second,
+				GRPCAddress:         ":3200",
+				GRPCEnabled:         true,
+				StatsDFlushInterval: 10 * time.Second,
+				ReplayWindow:        5 * time.Minute,
 			},
 			wantErr: assert.NoError,
 		},
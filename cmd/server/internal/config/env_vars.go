@@ -10,15 +10,34 @@ import (
 )
 
 type envVarConfig struct {
-	Address          *string        `env:"ADDRESS"`
-	StoreInterval    *time.Duration `env:"STORE_INTERVAL"`
-	StoreFile        *string        `env:"STORE_FILE"`
-	ConfigFile       *string        `env:"CONFIG"`
-	Restore          *bool          `env:"RESTORE"`
-	Key              *string        `env:"KEY"`
-	CryptoKey        *string        `env:"CRYPTO_KEY"`
-	DatabaseDSN      *string        `env:"DATABASE_DSN"`
-	TrustedSubnetStr *string        `env:"TRUSTED_SUBNET"`
+	Address            *string        `env:"ADDRESS"`
+	StoreInterval      *time.Duration `env:"STORE_INTERVAL"`
+	StoreFile          *string        `env:"STORE_FILE"`
+	ConfigFile         *string        `env:"CONFIG"`
+	Restore            *bool          `env:"RESTORE"`
+	Key                *string        `env:"KEY"`
+	CryptoKey          *string        `env:"CRYPTO_KEY"`
+	DatabaseDSN        *string        `env:"DATABASE_DSN"`
+	TrustedSubnetStr   *string        `env:"TRUSTED_SUBNET"`
+	HistoryRetention   *time.Duration `env:"HISTORY_RETENTION"`
+	ShutdownTimeout    *time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	PromRuntime        *bool          `env:"PROM_RUNTIME_METRICS"`
+	GRPCEnabled        *bool          `env:"GRPC_ENABLED"`
+	GRPCAddress        *string        `env:"GRPC_ADDRESS"`
+	GRPCCertFile       *string        `env:"GRPC_CERT"`
+	GRPCKeyFile        *string        `env:"GRPC_KEY"`
+	GRPCClientCAFile   *string        `env:"GRPC_CLIENT_CA"`
+	CumulativeCounters *bool          `env:"CUMULATIVE_COUNTERS"`
+	GraphiteAddress    *string        `env:"GRAPHITE_ADDRESS"`
+	StatsDAddress      *string        `env:"STATSD_ADDRESS"`
+	StatsDFlush        *time.Duration `env:"STATSD_FLUSH_INTERVAL"`
+	ReplayWindow       *time.Duration `env:"REPLAY_WINDOW"`
+	AgentKeysFile      *string        `env:"AGENT_KEYS_FILE"`
+	AgentKeysDB        *bool          `env:"AGENT_KEYS_DB"`
+	AgentLabel         *string        `env:"AGENT_LABEL"`
+	TokensFile         *string        `env:"TOKENS_FILE"`
+	TokensDB           *bool          `env:"TOKENS_DB"`
+	RequireEnvelope    *bool          `env:"REQUIRE_ENVELOPE"`
 }
 
 // ProcessEnvVars scans environment variables and store them in temporal struct
@@ -47,6 +66,15 @@ func (b *Builder) MergeEnvVars() *Builder {
 	common.CopyIfNotNil(&b.partial.Key, b.envVars.Key)
 	common.CopyIfNotNil(&b.partial.CryptoKey, b.envVars.CryptoKey)
 	common.CopyIfNotNil(&b.partial.DatabaseDSN, b.envVars.DatabaseDSN)
+	common.CopyIfNotNil(&b.partial.GRPCAddress, b.envVars.GRPCAddress)
+	common.CopyIfNotNil(&b.partial.GRPCCertFile, b.envVars.GRPCCertFile)
+	common.CopyIfNotNil(&b.partial.GRPCKeyFile, b.envVars.GRPCKeyFile)
+	common.CopyIfNotNil(&b.partial.GRPCClientCAFile, b.envVars.GRPCClientCAFile)
+	common.CopyIfNotNil(&b.partial.GraphiteAddress, b.envVars.GraphiteAddress)
+	common.CopyIfNotNil(&b.partial.StatsDAddress, b.envVars.StatsDAddress)
+	common.CopyIfNotNil(&b.partial.AgentKeysFile, b.envVars.AgentKeysFile)
+	common.CopyIfNotNil(&b.partial.AgentLabel, b.envVars.AgentLabel)
+	common.CopyIfNotNil(&b.partial.TokensFile, b.envVars.TokensFile)
 
 	if b.envVars.StoreInterval != nil {
 		b.partial.StoreInterval = *b.envVars.StoreInterval
@@ -56,6 +84,44 @@ func (b *Builder) MergeEnvVars() *Builder {
 		b.partial.Restore = *b.envVars.Restore
 	}
 
+	if b.envVars.HistoryRetention != nil {
+		b.partial.HistoryRetention = *b.envVars.HistoryRetention
+	}
+
+	if b.envVars.ShutdownTimeout != nil {
+		b.partial.ShutdownTimeout = *b.envVars.ShutdownTimeout
+	}
+
+	if b.envVars.StatsDFlush != nil {
+		b.partial.StatsDFlushInterval = *b.envVars.StatsDFlush
+	}
+
+	if b.envVars.ReplayWindow != nil {
+		b.partial.ReplayWindow = *b.envVars.ReplayWindow
+	}
+
+	if b.envVars.AgentKeysDB != nil {
+		b.partial.AgentKeysDB = *b.envVars.AgentKeysDB
+	}
+	if b.envVars.TokensDB != nil {
+		b.partial.TokensDB = *b.envVars.TokensDB
+	}
+	if b.envVars.RequireEnvelope != nil {
+		b.partial.RequireEnvelope = *b.envVars.RequireEnvelope
+	}
+
+	if b.envVars.CumulativeCounters != nil {
+		b.partial.CumulativeCounters = *b.envVars.CumulativeCounters
+	}
+
+	if b.envVars.GRPCEnabled != nil {
+		b.partial.GRPCEnabled = *b.envVars.GRPCEnabled
+	}
+
+	if b.envVars.PromRuntime != nil {
+		b.partial.PromRuntimeMetrics = *b.envVars.PromRuntime
+	}
+
 	if b.envVars.TrustedSubnetStr != nil {
 		_, subnet, err := net.ParseCIDR(*b.envVars.TrustedSubnetStr)
 		if err != nil {
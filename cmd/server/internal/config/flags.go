@@ -8,15 +8,34 @@ import (
 )
 
 type flags struct {
-	configFile       common.StringFlag
-	address          common.StringFlag
-	storeInterval    common.TimeFlag
-	storeFile        common.StringFlag
-	restore          common.BoolFlag
-	key              common.StringFlag
-	cryptoKey        common.StringFlag
-	databaseDSN      common.StringFlag
-	trustedSubnetStr common.StringFlag
+	configFile         common.StringFlag
+	address            common.StringFlag
+	storeInterval      common.TimeFlag
+	storeFile          common.StringFlag
+	restore            common.BoolFlag
+	key                common.StringFlag
+	cryptoKey          common.StringFlag
+	databaseDSN        common.StringFlag
+	trustedSubnetStr   common.StringFlag
+	historyRetention   common.TimeFlag
+	shutdownTimeout    common.TimeFlag
+	promRuntime        common.BoolFlag
+	grpcEnabled        common.BoolFlag
+	grpcAddress        common.StringFlag
+	grpcCertFile       common.StringFlag
+	grpcKeyFile        common.StringFlag
+	grpcClientCAFile   common.StringFlag
+	cumulativeCounters common.BoolFlag
+	graphiteAddress    common.StringFlag
+	statsDAddress      common.StringFlag
+	statsDFlush        common.TimeFlag
+	replayWindow       common.TimeFlag
+	agentKeysFile      common.StringFlag
+	agentKeysDB        common.BoolFlag
+	agentLabel         common.StringFlag
+	tokensFile         common.StringFlag
+	tokensDB           common.BoolFlag
+	requireEnvelope    common.BoolFlag
 }
 
 // ProcessFlags sets command-line flags to use
@@ -48,6 +67,63 @@ func (b *Builder) ProcessFlags() *Builder {
 	b.flags.trustedSubnetStr.Option = "t"
 	b.flags.trustedSubnetStr.Value = flag.String(b.flags.trustedSubnetStr.Option, "", "trusted subnet")
 
+	b.flags.historyRetention.Option = "history-retention"
+	b.flags.historyRetention.Value = flag.Duration(b.flags.historyRetention.Option, b.defaultConfig.HistoryRetention, "history retention, 0 disables history")
+
+	b.flags.shutdownTimeout.Option = "shutdown-timeout"
+	b.flags.shutdownTimeout.Value = flag.Duration(b.flags.shutdownTimeout.Option, b.defaultConfig.ShutdownTimeout, "time to wait for running requests on exit")
+
+	b.flags.promRuntime.Option = "prom-runtime"
+	b.flags.promRuntime.Value = flag.Bool(b.flags.promRuntime.Option, false, "add runtime metrics to /metrics")
+
+	b.flags.grpcEnabled.Option = "grpc-enabled"
+	b.flags.grpcEnabled.Value = flag.Bool(b.flags.grpcEnabled.Option, b.defaultConfig.GRPCEnabled, "start gRPC server")
+
+	b.flags.grpcAddress.Option = "grpc-address"
+	b.flags.grpcAddress.Value = flag.String(b.flags.grpcAddress.Option, b.defaultConfig.GRPCAddress, "gRPC bind address")
+
+	b.flags.grpcCertFile.Option = "grpc-cert"
+	b.flags.grpcCertFile.Value = flag.String(b.flags.grpcCertFile.Option, "", "gRPC TLS certificate file")
+
+	b.flags.grpcKeyFile.Option = "grpc-key"
+	b.flags.grpcKeyFile.Value = flag.String(b.flags.grpcKeyFile.Option, "", "gRPC TLS key file")
+
+	b.flags.grpcClientCAFile.Option = "grpc-client-ca"
+	b.flags.grpcClientCAFile.Value = flag.String(b.flags.grpcClientCAFile.Option, "", "gRPC client CA file, enables mTLS")
+
+	b.flags.cumulativeCounters.Option = "cumulative-counters"
+	b.flags.cumulativeCounters.Value = flag.Bool(b.flags.cumulativeCounters.Option, false, "treat received counters as running totals")
+
+	b.flags.graphiteAddress.Option = "graphite-address"
+	b.flags.graphiteAddress.Value = flag.String(b.flags.graphiteAddress.Option, "", "TCP address to accept Graphite plaintext metrics on, e.g. :2003. Unauthenticated, only the trusted subnet is checked")
+
+	b.flags.statsDAddress.Option = "statsd-address"
+	b.flags.statsDAddress.Value = flag.String(b.flags.statsDAddress.Option, "", "UDP address to accept StatsD metrics on, e.g. :8125. Unauthenticated, only the trusted subnet is checked")
+
+	b.flags.statsDFlush.Option = "statsd-flush-interval"
+	b.flags.statsDFlush.Value = flag.Duration(b.flags.statsDFlush.Option, b.defaultConfig.StatsDFlushInterval, "how often aggregated StatsD metrics are stored")
+
+	b.flags.replayWindow.Option = "replay-window"
+	b.flags.replayWindow.Value = flag.Duration(b.flags.replayWindow.Option, b.defaultConfig.ReplayWindow, "allowed clock skew of signed batches")
+
+	b.flags.agentKeysFile.Option = "agent-keys-file"
+	b.flags.agentKeysFile.Value = flag.String(b.flags.agentKeysFile.Option, "", "JSON file with the keys of the agents by their IDs")
+
+	b.flags.agentKeysDB.Option = "agent-keys-db"
+	b.flags.agentKeysDB.Value = flag.Bool(b.flags.agentKeysDB.Option, false, "look up the keys of the agents in the database")
+
+	b.flags.agentLabel.Option = "agent-label"
+	b.flags.agentLabel.Value = flag.String(b.flags.agentLabel.Option, "", "label to add with the agent ID to signed metrics, e.g. agent")
+
+	b.flags.tokensFile.Option = "tokens-file"
+	b.flags.tokensFile.Value = flag.String(b.flags.tokensFile.Option, "", "JSON file with the hashed API tokens and their scopes")
+
+	b.flags.tokensDB.Option = "tokens-db"
+	b.flags.tokensDB.Value = flag.Bool(b.flags.tokensDB.Option, false, "look up the API tokens in the database")
+
+	b.flags.requireEnvelope.Option = "require-envelope"
+	b.flags.requireEnvelope.Value = flag.Bool(b.flags.requireEnvelope.Option, false, "accept only writes signed with a replay-protected envelope")
+
 	flag.Parse()
 
 	b.flags.configFile.Set = common.IsFlagPassed(b.flags.configFile.Option)
@@ -59,6 +135,25 @@ func (b *Builder) ProcessFlags() *Builder {
 	b.flags.cryptoKey.Set = common.IsFlagPassed(b.flags.cryptoKey.Option)
 	b.flags.databaseDSN.Set = common.IsFlagPassed(b.flags.databaseDSN.Option)
 	b.flags.trustedSubnetStr.Set = common.IsFlagPassed(b.flags.trustedSubnetStr.Option)
+	b.flags.historyRetention.Set = common.IsFlagPassed(b.flags.historyRetention.Option)
+	b.flags.shutdownTimeout.Set = common.IsFlagPassed(b.flags.shutdownTimeout.Option)
+	b.flags.promRuntime.Set = common.IsFlagPassed(b.flags.promRuntime.Option)
+	b.flags.grpcEnabled.Set = common.IsFlagPassed(b.flags.grpcEnabled.Option)
+	b.flags.grpcAddress.Set = common.IsFlagPassed(b.flags.grpcAddress.Option)
+	b.flags.grpcCertFile.Set = common.IsFlagPassed(b.flags.grpcCertFile.Option)
+	b.flags.grpcKeyFile.Set = common.IsFlagPassed(b.flags.grpcKeyFile.Option)
+	b.flags.grpcClientCAFile.Set = common.IsFlagPassed(b.flags.grpcClientCAFile.Option)
+	b.flags.cumulativeCounters.Set = common.IsFlagPassed(b.flags.cumulativeCounters.Option)
+	b.flags.graphiteAddress.Set = common.IsFlagPassed(b.flags.graphiteAddress.Option)
+	b.flags.statsDAddress.Set = common.IsFlagPassed(b.flags.statsDAddress.Option)
+	b.flags.statsDFlush.Set = common.IsFlagPassed(b.flags.statsDFlush.Option)
+	b.flags.replayWindow.Set = common.IsFlagPassed(b.flags.replayWindow.Option)
+	b.flags.agentKeysFile.Set = common.IsFlagPassed(b.flags.agentKeysFile.Option)
+	b.flags.agentKeysDB.Set = common.IsFlagPassed(b.flags.agentKeysDB.Option)
+	b.flags.agentLabel.Set = common.IsFlagPassed(b.flags.agentLabel.Option)
+	b.flags.tokensFile.Set = common.IsFlagPassed(b.flags.tokensFile.Option)
+	b.flags.tokensDB.Set = common.IsFlagPassed(b.flags.tokensDB.Option)
+	b.flags.requireEnvelope.Set = common.IsFlagPassed(b.flags.requireEnvelope.Option)
 
 	return b
 }
@@ -86,6 +181,63 @@ func (b *Builder) MergeFlags() *Builder {
 	if b.flags.databaseDSN.Set {
 		b.partial.DatabaseDSN = *b.flags.databaseDSN.Value
 	}
+	if b.flags.historyRetention.Set {
+		b.partial.HistoryRetention = *b.flags.historyRetention.Value
+	}
+	if b.flags.shutdownTimeout.Set {
+		b.partial.ShutdownTimeout = *b.flags.shutdownTimeout.Value
+	}
+	if b.flags.promRuntime.Set {
+		b.partial.PromRuntimeMetrics = *b.flags.promRuntime.Value
+	}
+	if b.flags.grpcEnabled.Set {
+		b.partial.GRPCEnabled = *b.flags.grpcEnabled.Value
+	}
+	if b.flags.grpcAddress.Set {
+		b.partial.GRPCAddress = *b.flags.grpcAddress.Value
+	}
+	if b.flags.grpcCertFile.Set {
+		b.partial.GRPCCertFile = *b.flags.grpcCertFile.Value
+	}
+	if b.flags.grpcKeyFile.Set {
+		b.partial.GRPCKeyFile = *b.flags.grpcKeyFile.Value
+	}
+	if b.flags.grpcClientCAFile.Set {
+		b.partial.GRPCClientCAFile = *b.flags.grpcClientCAFile.Value
+	}
+	if b.flags.cumulativeCounters.Set {
+		b.partial.CumulativeCounters = *b.flags.cumulativeCounters.Value
+	}
+	if b.flags.graphiteAddress.Set {
+		b.partial.GraphiteAddress = *b.flags.graphiteAddress.Value
+	}
+	if b.flags.statsDAddress.Set {
+		b.partial.StatsDAddress = *b.flags.statsDAddress.Value
+	}
+	if b.flags.statsDFlush.Set {
+		b.partial.StatsDFlushInterval = *b.flags.statsDFlush.Value
+	}
+	if b.flags.replayWindow.Set {
+		b.partial.ReplayWindow = *b.flags.replayWindow.Value
+	}
+	if b.flags.agentKeysFile.Set {
+		b.partial.AgentKeysFile = *b.flags.agentKeysFile.Value
+	}
+	if b.flags.agentKeysDB.Set {
+		b.partial.AgentKeysDB = *b.flags.agentKeysDB.Value
+	}
+	if b.flags.agentLabel.Set {
+		b.partial.AgentLabel = *b.flags.agentLabel.Value
+	}
+	if b.flags.tokensFile.Set {
+		b.partial.TokensFile = *b.flags.tokensFile.Value
+	}
+	if b.flags.tokensDB.Set {
+		b.partial.TokensDB = *b.flags.tokensDB.Value
+	}
+	if b.flags.requireEnvelope.Set {
+		b.partial.RequireEnvelope = *b.flags.requireEnvelope.Value
+	}
 	if b.flags.trustedSubnetStr.Set {
 		_, subnet, err := net.ParseCIDR(*b.flags.trustedSubnetStr.Value)
 		if err != nil {
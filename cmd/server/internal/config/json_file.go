@@ -11,14 +11,33 @@ import (
 
 // JSONConfig is used to parse json config file
 type JSONConfig struct {
-	Address          *string `json:"address"`
-	StoreFile        *string `json:"store_file"`
-	Key              *string `json:"key"`
-	CryptoKey        *string `json:"crypto_key"`
-	DatabaseDSN      *string `json:"database_dsn"`
-	StoreIntervalStr *string `json:"store_interval"`
-	TrustedSubnetStr *string `json:"trusted_subnet"`
-	Restore          *bool   `json:"restore"`
+	Address            *string `json:"address"`
+	StoreFile          *string `json:"store_file"`
+	Key                *string `json:"key"`
+	CryptoKey          *string `json:"crypto_key"`
+	DatabaseDSN        *string `json:"database_dsn"`
+	StoreIntervalStr   *string `json:"store_interval"`
+	TrustedSubnetStr   *string `json:"trusted_subnet"`
+	HistoryRetention   *string `json:"history_retention"`
+	ShutdownTimeout    *string `json:"shutdown_timeout"`
+	Restore            *bool   `json:"restore"`
+	PromRuntime        *bool   `json:"prom_runtime_metrics"`
+	GRPCEnabled        *bool   `json:"grpc_enabled"`
+	GRPCAddress        *string `json:"grpc_address"`
+	GRPCCertFile       *string `json:"grpc_cert"`
+	GRPCKeyFile        *string `json:"grpc_key"`
+	GRPCClientCAFile   *string `json:"grpc_client_ca"`
+	CumulativeCounters *bool   `json:"cumulative_counters"`
+	GraphiteAddress    *string `json:"graphite_address"`
+	StatsDAddress      *string `json:"statsd_address"`
+	StatsDFlush        *string `json:"statsd_flush_interval"`
+	ReplayWindow       *string `json:"replay_window"`
+	AgentKeysFile      *string `json:"agent_keys_file"`
+	AgentKeysDB        *bool   `json:"agent_keys_db"`
+	AgentLabel         *string `json:"agent_label"`
+	TokensFile         *string `json:"tokens_file"`
+	TokensDB           *bool   `json:"tokens_db"`
+	RequireEnvelope    *bool   `json:"require_envelope"`
 }
 
 // ReadJSONConfig parses config file and returns parsed data in struct
@@ -53,6 +72,15 @@ func (b *Builder) MergeJSONConfig() *Builder {
 	common.CopyIfNotNil(&b.partial.Key, b.jsonConfig.Key)
 	common.CopyIfNotNil(&b.partial.CryptoKey, b.jsonConfig.CryptoKey)
 	common.CopyIfNotNil(&b.partial.DatabaseDSN, b.jsonConfig.DatabaseDSN)
+	common.CopyIfNotNil(&b.partial.GRPCAddress, b.jsonConfig.GRPCAddress)
+	common.CopyIfNotNil(&b.partial.GRPCCertFile, b.jsonConfig.GRPCCertFile)
+	common.CopyIfNotNil(&b.partial.GRPCKeyFile, b.jsonConfig.GRPCKeyFile)
+	common.CopyIfNotNil(&b.partial.GRPCClientCAFile, b.jsonConfig.GRPCClientCAFile)
+	common.CopyIfNotNil(&b.partial.GraphiteAddress, b.jsonConfig.GraphiteAddress)
+	common.CopyIfNotNil(&b.partial.StatsDAddress, b.jsonConfig.StatsDAddress)
+	common.CopyIfNotNil(&b.partial.AgentKeysFile, b.jsonConfig.AgentKeysFile)
+	common.CopyIfNotNil(&b.partial.AgentLabel, b.jsonConfig.AgentLabel)
+	common.CopyIfNotNil(&b.partial.TokensFile, b.jsonConfig.TokensFile)
 
 	if b.jsonConfig.StoreIntervalStr != nil {
 		storeInterval, err := time.ParseDuration(*b.jsonConfig.StoreIntervalStr)
@@ -63,10 +91,68 @@ func (b *Builder) MergeJSONConfig() *Builder {
 		b.partial.StoreInterval = storeInterval
 	}
 
+	if b.jsonConfig.HistoryRetention != nil {
+		historyRetention, err := time.ParseDuration(*b.jsonConfig.HistoryRetention)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.HistoryRetention = historyRetention
+	}
+
+	if b.jsonConfig.ShutdownTimeout != nil {
+		shutdownTimeout, err := time.ParseDuration(*b.jsonConfig.ShutdownTimeout)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.ShutdownTimeout = shutdownTimeout
+	}
+
+	if b.jsonConfig.StatsDFlush != nil {
+		statsDFlush, err := time.ParseDuration(*b.jsonConfig.StatsDFlush)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.StatsDFlushInterval = statsDFlush
+	}
+
+	if b.jsonConfig.ReplayWindow != nil {
+		replayWindow, err := time.ParseDuration(*b.jsonConfig.ReplayWindow)
+		if err != nil {
+			b.err = err
+			return b
+		}
+		b.partial.ReplayWindow = replayWindow
+	}
+
 	if b.jsonConfig.Restore != nil {
 		b.partial.Restore = *b.jsonConfig.Restore
 	}
 
+	if b.jsonConfig.AgentKeysDB != nil {
+		b.partial.AgentKeysDB = *b.jsonConfig.AgentKeysDB
+	}
+	if b.jsonConfig.TokensDB != nil {
+		b.partial.TokensDB = *b.jsonConfig.TokensDB
+	}
+	if b.jsonConfig.RequireEnvelope != nil {
+		b.partial.RequireEnvelope = *b.jsonConfig.RequireEnvelope
+	}
+
+	if b.jsonConfig.CumulativeCounters != nil {
+		b.partial.CumulativeCounters = *b.jsonConfig.CumulativeCounters
+	}
+
+	if b.jsonConfig.GRPCEnabled != nil {
+		b.partial.GRPCEnabled = *b.jsonConfig.GRPCEnabled
+	}
+
+	if b.jsonConfig.PromRuntime != nil {
+		b.partial.PromRuntimeMetrics = *b.jsonConfig.PromRuntime
+	}
+
 	if b.jsonConfig.TrustedSubnetStr != nil {
 		_, subnet, err := net.ParseCIDR(*b.jsonConfig.TrustedSubnetStr)
 		if err != nil {
@@ -15,10 +15,10 @@ var (
 	buildCommit  string
 )
 
-func setServerArgs() error {
+func setServerArgs() (server.ConfigType, error) {
 	builder := config.NewBuilder()
 
-	server.Config = builder.
+	cfg := builder.
 		MergeDefaults().
 		ProcessFlags().
 		ProcessEnvVars().
@@ -31,27 +31,25 @@ func setServerArgs() error {
 		ReportEnvVars().
 		Final()
 
-	return builder.Err()
+	return cfg, builder.Err()
 }
 
 func main() {
-	if err := setServerArgs(); err != nil {
+	cfg, err := setServerArgs()
+	if err != nil {
 		log.Fatal(err)
 	}
 
 	common.PrintBuildInfo(buildVersion, buildDate, buildCommit)
 
-	prettyConfig, err := json.Marshal(server.Config)
+	prettyConfig, err := json.Marshal(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Printf("server started with %v", string(prettyConfig))
-	if err = server.ReadServerKey(); err != nil {
-		log.Fatal(err)
-	}
 
-	err = server.StartServer()
+	err = server.StartServer(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
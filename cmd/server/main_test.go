@@ -4,7 +4,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/alexey-mavrin/go-musthave-devops/internal/server"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,9 +35,9 @@ func Test_setServerArgs(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := setServerArgs()
+			cfg, err := setServerArgs()
 			tt.wantErr(t, err)
-			assert.EqualValues(t, tt.want, server.Config)
+			assert.EqualValues(t, tt.want, cfg)
 		})
 	}
 }
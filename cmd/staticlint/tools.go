@@ -0,0 +1,13 @@
+//go:build tools
+// +build tools
+
+// main.go is built with go build cmd/staticlint/main.go only, its ignore
+// tag hides the imports from go mod tidy. This file keeps them in go.mod
+package main
+
+import (
+	_ "honnef.co/go/tools/quickfix"
+	_ "honnef.co/go/tools/simple"
+	_ "honnef.co/go/tools/staticcheck"
+	_ "honnef.co/go/tools/stylecheck"
+)
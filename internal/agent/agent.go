@@ -3,58 +3,24 @@ package agent
 
 import (
 	"bytes"
-	"context"
 	crand "crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"runtime"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
 	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 	"github.com/alexey-mavrin/go-musthave-devops/internal/crypt"
-	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
-	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
 
 	"github.com/alexey-mavrin/go-musthave-devops/internal/iproute"
 )
 
-type statData struct {
-	CPUutilLastTime time.Time
-	CPUtime         []float64
-	CPUutilization  []float64
-	mu              sync.Mutex
-	memStats        runtime.MemStats
-	PollCount       int64
-	RandomValue     int
-	TotalMemory     float64
-	FreeMemory      float64
-}
-
-func init() {
-	cpuStat, err := cpu.Info()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	numCPU := len(cpuStat)
-	myStatData.CPUtime = make([]float64, numCPU)
-	myStatData.CPUutilization = make([]float64, numCPU)
-}
-
-var myStatData statData
-
 const (
 	defaultServer = "http://localhost:8080"
 )
@@ -62,19 +28,49 @@ const (
 const (
 	pollInterval   = 2 * time.Second
 	reportInterval = 10 * time.Second
+	spoolSize      = 10 << 20
 )
 
 // ConfigType contains config options for the agent
 type ConfigType struct {
-	ServerAddr     string
-	Key            string
-	CryptoKey      string
-	GRPCServer     string
+	// Labels are added to every metric sent by the agent
+	Labels     map[string]string
+	ServerAddr string
+	Key        string
+	CryptoKey  string
+	GRPCServer string
+	// GRPCCAFile is the CA to check the gRPC server certificate against
+	GRPCCAFile string
+	// GRPCCertFile and GRPCKeyFile are the client certificate for mTLS
+	GRPCCertFile   string
+	GRPCKeyFile    string
 	PollInterval   time.Duration
 	ReportInterval time.Duration
 	useJSON        bool
 	useBatch       bool
 	UseGRPC        bool
+	// GRPCTLS enables TLS for gRPC, it is implied by GRPCCAFile
+	GRPCTLS bool
+	// SpoolDir is the directory to keep batches which failed to be sent,
+	// no spooling if empty
+	SpoolDir string
+	// SpoolSize is the spool size limit in bytes
+	SpoolSize int64
+	// Collectors are the settings of collectors by their names
+	Collectors map[string]CollectorConfig
+	// EnabledCollectors if not nil is the list of the only collectors
+	// to run, it overrides Enabled of Collectors
+	EnabledCollectors []string
+	// HostLabel adds the host label with the agent host name to every metric
+	HostLabel bool
+	// StatsDAddress is the UDP address to accept StatsD metrics on,
+	// no listener if empty
+	StatsDAddress string
+	// AgentID identifies the agent on the server, which signs batches
+	// with Key registered for the ID
+	AgentID string
+	// APIToken is sent as the bearer token if the server requires one
+	APIToken string
 }
 
 var publicServerKey *rsa.PublicKey
@@ -84,6 +80,7 @@ var Config ConfigType = ConfigType{
 	ServerAddr:     defaultServer,
 	PollInterval:   pollInterval,
 	ReportInterval: reportInterval,
+	SpoolSize:      spoolSize,
 	useBatch:       true,
 }
 
@@ -98,154 +95,31 @@ func ReadServerKey() error {
 	return err
 }
 
-func collectPSStats() {
-	m, err := mem.VirtualMemory()
-	if err != nil {
-		log.Println(err)
-	}
-	c, err := cpu.Times(true)
-	timeNow := time.Now()
-	if err != nil {
-		log.Println(err)
-	}
-	myStatData.mu.Lock()
-	timeDiff := timeNow.Sub(myStatData.CPUutilLastTime)
-	myStatData.CPUutilLastTime = timeNow
-	myStatData.TotalMemory = float64(m.Total)
-	myStatData.FreeMemory = float64(m.Free)
-	for n := range c {
-		newCPUTime := c[n].User + c[n].System
-		cpuUtil := (newCPUTime - myStatData.CPUtime[n]) * 1000 / float64(timeDiff.Milliseconds())
-		myStatData.CPUutilization[n] = cpuUtil
-		myStatData.CPUtime[n] = newCPUTime
-	}
-	myStatData.mu.Unlock()
-
-}
-
-func collectStats() {
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-
-	myStatData.mu.Lock()
-	myStatData.PollCount++
-	myStatData.RandomValue = rand.Int()
-	myStatData.memStats = memStats
-	myStatData.mu.Unlock()
-}
-
-// RunCollectStats collects data periodically
-func RunCollectStats() {
-	ticker := time.NewTicker(Config.PollInterval)
-	for {
-		<-ticker.C
-		collectStats()
-	}
-}
-
-// RunCollectPSStats collects data from psutils periodically
-func RunCollectPSStats() {
-	ticker := time.NewTicker(Config.PollInterval)
-	for {
-		<-ticker.C
-		collectPSStats()
-	}
-}
-
-func appendBatch(initial []common.Metrics, name string, data interface{}) []common.Metrics {
-	if initial == nil {
-		log.Print("addBatch: trying to add to nil slice")
-	}
-
-	switch v := data.(type) {
-	case int64:
-		delta := v
-		m := common.Metrics{
-			ID:    name,
-			MType: common.NameCounter,
-			Delta: &delta,
-		}
-		m.StoreHash(Config.Key)
-		return append(initial, m)
-
-	case float64:
-		value := v
-		m := common.Metrics{
-			ID:    name,
-			MType: common.NameGauge,
-			Value: &value,
-		}
-		m.StoreHash(Config.Key)
-		return append(initial, m)
-	case []float64:
-		for item := range v {
-			value := v[item]
-			m := common.Metrics{
-				ID:    fmt.Sprintf("%s%d", name, item),
-				MType: common.NameGauge,
-				Value: &value,
-			}
-			m.StoreHash(Config.Key)
-			initial = append(initial, m)
-		}
-		return initial
-	}
-	return initial
-}
-
 var logOnce sync.Once
 
-func sendBatchGRPC(mm []common.Metrics) error {
-	pList := make([](*pb.Metrics), 0, len(mm))
-	for _, m := range mm {
-		p := grpcint.MetricsToPb(m)
-		if Config.Key != "" {
-			grpcint.StoreHash(p, Config.Key)
+func sendBatch(mm []common.Metrics) error {
+	// the batch may be replayed from the spool, so it is signed
+	// right before sending, with a fresh timestamp and nonce
+	var payload interface{} = mm
+	if Config.Key != "" {
+		e := common.Envelope{Metrics: mm, AgentID: Config.AgentID}
+		if err := e.Sign(Config.Key); err != nil {
+			return err
 		}
-		pList = append(pList, p)
-	}
-
-	req := pb.UpdateMetricesRequest{
-		Count:    int32(len(mm)),
-		Metrices: pList,
-	}
-
-	conn, err := grpc.Dial(
-		Config.GRPCServer,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	mc := pb.NewMetricesClient(conn)
-
-	resp, err := mc.UpdateMetrices(context.Background(), &req)
-	if err != nil {
-		return err
-	}
-	if resp != nil && resp.Error != "" {
-		return errors.New(resp.Error)
+		payload = e
 	}
 
-	return nil
-}
-
-func sendBatch(mm []common.Metrics) error {
 	var body bytes.Buffer
-	if err := json.NewEncoder(&body).Encode(mm); err != nil {
+	if err := json.NewEncoder(&body).Encode(payload); err != nil {
 		return err
 	}
 	url := Config.ServerAddr + "/updates/"
 
 	if publicServerKey != nil {
-		encryptedBytes, err := crypt.EncryptOAEP(
-			sha256.New(),
+		encryptedBytes, err := crypt.EncryptHybrid(
 			crand.Reader,
 			publicServerKey,
-			body.Bytes(),
-			nil)
+			body.Bytes())
 		if err != nil {
 			return err
 		}
@@ -259,6 +133,9 @@ func sendBatch(mm []common.Metrics) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if publicServerKey != nil {
+		req.Header.Set(crypt.EncryptionHeader, crypt.SchemeHybrid)
+	}
 	ip, err := iproute.GetSrcIPURL(url)
 	if err != nil {
 		// if we are unable to do it once, chances are high
@@ -268,6 +145,9 @@ func sendBatch(mm []common.Metrics) error {
 		})
 	}
 	req.Header.Set("X-Real-IP", ip)
+	if Config.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+Config.APIToken)
+	}
 
 	client := &http.Client{}
 
@@ -279,52 +159,60 @@ func sendBatch(mm []common.Metrics) error {
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Sending %s, http status %d", url, resp.StatusCode)
+		// the token may be missing or rotated, the batch is kept
+		auth := resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+		if resp.StatusCode < http.StatusInternalServerError && !auth {
+			return fmt.Errorf("%w: http status %d", errRejected, resp.StatusCode)
+		}
+		return fmt.Errorf("http status %d", resp.StatusCode)
 	}
 
 	return nil
 }
 
 func sendStatsBatch() error {
-	bm := make([]common.Metrics, 0, 100)
-	myStatData.mu.Lock()
-	bm = appendBatch(bm, "PollCount", myStatData.PollCount)
-	bm = appendBatch(bm, "RandomValue", float64(myStatData.RandomValue))
-	bm = appendBatch(bm, "Alloc", float64(myStatData.memStats.Alloc))
-	bm = appendBatch(bm, "BuckHashSys", float64(myStatData.memStats.BuckHashSys))
-	bm = appendBatch(bm, "Frees", float64(myStatData.memStats.Frees))
-	bm = appendBatch(bm, "GCCPUFraction", float64(myStatData.memStats.GCCPUFraction))
-	bm = appendBatch(bm, "GCSys", float64(myStatData.memStats.GCSys))
-	bm = appendBatch(bm, "HeapAlloc", float64(myStatData.memStats.HeapAlloc))
-	bm = appendBatch(bm, "HeapIdle", float64(myStatData.memStats.HeapIdle))
-	bm = appendBatch(bm, "HeapInuse", float64(myStatData.memStats.HeapInuse))
-	bm = appendBatch(bm, "HeapObjects", float64(myStatData.memStats.HeapObjects))
-	bm = appendBatch(bm, "HeapReleased", float64(myStatData.memStats.HeapReleased))
-	bm = appendBatch(bm, "HeapSys", float64(myStatData.memStats.HeapSys))
-	bm = appendBatch(bm, "LastGC", float64(myStatData.memStats.LastGC))
-	bm = appendBatch(bm, "Lookups", float64(myStatData.memStats.Lookups))
-	bm = appendBatch(bm, "MCacheInuse", float64(myStatData.memStats.MCacheInuse))
-	bm = appendBatch(bm, "MCacheSys", float64(myStatData.memStats.MCacheSys))
-	bm = appendBatch(bm, "MSpanInuse", float64(myStatData.memStats.MSpanInuse))
-	bm = appendBatch(bm, "MSpanSys", float64(myStatData.memStats.MSpanSys))
-	bm = appendBatch(bm, "Mallocs", float64(myStatData.memStats.Mallocs))
-	bm = appendBatch(bm, "NextGC", float64(myStatData.memStats.NextGC))
-	bm = appendBatch(bm, "NumForcedGC", float64(myStatData.memStats.NumForcedGC))
-	bm = appendBatch(bm, "NumGC", float64(myStatData.memStats.NumGC))
-	bm = appendBatch(bm, "OtherSys", float64(myStatData.memStats.OtherSys))
-	bm = appendBatch(bm, "PauseTotalNs", float64(myStatData.memStats.PauseTotalNs))
-	bm = appendBatch(bm, "StackInuse", float64(myStatData.memStats.StackInuse))
-	bm = appendBatch(bm, "StackSys", float64(myStatData.memStats.StackSys))
-	bm = appendBatch(bm, "TotalAlloc", float64(myStatData.memStats.TotalAlloc))
-	bm = appendBatch(bm, "Sys", float64(myStatData.memStats.Sys))
-	bm = appendBatch(bm, "TotalMemory", myStatData.TotalMemory)
-	bm = appendBatch(bm, "FreeMemory", myStatData.FreeMemory)
-	bm = appendBatch(bm, "CPUutilization", myStatData.CPUutilization)
-	myStatData.mu.Unlock()
+	if agentStatsD != nil {
+		agentStatsD.flush(stats)
+	}
+	bm, deltas := stats.batch(Config.Labels)
+	stored, err := sendOrSpool(bm)
+	// counters are sent as deltas since the last batch sent or spooled
+	if stored {
+		stats.commit(deltas)
+	}
+	return err
+}
+
+var agentSpool *spool
 
+// sendOrSpool sends the batch, the batch is spooled if sending fails.
+// Spooled batches are sent first to keep the order. It tells if the
+// batch is sent or spooled, an error may be returned in both cases
+func sendOrSpool(bm []common.Metrics) (bool, error) {
+	send := sendBatch
 	if Config.UseGRPC {
-		return sendBatchGRPC(bm)
+		send = sendBatchGRPC
+	}
+	if agentSpool == nil {
+		err := send(bm)
+		return err == nil, err
+	}
+
+	if agentSpool.empty() {
+		err := send(bm)
+		if err == nil || errors.Is(err, errRejected) {
+			return err == nil, err
+		}
+		log.Printf("error sending update, spooling the batch: %v", err)
+		err = agentSpool.push(bm)
+		return err == nil, err
 	}
-	return sendBatch(bm)
+
+	if err := agentSpool.push(bm); err != nil {
+		return false, err
+	}
+	// the spool owns the batch now, it is sent with the next replay
+	return true, agentSpool.replay(send)
 }
 
 // RunSendStats periodically sends statistics to a collector
@@ -342,8 +230,34 @@ func RunSendStats() {
 // RunAgent is the function to start agent operation
 func RunAgent() {
 	rand.Seed(time.Now().UnixNano())
-	go RunCollectStats()
-	go RunCollectPSStats()
+	if Config.HostLabel {
+		host, err := os.Hostname()
+		if err != nil {
+			log.Printf("can't get host name for the host label: %v", err)
+		} else {
+			Config.Labels = common.MergeLabels(Config.Labels, map[string]string{"host": host})
+		}
+	}
+	if Config.SpoolDir != "" {
+		var err error
+		agentSpool, err = newSpool(Config.SpoolDir, Config.SpoolSize)
+		if err != nil {
+			log.Printf("can't use spool %s, failed batches will be lost: %v", Config.SpoolDir, err)
+		}
+	}
+	if Config.StatsDAddress != "" {
+		if err := startStatsD(Config.StatsDAddress); err != nil {
+			log.Fatal(err)
+		}
+	}
+	collectors, err := newCollectors()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range collectors {
+		log.Printf("starting collector %s", c.Name())
+		go runCollector(c, stats)
+	}
 	RunSendStats()
 
 }
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+func TestSendStatsBatchDeltas(t *testing.T) {
+	var deltas []int64
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mm []common.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&mm))
+		for _, m := range mm {
+			if m.ID == "PollCount" && status == http.StatusOK {
+				deltas = append(deltas, *m.Delta)
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	saved := Config
+	Config.ServerAddr = ts.URL
+	Config.UseGRPC = false
+	defer func() { Config = saved }()
+	stats = newMetricsBuffer()
+	poll := func() { stats.Counter("PollCount", 1, nil) }
+
+	poll()
+	poll()
+	require.NoError(t, sendStatsBatch())
+
+	// the delta of the failed send is sent next time
+	poll()
+	status = http.StatusServiceUnavailable
+	assert.Error(t, sendStatsBatch())
+	status = http.StatusOK
+	poll()
+	require.NoError(t, sendStatsBatch())
+
+	require.NoError(t, sendStatsBatch())
+	assert.Equal(t, []int64{2, 2, 0}, deltas)
+}
+
+func TestSendStatsBatchSpooled(t *testing.T) {
+	// authentication errors are retried as the token may be rotated
+	for _, failure := range []int{http.StatusServiceUnavailable, http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(http.StatusText(failure), func(t *testing.T) {
+			testSendStatsBatchSpooled(t, failure)
+		})
+	}
+}
+
+func testSendStatsBatchSpooled(t *testing.T, failure int) {
+	var total int64
+	status := failure
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mm []common.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&mm))
+		for _, m := range mm {
+			if m.ID == "PollCount" && status == http.StatusOK {
+				total += *m.Delta
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	saved := Config
+	Config.ServerAddr = ts.URL
+	Config.UseGRPC = false
+	defer func() { Config = saved }()
+	var err error
+	agentSpool, err = newSpool(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	defer func() { agentSpool = nil }()
+	stats = newMetricsBuffer()
+	poll := func() { stats.Counter("PollCount", 1, nil) }
+
+	poll()
+	require.NoError(t, sendStatsBatch())
+	// the batch is spooled even if the replay fails,
+	// so its delta is not sent again
+	poll()
+	assert.Error(t, sendStatsBatch())
+
+	status = http.StatusOK
+	poll()
+	require.NoError(t, sendStatsBatch())
+	assert.True(t, agentSpool.empty())
+	assert.Equal(t, int64(3), total)
+}
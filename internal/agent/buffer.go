@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+type bufferedGauge struct {
+	labels map[string]string
+	name   string
+	value  float64
+}
+
+type bufferedCounter struct {
+	labels map[string]string
+	name   string
+	delta  int64
+}
+
+// metricsBuffer is the Sink keeping metrics between sends.
+// Gauges keep the last value, counters keep the delta not sent yet
+type metricsBuffer struct {
+	gauges   map[string]*bufferedGauge
+	counters map[string]*bufferedCounter
+	mu       sync.Mutex
+}
+
+func newMetricsBuffer() *metricsBuffer {
+	return &metricsBuffer{
+		gauges:   make(map[string]*bufferedGauge),
+		counters: make(map[string]*bufferedCounter),
+	}
+}
+
+var stats = newMetricsBuffer()
+
+// Gauge sets the gauge value
+func (b *metricsBuffer) Gauge(name string, value float64, labels map[string]string) {
+	key := common.SeriesKey(name, labels)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gauges[key] = &bufferedGauge{name: name, labels: labels, value: value}
+}
+
+// RemoveGauge stops sending the gauge
+func (b *metricsBuffer) RemoveGauge(name string, labels map[string]string) {
+	key := common.SeriesKey(name, labels)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.gauges, key)
+}
+
+// Counter adds delta to the counter
+func (b *metricsBuffer) Counter(name string, delta int64, labels map[string]string) {
+	key := common.SeriesKey(name, labels)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.counters[key]
+	if !ok {
+		c = &bufferedCounter{name: name, labels: labels}
+		b.counters[key] = c
+	}
+	c.delta += delta
+}
+
+// batch returns all buffered metrics with the extra labels added
+// and the counter deltas in it. The deltas stay in the buffer
+// until commit is called with them
+func (b *metricsBuffer) batch(extra map[string]string) ([]common.Metrics, map[string]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	mm := make([]common.Metrics, 0, len(b.counters)+len(b.gauges))
+	deltas := make(map[string]int64, len(b.counters))
+	for _, key := range common.SortedKeys(b.counters) {
+		c := b.counters[key]
+		delta := c.delta
+		deltas[key] = delta
+		mm = append(mm, common.Metrics{
+			ID:     c.name,
+			MType:  common.NameCounter,
+			Delta:  &delta,
+			Labels: common.MergeLabels(extra, c.labels),
+		})
+	}
+	for _, key := range common.SortedKeys(b.gauges) {
+		g := b.gauges[key]
+		value := g.value
+		mm = append(mm, common.Metrics{
+			ID:     g.name,
+			MType:  common.NameGauge,
+			Value:  &value,
+			Labels: common.MergeLabels(extra, g.labels),
+		})
+	}
+	return mm, deltas
+}
+
+// commit subtracts the deltas delivered to the server
+func (b *metricsBuffer) commit(deltas map[string]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, delta := range deltas {
+		if c, ok := b.counters[key]; ok {
+			c.delta -= delta
+		}
+	}
+}
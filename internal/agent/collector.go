@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// Sink receives metrics from collectors
+type Sink interface {
+	// Gauge sets the gauge value
+	Gauge(name string, value float64, labels map[string]string)
+	// Counter adds delta to the counter
+	Counter(name string, delta int64, labels map[string]string)
+	// RemoveGauge stops sending the gauge
+	RemoveGauge(name string, labels map[string]string)
+}
+
+// Collector gathers a group of metrics
+type Collector interface {
+	// Name is the collector name used in the config
+	Name() string
+	// Interval is how often Collect is called,
+	// zero means the agent poll interval
+	Interval() time.Duration
+	// Collect puts the current values into the sink
+	Collect(sink Sink) error
+}
+
+// CollectorConfig holds the settings of one collector
+type CollectorConfig struct {
+	// Enabled overrides the default state of the collector
+	Enabled *bool `json:"enabled"`
+	// Interval overrides the collector interval, e.g. "10s"
+	Interval string `json:"interval"`
+	// Options are collector specific settings
+	Options json.RawMessage `json:"options"`
+}
+
+// interval parses Interval, zero if it is not set
+func (c CollectorConfig) interval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Interval)
+}
+
+// decodeOptions unmarshals Options into v, v is unchanged without options
+func (c CollectorConfig) decodeOptions(v interface{}) error {
+	if len(c.Options) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.Options, v)
+}
+
+// CollectorFactory creates the collector from its config
+type CollectorFactory func(cfg CollectorConfig) (Collector, error)
+
+type registeredCollector struct {
+	factory        CollectorFactory
+	enabledDefault bool
+}
+
+var collectorRegistry = make(map[string]registeredCollector)
+
+// RegisterCollector makes the collector available in the config.
+// enabledDefault is the collector state if the config does not set it
+func RegisterCollector(name string, enabledDefault bool, factory CollectorFactory) {
+	if _, ok := collectorRegistry[name]; ok {
+		panic("collector " + name + " is registered twice")
+	}
+	collectorRegistry[name] = registeredCollector{
+		factory:        factory,
+		enabledDefault: enabledDefault,
+	}
+}
+
+// CollectorNames returns the names of all registered collectors
+func CollectorNames() []string {
+	names := make([]string, 0, len(collectorRegistry))
+	for name := range collectorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectorEnabled tells if the collector is enabled by the config
+func collectorEnabled(name string, reg registeredCollector) bool {
+	if Config.EnabledCollectors != nil {
+		for _, n := range Config.EnabledCollectors {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if cfg, ok := Config.Collectors[name]; ok && cfg.Enabled != nil {
+		return *cfg.Enabled
+	}
+	return reg.enabledDefault
+}
+
+// newCollectors creates all enabled collectors
+func newCollectors() ([]Collector, error) {
+	for name := range Config.Collectors {
+		if _, ok := collectorRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %s", name)
+		}
+	}
+	for _, name := range Config.EnabledCollectors {
+		if _, ok := collectorRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %s", name)
+		}
+	}
+
+	var collectors []Collector
+	for _, name := range CollectorNames() {
+		reg := collectorRegistry[name]
+		if !collectorEnabled(name, reg) {
+			continue
+		}
+		c, err := reg.factory(Config.Collectors[name])
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		collectors = append(collectors, c)
+	}
+	return collectors, nil
+}
+
+// putMetrics puts gauges and counter deltas into the sink
+func putMetrics(sink Sink, mm []common.Metrics) {
+	for _, m := range mm {
+		switch m.MType {
+		case common.NameGauge:
+			sink.Gauge(m.ID, *m.Value, m.Labels)
+		case common.NameCounter:
+			sink.Counter(m.ID, *m.Delta, m.Labels)
+		}
+	}
+}
+
+// runCollector calls Collect periodically
+func runCollector(c Collector, sink Sink) {
+	interval := c.Interval()
+	if interval <= 0 {
+		interval = Config.PollInterval
+	}
+	ticker := time.NewTicker(interval)
+	for {
+		<-ticker.C
+		if err := c.Collect(sink); err != nil {
+			log.Printf("collector %s: %v", c.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"log"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func init() {
+	RegisterCollector("disk", false, newDiskCollector)
+	RegisterCollector("diskio", false, newDiskIOCollector)
+}
+
+// diskCollector reports filesystem usage per mount point
+type diskCollector struct {
+	baseCollector
+	opts struct {
+		// Mounts are the mount points to report, all if empty
+		Mounts nameFilter `json:"mounts"`
+		// Fstypes are the filesystem types to report, all if empty
+		Fstypes nameFilter `json:"fstypes"`
+	}
+}
+
+func newDiskCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("disk", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &diskCollector{baseCollector: base}
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Collect reports usage of every mounted filesystem
+func (c *diskCollector) Collect(sink Sink) error {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		if !c.opts.Mounts.match(p.Mountpoint) || !c.opts.Fstypes.match(p.Fstype) {
+			continue
+		}
+		u, err := disk.Usage(p.Mountpoint)
+		if err != nil {
+			// the mount may be gone or not accessible
+			log.Printf("disk collector: %v", err)
+			continue
+		}
+		labels := map[string]string{
+			"mount":  p.Mountpoint,
+			"device": p.Device,
+			"fstype": p.Fstype,
+		}
+		sink.Gauge("DiskTotal", float64(u.Total), labels)
+		sink.Gauge("DiskFree", float64(u.Free), labels)
+		sink.Gauge("DiskUsed", float64(u.Used), labels)
+		sink.Gauge("DiskUsedPercent", u.UsedPercent, labels)
+		sink.Gauge("DiskInodesTotal", float64(u.InodesTotal), labels)
+		sink.Gauge("DiskInodesFree", float64(u.InodesFree), labels)
+	}
+	return nil
+}
+
+// diskIOCollector reports disk I/O counters per device
+type diskIOCollector struct {
+	baseCollector
+	deltas *deltaTracker
+	opts   struct {
+		// Devices are the devices to report, all if empty
+		Devices nameFilter `json:"devices"`
+	}
+}
+
+func newDiskIOCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("diskio", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &diskIOCollector{baseCollector: base, deltas: newDeltaTracker()}
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Collect reports the increase of the I/O counters since the last call.
+// Times are in milliseconds
+func (c *diskIOCollector) Collect(sink Sink) error {
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return err
+	}
+	for name, io := range counters {
+		if !c.opts.Devices.match(name) {
+			continue
+		}
+		labels := map[string]string{"device": name}
+		c.deltas.counter(sink, "DiskReads", io.ReadCount, labels)
+		c.deltas.counter(sink, "DiskWrites", io.WriteCount, labels)
+		c.deltas.counter(sink, "DiskReadBytes", io.ReadBytes, labels)
+		c.deltas.counter(sink, "DiskWriteBytes", io.WriteBytes, labels)
+		c.deltas.counter(sink, "DiskReadTime", io.ReadTime, labels)
+		c.deltas.counter(sink, "DiskWriteTime", io.WriteTime, labels)
+		c.deltas.counter(sink, "DiskIOTime", io.IoTime, labels)
+		sink.Gauge("DiskIOInProgress", float64(io.IopsInProgress), labels)
+	}
+	return nil
+}
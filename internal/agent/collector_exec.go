@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+func init() {
+	RegisterCollector("exec", false, newExecCollector)
+}
+
+const (
+	execFormatLines = "lines"
+	execFormatJSON  = "json"
+
+	defaultExecTimeout = 10 * time.Second
+	// execWaitDelay is how long the output is read after the command
+	// exits or times out, a child left running may keep it open
+	execWaitDelay = time.Second
+)
+
+// execCommand is a command run by the exec collector
+type execCommand struct {
+	// Name identifies the command in the self-metrics
+	Name string `json:"name"`
+	// Command is the program and its arguments
+	Command []string `json:"command"`
+	// Timeout kills the command if it runs longer, e.g. "5s"
+	Timeout string `json:"timeout"`
+	// Format of the output, "lines" (default) or "json"
+	Format string `json:"format"`
+
+	timeout time.Duration
+}
+
+// execCollector runs commands and reports the metrics they print.
+// Each command reports ExecDuration gauge and ExecFailures counter
+// labelled with the command name
+type execCollector struct {
+	baseCollector
+	opts struct {
+		Commands []*execCommand `json:"commands"`
+	}
+}
+
+func newExecCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("exec", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &execCollector{baseCollector: base}
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	for i, cmd := range c.opts.Commands {
+		if len(cmd.Command) == 0 {
+			return nil, fmt.Errorf("exec collector: command %d is empty", i)
+		}
+		if cmd.Name == "" {
+			cmd.Name = cmd.Command[0]
+		}
+		switch cmd.Format {
+		case "":
+			cmd.Format = execFormatLines
+		case execFormatLines, execFormatJSON:
+		default:
+			return nil, fmt.Errorf("exec collector: %s: unknown format %s", cmd.Name, cmd.Format)
+		}
+		cmd.timeout = defaultExecTimeout
+		if cmd.Timeout != "" {
+			if cmd.timeout, err = time.ParseDuration(cmd.Timeout); err != nil {
+				return nil, fmt.Errorf("exec collector: %s: %w", cmd.Name, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Collect runs the commands one by one. A failed command reports
+// none of its metrics
+func (c *execCollector) Collect(sink Sink) error {
+	for _, cmd := range c.opts.Commands {
+		labels := map[string]string{"command": cmd.Name}
+		start := time.Now()
+		mm, err := cmd.run()
+		sink.Gauge("ExecDuration", time.Since(start).Seconds(), labels)
+		if err != nil {
+			log.Printf("exec collector: %s: %v", cmd.Name, err)
+			sink.Counter("ExecFailures", 1, labels)
+			continue
+		}
+		sink.Counter("ExecFailures", 0, labels)
+		putMetrics(sink, mm)
+	}
+	return nil
+}
+
+// run runs the command and parses its output
+func (cmd *execCommand) run() ([]common.Metrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	proc := exec.CommandContext(ctx, cmd.Command[0], cmd.Command[1:]...)
+	proc.Stderr = &stderr
+	proc.WaitDelay = execWaitDelay
+	out, err := proc.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %v", cmd.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if cmd.Format == execFormatJSON {
+		return parseExecJSON(out)
+	}
+	return parseExecLines(out)
+}
+
+// parseExecJSON parses a JSON array of metrics as sent to the server.
+// Counter deltas are added to the agent counters
+func parseExecJSON(out []byte) ([]common.Metrics, error) {
+	var mm []common.Metrics
+	if err := json.Unmarshal(out, &mm); err != nil {
+		return nil, err
+	}
+	for _, m := range mm {
+		if err := checkExecMetric(m); err != nil {
+			return nil, err
+		}
+	}
+	return mm, nil
+}
+
+// parseExecLines parses "name type value" lines, the name may have
+// labels like CPUutilization{cpu="0"}. Empty lines and lines
+// starting with # are skipped. Counter values are deltas
+func parseExecLines(out []byte) ([]common.Metrics, error) {
+	var mm []common.Metrics
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parseExecLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		mm = append(mm, m)
+	}
+	return mm, sc.Err()
+}
+
+func parseExecLine(line string) (common.Metrics, error) {
+	var m common.Metrics
+	// label values may contain spaces, so the type and the value
+	// are the last two fields
+	i := strings.LastIndexAny(line, " \t")
+	if i < 0 {
+		return m, errors.New("expected name, type and value")
+	}
+	value := line[i+1:]
+	rest := strings.TrimRight(line[:i], " \t")
+	j := strings.LastIndexAny(rest, " \t")
+	if j < 0 {
+		return m, errors.New("expected name, type and value")
+	}
+	m.MType = rest[j+1:]
+	key := strings.TrimSpace(rest[:j])
+
+	var err error
+	if m.ID, m.Labels, err = common.ParseSeriesKey(key); err != nil {
+		return m, err
+	}
+	switch m.MType {
+	case common.NameGauge:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Value = &v
+	case common.NameCounter:
+		d, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Delta = &d
+	}
+	return m, checkExecMetric(m)
+}
+
+// checkExecMetric checks the metric can be sent. A metric the server
+// refuses or a value JSON can't encode would stay in the buffer and
+// break every later report
+func checkExecMetric(m common.Metrics) error {
+	if err := common.CheckName(m.ID); err != nil {
+		return err
+	}
+	if err := common.CheckLabels(m.Labels); err != nil {
+		return err
+	}
+	switch {
+	case m.MType == common.NameGauge && m.Value != nil:
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return fmt.Errorf("%s: value is not finite", m.ID)
+		}
+	case m.MType == common.NameCounter && m.Delta != nil:
+	case m.MType == common.NameGauge || m.MType == common.NameCounter:
+		return fmt.Errorf("%s: no value", m.ID)
+	default:
+		return fmt.Errorf("%s: unknown type %s", m.ID, m.MType)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+func TestParseExecLines(t *testing.T) {
+	out := `# queue stats
+QueueLength gauge 12.5
+
+Processed{queue="in box"} counter 7
+`
+	mm, err := parseExecLines([]byte(out))
+	require.NoError(t, err)
+	require.Len(t, mm, 2)
+	assert.Equal(t, "QueueLength", mm[0].ID)
+	assert.Equal(t, 12.5, *mm[0].Value)
+	assert.Equal(t, common.NameCounter, mm[1].MType)
+	assert.Equal(t, map[string]string{"queue": "in box"}, mm[1].Labels)
+	assert.Equal(t, int64(7), *mm[1].Delta)
+
+	for _, bad := range []string{
+		"QueueLength 12",
+		"QueueLength histogram 12",
+		"Processed counter 1.5",
+		`Processed{queue="x} counter 1`,
+		"QueueLength gauge NaN",
+		"QueueLength gauge -Inf",
+		`Queue"Length gauge 1`,
+		`Processed{a="1",b="2",c-d="3"} counter 1`,
+	} {
+		_, err := parseExecLines([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseExecJSON(t *testing.T) {
+	mm, err := parseExecJSON([]byte(`[{"id":"A","type":"gauge","value":1},{"id":"B","type":"counter","delta":2,"labels":{"k":"v"}}]`))
+	require.NoError(t, err)
+	require.Len(t, mm, 2)
+	assert.Equal(t, "B", mm[1].ID)
+
+	_, err = parseExecJSON([]byte(`[{"id":"A","type":"counter","value":1}]`))
+	assert.Error(t, err)
+	_, err = parseExecJSON([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestExecCollector(t *testing.T) {
+	c := newTestCollector(t, "exec", `{"commands": [
+		{"name": "lines", "command": ["sh", "-c", "echo 'Users gauge 3'; echo 'Logins counter 2'"]},
+		{"command": ["echo", "[{\"id\":\"Jobs\",\"type\":\"gauge\",\"value\":5}]"], "format": "json"},
+		{"name": "slow", "command": ["sleep", "5"], "timeout": "50ms"},
+		{"name": "orphan", "command": ["sh", "-c", "sleep 5 & echo 'Orphans gauge 1'"]},
+		{"name": "failing", "command": ["sh", "-c", "echo 'Users gauge 100'; exit 1"]},
+		{"name": "garbage", "command": ["echo", "Users"]}
+	]}`)
+
+	mm := collect(t, c)
+	assert.Equal(t, 3.0, *mm["Users"].Value)
+	assert.Equal(t, int64(2), *mm["Logins"].Delta)
+	assert.Equal(t, 5.0, *mm["Jobs"].Value)
+	assert.Equal(t, int64(0), *mm[`ExecFailures{command="lines"}`].Delta)
+	assert.Equal(t, int64(0), *mm[`ExecFailures{command="echo"}`].Delta)
+	for _, name := range []string{"slow", "orphan", "failing", "garbage"} {
+		assert.Equal(t, int64(1), *mm[`ExecFailures{command="`+name+`"}`].Delta, name)
+	}
+	assert.Less(t, *mm[`ExecDuration{command="slow"}`].Value, 1.0)
+	// the child keeping the output open doesn't hang the collection
+	assert.Less(t, *mm[`ExecDuration{command="orphan"}`].Value, 3.0)
+
+	for _, bad := range []string{
+		`{"commands": [{"command": []}]}`,
+		`{"commands": [{"command": ["true"], "format": "xml"}]}`,
+		`{"commands": [{"command": ["true"], "timeout": "soon"}]}`,
+	} {
+		_, err := collectorRegistry["exec"].factory(CollectorConfig{Options: []byte(bad)})
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// copyTree copies the fixture tree into a temp dir, so tests can change it
+func copyTree(t *testing.T, src string) string {
+	dir := t.TempDir()
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0700)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, rel), buf, 0600)
+	})
+	require.NoError(t, err)
+	return dir
+}
+
+// fakeProc makes gopsutil read a copy of testdata/proc instead of /proc
+func fakeProc(t *testing.T) string {
+	dir := copyTree(t, "testdata/proc")
+	t.Setenv("HOST_PROC", dir)
+	return dir
+}
+
+// collect runs the collector and returns the collected metrics by key
+func collect(t *testing.T, c Collector) map[string]common.Metrics {
+	b := newMetricsBuffer()
+	require.NoError(t, c.Collect(b))
+	mm, _ := b.batch(nil)
+	ret := make(map[string]common.Metrics)
+	for _, m := range mm {
+		ret[m.Key()] = m
+	}
+	return ret
+}
+
+func newTestCollector(t *testing.T, name, options string) Collector {
+	cfg := CollectorConfig{}
+	if options != "" {
+		cfg.Options = []byte(options)
+	}
+	c, err := collectorRegistry[name].factory(cfg)
+	require.NoError(t, err)
+	return c
+}
+
+func TestDiskCollector(t *testing.T) {
+	dir := fakeProc(t)
+	// the usage of a temp directory, not of the host root
+	mount := t.TempDir()
+	mountinfo := fmt.Sprintf(`22 1 8:1 / %s rw,relatime shared:1 - ext4 /dev/sda1 rw
+25 22 8:17 / /nonexistent-mount rw,relatime shared:2 - ext4 /dev/sdb1 rw
+26 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:3 - proc proc rw
+`, mount)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1/mountinfo"), []byte(mountinfo), 0600))
+	mm := collect(t, newTestCollector(t, "disk", ""))
+
+	// /proc is not a block filesystem, /nonexistent-mount can't be read
+	require.Len(t, mm, 6)
+	m, ok := mm[`DiskTotal{device="/dev/sda1",fstype="ext4",mount="`+mount+`"}`]
+	require.True(t, ok)
+	assert.Equal(t, common.NameGauge, m.MType)
+	assert.Greater(t, *m.Value, 0.0)
+
+	mm = collect(t, newTestCollector(t, "disk", `{"mounts": ["/home"]}`))
+	assert.Empty(t, mm)
+}
+
+func TestDiskIOCollector(t *testing.T) {
+	dir := fakeProc(t)
+	c := newTestCollector(t, "diskio", `{"devices": ["sda"]}`)
+
+	// the first call only sets the base for counters
+	mm := collect(t, c)
+	require.Len(t, mm, 1)
+	assert.Equal(t, 1.0, *mm[`DiskIOInProgress{device="sda"}`].Value)
+
+	err := os.WriteFile(filepath.Join(dir, "diskstats"),
+		[]byte("   8       0 sda 150 10 3000 60 200 20 4000 80 0 130 140 0 0 0 0\n"), 0600)
+	require.NoError(t, err)
+	mm = collect(t, c)
+	assert.Equal(t, int64(50), *mm[`DiskReads{device="sda"}`].Delta)
+	assert.Equal(t, int64(1000*512), *mm[`DiskReadBytes{device="sda"}`].Delta)
+	assert.Equal(t, int64(0), *mm[`DiskWrites{device="sda"}`].Delta)
+	assert.Equal(t, int64(10), *mm[`DiskIOTime{device="sda"}`].Delta)
+	assert.Equal(t, common.NameCounter, mm[`DiskIOTime{device="sda"}`].MType)
+}
+
+func TestNetCollector(t *testing.T) {
+	dir := fakeProc(t)
+	c := newTestCollector(t, "net", `{"interfaces": ["eth0"]}`)
+	assert.Empty(t, collect(t, c))
+
+	dev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:  250000    2500    1    2    0     0          0         0   100500    1001    3    5    0     0       0          0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "net/dev"), []byte(dev), 0600))
+
+	mm := collect(t, c)
+	require.Len(t, mm, 8)
+	assert.Equal(t, int64(50000), *mm[`NetBytesRecv{interface="eth0"}`].Delta)
+	assert.Equal(t, int64(500), *mm[`NetBytesSent{interface="eth0"}`].Delta)
+	assert.Equal(t, int64(1), *mm[`NetDropOut{interface="eth0"}`].Delta)
+	assert.Equal(t, int64(0), *mm[`NetErrIn{interface="eth0"}`].Delta)
+}
+
+func TestLoadCollector(t *testing.T) {
+	fakeProc(t)
+	mm := collect(t, newTestCollector(t, "load", ""))
+	require.Len(t, mm, 3)
+	assert.Equal(t, 0.52, *mm["Load1"].Value)
+	assert.Equal(t, 0.58, *mm["Load5"].Value)
+	assert.Equal(t, 0.59, *mm["Load15"].Value)
+}
+
+func TestSwapCollector(t *testing.T) {
+	dir := fakeProc(t)
+	c := newTestCollector(t, "swap", "")
+	mm := collect(t, c)
+	assert.Equal(t, float64(2000000*1024), *mm["SwapTotal"].Value)
+	assert.Equal(t, float64(500000*1024), *mm["SwapUsed"].Value)
+
+	err := os.WriteFile(filepath.Join(dir, "vmstat"), []byte("pswpin 110\npswpout 200\n"), 0600)
+	require.NoError(t, err)
+	mm = collect(t, c)
+	assert.Equal(t, int64(10*4*1024), *mm["SwapIn"].Delta)
+	assert.Equal(t, int64(0), *mm["SwapOut"].Delta)
+}
+
+func TestUptimeCollector(t *testing.T) {
+	dir := fakeProc(t)
+	// gopsutil reads btime from stat or uptime in containers
+	boot := time.Now().Add(-time.Hour).Unix()
+	err := os.WriteFile(filepath.Join(dir, "stat"), []byte(fmt.Sprintf("btime %d\n", boot)), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "uptime"), []byte("3600.00 3000.00\n"), 0600)
+	require.NoError(t, err)
+
+	mm := collect(t, newTestCollector(t, "uptime", ""))
+	assert.InDelta(t, 3600, *mm["Uptime"].Value, 5)
+}
+
+func TestProcessCollector(t *testing.T) {
+	dir := copyTree(t, "../procfs/testdata/proc")
+	c := newTestCollector(t, "process", fmt.Sprintf(`{"proc_root": %q, "names": ["^my "]}`, dir))
+
+	mm := collect(t, c)
+	require.Len(t, mm, 6)
+	labels := `{name="my (app) srv"}`
+	assert.Equal(t, 1.0, *mm["ProcessCount"+labels].Value)
+	assert.Equal(t, float64(10240*1024), *mm["ProcessRSS"+labels].Value)
+	assert.Equal(t, 7.0, *mm["ProcessThreads"+labels].Value)
+	assert.Equal(t, 3.0, *mm["ProcessOpenFDs"+labels].Value)
+
+	stat := "42 (my (app) srv) S 1 42 42 0 -1 4194560 1500 0 0 0 260 60 0 0 20 0 7 0 1000 104857600 2560 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "42/stat"), []byte(stat), 0600))
+	mm = collect(t, c)
+	assert.Equal(t, int64(200), *mm["ProcessCPUTime"+labels].Delta)
+	assert.Equal(t, int64(0), *mm["ProcessReadBytes"+labels].Delta)
+
+	// io is not readable, the process is selected by pid
+	c = newTestCollector(t, "process", fmt.Sprintf(`{"proc_root": %q, "pids": [43, 44]}`, dir))
+	mm = collect(t, c)
+	require.Len(t, mm, 3)
+	assert.Equal(t, 2.0, *mm[`ProcessThreads{name="nginx"}`].Value)
+
+	// the processes with the same name are summed up,
+	// a restarted process doesn't make a new series
+	c = newTestCollector(t, "process", fmt.Sprintf(`{"proc_root": %q, "names": ["^nginx$"]}`, dir))
+	collect(t, c)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "45"), 0700))
+	stat = "45 (nginx) S 1 45 45 0 -1 4194560 100 0 0 0 90 10 0 0 20 0 3 0 3000 52428800 1024 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "45/stat"), []byte(stat), 0600))
+	mm = collect(t, c)
+	require.Len(t, mm, 4)
+	assert.Equal(t, 2.0, *mm[`ProcessCount{name="nginx"}`].Value)
+	assert.Equal(t, 5.0, *mm[`ProcessThreads{name="nginx"}`].Value)
+	// the base of the new process is set, the old one is not changed
+	assert.Equal(t, int64(0), *mm[`ProcessCPUTime{name="nginx"}`].Delta)
+
+	// the group with no processes left is zeroed once
+	for _, pid := range []string{"43", "44", "45"} {
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, pid)))
+	}
+	mm = collect(t, c)
+	require.Len(t, mm, 3)
+	assert.Equal(t, 0.0, *mm[`ProcessCount{name="nginx"}`].Value)
+	assert.Equal(t, 0.0, *mm[`ProcessThreads{name="nginx"}`].Value)
+	assert.Empty(t, collect(t, c))
+
+	_, err := collectorRegistry["process"].factory(CollectorConfig{Options: []byte(`{"names": ["("]}`)})
+	assert.Error(t, err)
+}
+
+func TestCgroupCollector(t *testing.T) {
+	for v, path := range map[string]string{"v1": "app", "v2": "app.slice"} {
+		t.Run(v, func(t *testing.T) {
+			root := "../procfs/testdata/cgroup/" + v
+			c := newTestCollector(t, "cgroup", fmt.Sprintf(`{"sys_root": %q, "paths": [%q]}`, root, path))
+			labels := fmt.Sprintf(`{cgroup=%q}`, path)
+
+			// counters are reported from the second call
+			mm := collect(t, c)
+			assert.Equal(t, 104857600.0, *mm["CgroupMemoryUsage"+labels].Value)
+			assert.Equal(t, 73400320.0, *mm["CgroupMemoryRSS"+labels].Value)
+			assert.NotContains(t, mm, "CgroupCPUUsage"+labels)
+			// v1 memory limit is not set
+			_, limited := mm["CgroupMemoryLimit"+labels]
+			assert.Equal(t, v == "v2", limited)
+
+			mm = collect(t, c)
+			assert.Equal(t, int64(0), *mm["CgroupCPUUsage"+labels].Delta)
+			devLabels := fmt.Sprintf(`{cgroup=%q,device="8:0"}`, path)
+			assert.Equal(t, int64(0), *mm["CgroupIOReadBytes"+devLabels].Delta)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func init() {
+	RegisterCollector("net", false, newNetCollector)
+}
+
+// netCollector reports network interface counters
+type netCollector struct {
+	baseCollector
+	deltas *deltaTracker
+	opts   struct {
+		// Interfaces are the interfaces to report, all if empty
+		Interfaces nameFilter `json:"interfaces"`
+	}
+}
+
+func newNetCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("net", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &netCollector{baseCollector: base, deltas: newDeltaTracker()}
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Collect reports the increase of the interface counters since the last call
+func (c *netCollector) Collect(sink Sink) error {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return err
+	}
+	for _, io := range counters {
+		if !c.opts.Interfaces.match(io.Name) {
+			continue
+		}
+		labels := map[string]string{"interface": io.Name}
+		c.deltas.counter(sink, "NetBytesSent", io.BytesSent, labels)
+		c.deltas.counter(sink, "NetBytesRecv", io.BytesRecv, labels)
+		c.deltas.counter(sink, "NetPacketsSent", io.PacketsSent, labels)
+		c.deltas.counter(sink, "NetPacketsRecv", io.PacketsRecv, labels)
+		c.deltas.counter(sink, "NetErrIn", io.Errin, labels)
+		c.deltas.counter(sink, "NetErrOut", io.Errout, labels)
+		c.deltas.counter(sink, "NetDropIn", io.Dropin, labels)
+		c.deltas.counter(sink, "NetDropOut", io.Dropout, labels)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/procfs"
+)
+
+func init() {
+	RegisterCollector("process", false, newProcessCollector)
+	RegisterCollector("cgroup", false, newCgroupCollector)
+}
+
+// processCollector reports per-process metrics read from procfs,
+// summed up by the process name
+type processCollector struct {
+	baseCollector
+	names []*regexp.Regexp
+	last  map[int]processSample
+	// groups are the groups reported last time by the name
+	groups map[string]*processGroup
+	opts   struct {
+		// ProcRoot is the procfs mount point
+		ProcRoot string `json:"proc_root"`
+		// PIDs are the processes to report
+		PIDs []int `json:"pids"`
+		// Names are regular expressions matched against process names.
+		// The agent process is reported if neither PIDs nor Names are set
+		Names []string `json:"names"`
+	}
+}
+
+func newProcessCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("process", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &processCollector{baseCollector: base, last: make(map[int]processSample)}
+	c.opts.ProcRoot = "/proc"
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	for _, n := range c.opts.Names {
+		re, err := regexp.Compile(n)
+		if err != nil {
+			return nil, fmt.Errorf("process collector: %w", err)
+		}
+		c.names = append(c.names, re)
+	}
+	if len(c.opts.PIDs) == 0 && len(c.names) == 0 {
+		c.opts.PIDs = []int{os.Getpid()}
+	}
+	return c, nil
+}
+
+// selected returns the stats of the processes to report
+func (c *processCollector) selected() ([]procfs.ProcStat, error) {
+	pids := c.opts.PIDs
+	if len(c.names) > 0 {
+		all, err := procfs.ListPIDs(c.opts.ProcRoot)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(append([]int{}, pids...), all...)
+	}
+
+	var ret []procfs.ProcStat
+	seen := make(map[int]bool)
+	for i, pid := range pids {
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		st, err := procfs.ReadProcStat(c.opts.ProcRoot, pid)
+		if err != nil {
+			// the process may be gone
+			continue
+		}
+		if i < len(c.opts.PIDs) || c.matchName(st.Comm) {
+			ret = append(ret, st)
+		}
+	}
+	return ret, nil
+}
+
+func (c *processCollector) matchName(name string) bool {
+	for _, re := range c.names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// processGroup sums the metrics of the processes with the same name
+type processGroup struct {
+	count, vsize, threads float64
+	rss, swap, fds        float64
+	hasStatus, hasFDs     bool
+	// counters are the increases since the last call by the metric name
+	counters map[string]int64
+}
+
+// processSample is the cumulative values of the process read last time
+type processSample struct {
+	name   string
+	values map[string]uint64
+}
+
+// Collect reports memory, threads and open files of the processes,
+// and the increase of CPU time (milliseconds) and IO since the last call.
+// The processes are labelled by name, the ones with the same name are
+// summed up and counted in ProcessCount, so restarts don't create
+// new series. The gauges of a group with no processes left are
+// reported as zeros
+func (c *processCollector) Collect(sink Sink) error {
+	procs, err := c.selected()
+	if err != nil {
+		return err
+	}
+	groups := make(map[string]*processGroup)
+	alive := make(map[int]processSample)
+	for _, st := range procs {
+		g, ok := groups[st.Comm]
+		if !ok {
+			g = &processGroup{counters: make(map[string]int64)}
+			groups[st.Comm] = g
+		}
+		g.count++
+		g.vsize += float64(st.VSize)
+		g.threads += float64(st.NumThreads)
+
+		values := map[string]uint64{
+			"ProcessCPUTime": (st.UTime + st.STime) * 1000 / procfs.UserHZ,
+		}
+		if status, err := procfs.ReadProcStatus(c.opts.ProcRoot, st.PID); err == nil {
+			g.hasStatus = true
+			g.rss += float64(status.VmRSS)
+			g.swap += float64(status.VmSwap)
+		}
+		// fd and io are readable only by the process owner
+		if fds, err := procfs.CountFDs(c.opts.ProcRoot, st.PID); err == nil {
+			g.hasFDs = true
+			g.fds += float64(fds)
+		}
+		if io, err := procfs.ReadProcIO(c.opts.ProcRoot, st.PID); err == nil {
+			values["ProcessReadBytes"] = io.ReadBytes
+			values["ProcessWriteBytes"] = io.WriteBytes
+		}
+		alive[st.PID] = processSample{name: st.Comm, values: values}
+
+		// the first values of a process only set the base,
+		// a reused pid is a new process
+		prev, ok := c.last[st.PID]
+		if !ok || prev.name != st.Comm {
+			continue
+		}
+		for name, value := range values {
+			last, ok := prev.values[name]
+			switch {
+			case !ok:
+			case value < last:
+				g.counters[name] += int64(value)
+			default:
+				g.counters[name] += int64(value - last)
+			}
+		}
+	}
+	// forget exited processes
+	c.last = alive
+
+	// the sink keeps the last values of the groups gone,
+	// so they are zeroed once
+	reported := make(map[string]*processGroup, len(groups))
+	for name, g := range c.groups {
+		if _, ok := groups[name]; !ok {
+			reported[name] = &processGroup{hasStatus: g.hasStatus, hasFDs: g.hasFDs}
+		}
+	}
+	for name, g := range groups {
+		reported[name] = g
+	}
+	c.groups = groups
+
+	for _, name := range common.SortedKeys(reported) {
+		g := reported[name]
+		labels := map[string]string{"name": name}
+		sink.Gauge("ProcessCount", g.count, labels)
+		sink.Gauge("ProcessVirtualMemory", g.vsize, labels)
+		sink.Gauge("ProcessThreads", g.threads, labels)
+		if g.hasStatus {
+			sink.Gauge("ProcessRSS", g.rss, labels)
+			sink.Gauge("ProcessSwap", g.swap, labels)
+		}
+		if g.hasFDs {
+			sink.Gauge("ProcessOpenFDs", g.fds, labels)
+		}
+		for _, counter := range common.SortedKeys(g.counters) {
+			sink.Counter(counter, g.counters[counter], labels)
+		}
+	}
+	return nil
+}
+
+// cgroupCollector reports container CPU, memory and IO usage
+// from cgroup v1 or v2 hierarchy
+type cgroupCollector struct {
+	baseCollector
+	deltas *deltaTracker
+	opts   struct {
+		// SysRoot is the cgroup filesystem mount point
+		SysRoot string `json:"sys_root"`
+		// Paths are the cgroups to report relative to SysRoot,
+		// the root cgroup (the container itself) if empty
+		Paths []string `json:"paths"`
+	}
+}
+
+func newCgroupCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("cgroup", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &cgroupCollector{baseCollector: base, deltas: newDeltaTracker()}
+	c.opts.SysRoot = "/sys/fs/cgroup"
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+	if len(c.opts.Paths) == 0 {
+		c.opts.Paths = []string{"/"}
+	}
+	return c, nil
+}
+
+// Collect reports memory usage and the increase of CPU time
+// (microseconds) and IO since the last call
+func (c *cgroupCollector) Collect(sink Sink) error {
+	for _, path := range c.opts.Paths {
+		st, err := procfs.ReadCgroup(c.opts.SysRoot, path)
+		if err != nil {
+			log.Printf("cgroup collector: %v", err)
+			continue
+		}
+		labels := map[string]string{"cgroup": path}
+		c.deltas.counter(sink, "CgroupCPUUsage", st.CPUUsage, labels)
+		c.deltas.counter(sink, "CgroupCPUUser", st.CPUUser, labels)
+		c.deltas.counter(sink, "CgroupCPUSystem", st.CPUSystem, labels)
+		c.deltas.counter(sink, "CgroupThrottledPeriods", st.ThrottledPeriods, labels)
+		c.deltas.counter(sink, "CgroupThrottledTime", st.ThrottledTime, labels)
+		sink.Gauge("CgroupMemoryUsage", float64(st.MemoryUsage), labels)
+		if st.MemoryLimit > 0 {
+			sink.Gauge("CgroupMemoryLimit", float64(st.MemoryLimit), labels)
+		}
+		sink.Gauge("CgroupMemoryRSS", float64(st.MemoryRSS), labels)
+		sink.Gauge("CgroupMemoryCache", float64(st.MemoryCache), labels)
+
+		for dev, io := range st.IO {
+			ioLabels := map[string]string{"cgroup": path, "device": dev}
+			c.deltas.counter(sink, "CgroupIOReadBytes", io.ReadBytes, ioLabels)
+			c.deltas.counter(sink, "CgroupIOWriteBytes", io.WriteBytes, ioLabels)
+			c.deltas.counter(sink, "CgroupIOReads", io.Reads, ioLabels)
+			c.deltas.counter(sink, "CgroupIOWrites", io.Writes, ioLabels)
+		}
+	}
+	return nil
+}
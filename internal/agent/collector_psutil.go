@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func init() {
+	RegisterCollector("psutil", true, newPSUtilCollector)
+}
+
+// psutilCollector reports the host memory and per-CPU utilization
+type psutilCollector struct {
+	lastTime time.Time
+	cpuTime  []float64
+	interval time.Duration
+}
+
+func newPSUtilCollector(cfg CollectorConfig) (Collector, error) {
+	interval, err := cfg.interval()
+	if err != nil {
+		return nil, err
+	}
+	return &psutilCollector{interval: interval}, nil
+}
+
+// Name returns the collector name
+func (c *psutilCollector) Name() string {
+	return "psutil"
+}
+
+// Interval returns the collect interval
+func (c *psutilCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect reads memory and CPU times. CPU utilization is reported
+// from the second call, as it needs the previous CPU times
+func (c *psutilCollector) Collect(sink Sink) error {
+	m, err := mem.VirtualMemory()
+	if err != nil {
+		return err
+	}
+	sink.Gauge("TotalMemory", float64(m.Total), nil)
+	sink.Gauge("FreeMemory", float64(m.Free), nil)
+
+	times, err := cpu.Times(true)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	timeDiff := now.Sub(c.lastTime).Seconds()
+	first := c.cpuTime == nil || len(c.cpuTime) != len(times)
+	if first {
+		c.cpuTime = make([]float64, len(times))
+	}
+	for n, t := range times {
+		cpuTime := t.User + t.System
+		if !first && timeDiff > 0 {
+			// per-CPU values are labelled with the CPU number
+			sink.Gauge("CPUutilization", (cpuTime-c.cpuTime[n])/timeDiff,
+				map[string]string{"cpu": strconv.Itoa(n)})
+		}
+		c.cpuTime[n] = cpuTime
+	}
+	c.lastTime = now
+	return nil
+}
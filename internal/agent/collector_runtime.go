@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"math/rand"
+	"runtime"
+	"time"
+)
+
+func init() {
+	RegisterCollector("runtime", true, newRuntimeCollector)
+}
+
+// runtimeCollector reports runtime.MemStats of the agent,
+// PollCount and RandomValue
+type runtimeCollector struct {
+	interval time.Duration
+}
+
+func newRuntimeCollector(cfg CollectorConfig) (Collector, error) {
+	interval, err := cfg.interval()
+	if err != nil {
+		return nil, err
+	}
+	return &runtimeCollector{interval: interval}, nil
+}
+
+// Name returns the collector name
+func (c *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+// Interval returns the collect interval
+func (c *runtimeCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect reads runtime.MemStats
+func (c *runtimeCollector) Collect(sink Sink) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	sink.Counter("PollCount", 1, nil)
+	sink.Gauge("RandomValue", float64(rand.Int()), nil)
+	sink.Gauge("Alloc", float64(m.Alloc), nil)
+	sink.Gauge("BuckHashSys", float64(m.BuckHashSys), nil)
+	sink.Gauge("Frees", float64(m.Frees), nil)
+	sink.Gauge("GCCPUFraction", m.GCCPUFraction, nil)
+	sink.Gauge("GCSys", float64(m.GCSys), nil)
+	sink.Gauge("HeapAlloc", float64(m.HeapAlloc), nil)
+	sink.Gauge("HeapIdle", float64(m.HeapIdle), nil)
+	sink.Gauge("HeapInuse", float64(m.HeapInuse), nil)
+	sink.Gauge("HeapObjects", float64(m.HeapObjects), nil)
+	sink.Gauge("HeapReleased", float64(m.HeapReleased), nil)
+	sink.Gauge("HeapSys", float64(m.HeapSys), nil)
+	sink.Gauge("LastGC", float64(m.LastGC), nil)
+	sink.Gauge("Lookups", float64(m.Lookups), nil)
+	sink.Gauge("MCacheInuse", float64(m.MCacheInuse), nil)
+	sink.Gauge("MCacheSys", float64(m.MCacheSys), nil)
+	sink.Gauge("MSpanInuse", float64(m.MSpanInuse), nil)
+	sink.Gauge("MSpanSys", float64(m.MSpanSys), nil)
+	sink.Gauge("Mallocs", float64(m.Mallocs), nil)
+	sink.Gauge("NextGC", float64(m.NextGC), nil)
+	sink.Gauge("NumForcedGC", float64(m.NumForcedGC), nil)
+	sink.Gauge("NumGC", float64(m.NumGC), nil)
+	sink.Gauge("OtherSys", float64(m.OtherSys), nil)
+	sink.Gauge("PauseTotalNs", float64(m.PauseTotalNs), nil)
+	sink.Gauge("StackInuse", float64(m.StackInuse), nil)
+	sink.Gauge("StackSys", float64(m.StackSys), nil)
+	sink.Gauge("TotalAlloc", float64(m.TotalAlloc), nil)
+	sink.Gauge("Sys", float64(m.Sys), nil)
+	return nil
+}
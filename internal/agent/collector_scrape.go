@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/promtext"
+)
+
+func init() {
+	RegisterCollector("scrape", false, newScrapeCollector)
+}
+
+const defaultScrapeTimeout = 10 * time.Second
+
+// scrapeTarget is an endpoint exposing metrics in Prometheus format
+type scrapeTarget struct {
+	// URL is the metrics endpoint, e.g. http://localhost:9100/metrics
+	URL string `json:"url"`
+	// Labels are added to the scraped metrics and override them.
+	// The instance label is the URL host if not set
+	Labels map[string]string `json:"labels"`
+}
+
+// scrapeCollector fetches Prometheus metrics from targets.
+//
+// Gauges and untyped metrics become gauges. Counters become agent
+// counters with the increase since the last scrape, truncated to integers.
+// Histograms and summaries are flattened into series of the same names
+// as in the exposition:
+//   - name_bucket (with the le label) and name_count become counters,
+//   - name_sum becomes a gauge with the cumulative sum,
+//   - summary quantiles become the gauge name with the quantile label.
+//
+// NaN and infinite values are skipped. Every target reports
+// ScrapeDuration and ScrapeSamples gauges and ScrapeFailures counter
+// labelled with the target URL
+type scrapeCollector struct {
+	baseCollector
+	client *http.Client
+	deltas *deltaTracker
+	opts   struct {
+		Targets []scrapeTarget `json:"targets"`
+		// Timeout limits every scrape, e.g. "5s"
+		Timeout string `json:"timeout"`
+	}
+}
+
+func newScrapeCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("scrape", cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &scrapeCollector{baseCollector: base, deltas: newDeltaTracker()}
+	if err := cfg.decodeOptions(&c.opts); err != nil {
+		return nil, err
+	}
+
+	timeout := defaultScrapeTimeout
+	if c.opts.Timeout != "" {
+		if timeout, err = time.ParseDuration(c.opts.Timeout); err != nil {
+			return nil, fmt.Errorf("scrape collector: %w", err)
+		}
+	}
+	c.client = &http.Client{Timeout: timeout}
+
+	for i, t := range c.opts.Targets {
+		u, err := url.Parse(t.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("scrape collector: bad target URL %q", t.URL)
+		}
+		if _, ok := t.Labels["instance"]; !ok {
+			c.opts.Targets[i].Labels = common.MergeLabels(t.Labels, map[string]string{"instance": u.Host})
+		}
+	}
+	return c, nil
+}
+
+// Collect scrapes the targets one by one. A failed scrape reports
+// none of the target metrics
+func (c *scrapeCollector) Collect(sink Sink) error {
+	for _, t := range c.opts.Targets {
+		labels := map[string]string{"target": t.URL}
+		start := time.Now()
+		samples, err := c.scrape(t.URL)
+		sink.Gauge("ScrapeDuration", time.Since(start).Seconds(), labels)
+		if err != nil {
+			log.Printf("scrape collector: %s: %v", t.URL, err)
+			sink.Counter("ScrapeFailures", 1, labels)
+			continue
+		}
+		sink.Counter("ScrapeFailures", 0, labels)
+		sink.Gauge("ScrapeSamples", float64(len(samples)), labels)
+		for _, s := range samples {
+			c.put(sink, s, common.MergeLabels(s.Labels, t.Labels))
+		}
+	}
+	return nil
+}
+
+func (c *scrapeCollector) scrape(target string) ([]promtext.Sample, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain; version=0.0.4")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return promtext.Parse(resp.Body)
+}
+
+// put maps the sample onto an agent gauge or counter
+func (c *scrapeCollector) put(sink Sink, s promtext.Sample, labels map[string]string) {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return
+	}
+	isCounter := false
+	switch s.Type {
+	case promtext.TypeCounter:
+		isCounter = true
+	case promtext.TypeHistogram:
+		isCounter = s.Name != s.Family+"_sum"
+	case promtext.TypeSummary:
+		isCounter = s.Name == s.Family+"_count"
+	}
+	if !isCounter {
+		sink.Gauge(s.Name, s.Value, labels)
+		return
+	}
+	if s.Value < 0 {
+		return
+	}
+	c.deltas.counter(sink, s.Name, uint64(s.Value), labels)
+}
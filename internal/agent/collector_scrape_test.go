@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeCollector(t *testing.T) {
+	requests := 1000
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `# TYPE requests_total counter
+requests_total{code="200",instance="scraped"} %d
+# TYPE queue gauge
+queue 5
+uptime 12.5
+# TYPE latency histogram
+latency_bucket{le="0.1"} %d
+latency_bucket{le="+Inf"} %d
+latency_sum 1.5
+latency_count %d
+# TYPE rpc summary
+rpc{quantile="0.5"} 0.2
+rpc{quantile="0.9"} NaN
+rpc_sum 10
+rpc_count 7
+`, requests, requests/2, requests, requests)
+	}))
+	defer ts.Close()
+
+	good := ts.URL + "/metrics"
+	bad := ts.URL + "/missing"
+	c := newTestCollector(t, "scrape", fmt.Sprintf(`{"targets": [
+		{"url": %q, "labels": {"job": "app"}},
+		{"url": %q}
+	]}`, good, bad))
+	instance := strings.TrimPrefix(ts.URL, "http://")
+	labels := fmt.Sprintf(`instance=%q,job="app"`, instance)
+
+	mm := collect(t, c)
+	assert.Equal(t, 5.0, *mm["queue{"+labels+"}"].Value)
+	assert.Equal(t, 12.5, *mm["uptime{"+labels+"}"].Value)
+	assert.Equal(t, 1.5, *mm["latency_sum{"+labels+"}"].Value)
+	assert.Equal(t, 0.2, *mm[`rpc{`+labels+`,quantile="0.5"}`].Value)
+	assert.NotContains(t, mm, `rpc{`+labels+`,quantile="0.9"}`)
+	// counters are reported from the second scrape
+	assert.NotContains(t, mm, "latency_count{"+labels+"}")
+	assert.Equal(t, 11.0, *mm[fmt.Sprintf(`ScrapeSamples{target=%q}`, good)].Value)
+	assert.Equal(t, int64(0), *mm[fmt.Sprintf(`ScrapeFailures{target=%q}`, good)].Delta)
+	assert.Equal(t, int64(1), *mm[fmt.Sprintf(`ScrapeFailures{target=%q}`, bad)].Delta)
+
+	requests = 1500
+	mm = collect(t, c)
+	// target labels override the scraped ones
+	key := fmt.Sprintf(`requests_total{code="200",instance=%q,job="app"}`, instance)
+	require.Contains(t, mm, key)
+	assert.Equal(t, int64(500), *mm[key].Delta)
+	assert.Equal(t, int64(250), *mm[`latency_bucket{`+labels+`,le="0.1"}`].Delta)
+	assert.Equal(t, int64(500), *mm["latency_count{"+labels+"}"].Delta)
+	assert.Equal(t, int64(0), *mm["rpc_count{"+labels+"}"].Delta)
+
+	for _, opts := range []string{
+		`{"targets": [{"url": "not a url"}]}`,
+		`{"timeout": "soon"}`,
+	} {
+		_, err := collectorRegistry["scrape"].factory(CollectorConfig{Options: []byte(opts)})
+		assert.Error(t, err, opts)
+	}
+}
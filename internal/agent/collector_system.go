@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func init() {
+	RegisterCollector("load", false, newLoadCollector)
+	RegisterCollector("swap", false, newSwapCollector)
+	RegisterCollector("uptime", false, newUptimeCollector)
+}
+
+// loadCollector reports the load average
+type loadCollector struct {
+	baseCollector
+}
+
+func newLoadCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("load", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &loadCollector{baseCollector: base}, nil
+}
+
+// Collect reports 1, 5 and 15 minutes load average
+func (c *loadCollector) Collect(sink Sink) error {
+	avg, err := load.Avg()
+	if err != nil {
+		return err
+	}
+	sink.Gauge("Load1", avg.Load1, nil)
+	sink.Gauge("Load5", avg.Load5, nil)
+	sink.Gauge("Load15", avg.Load15, nil)
+	return nil
+}
+
+// swapCollector reports swap usage and paging
+type swapCollector struct {
+	baseCollector
+	deltas *deltaTracker
+}
+
+func newSwapCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("swap", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &swapCollector{baseCollector: base, deltas: newDeltaTracker()}, nil
+}
+
+// Collect reports swap size from meminfo and bytes swapped in and out
+func (c *swapCollector) Collect(sink Sink) error {
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return err
+	}
+	sink.Gauge("SwapTotal", float64(vm.SwapTotal), nil)
+	sink.Gauge("SwapFree", float64(vm.SwapFree), nil)
+	sink.Gauge("SwapUsed", float64(vm.SwapTotal-vm.SwapFree), nil)
+
+	swap, err := mem.SwapMemory()
+	if err != nil {
+		return err
+	}
+	c.deltas.counter(sink, "SwapIn", swap.Sin, nil)
+	c.deltas.counter(sink, "SwapOut", swap.Sout, nil)
+	return nil
+}
+
+// uptimeCollector reports the host uptime
+type uptimeCollector struct {
+	baseCollector
+}
+
+func newUptimeCollector(cfg CollectorConfig) (Collector, error) {
+	base, err := newBaseCollector("uptime", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &uptimeCollector{baseCollector: base}, nil
+}
+
+// Collect reports seconds since the host boot
+func (c *uptimeCollector) Collect(sink Sink) error {
+	boot, err := host.BootTime()
+	if err != nil {
+		return err
+	}
+	uptime := time.Since(time.Unix(int64(boot), 0))
+	sink.Gauge("Uptime", uptime.Seconds(), nil)
+	return nil
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+func TestMetricsBuffer(t *testing.T) {
+	b := newMetricsBuffer()
+	b.Counter("PollCount", 1, nil)
+	b.Counter("PollCount", 2, nil)
+	b.Gauge("CPUutilization", 0.5, map[string]string{"cpu": "0"})
+	b.Gauge("CPUutilization", 0.7, map[string]string{"cpu": "0"})
+
+	mm, deltas := b.batch(map[string]string{"host": "a"})
+	require.Len(t, mm, 2)
+	assert.Equal(t, "PollCount", mm[0].ID)
+	assert.Equal(t, int64(3), *mm[0].Delta)
+	assert.Equal(t, map[string]string{"host": "a"}, mm[0].Labels)
+	assert.Equal(t, 0.7, *mm[1].Value)
+	assert.Equal(t, `CPUutilization{cpu="0",host="a"}`, mm[1].Key())
+
+	// the counter grows while the batch is being sent
+	b.Counter("PollCount", 4, nil)
+	b.commit(deltas)
+	mm, _ = b.batch(nil)
+	assert.Equal(t, int64(4), *mm[0].Delta)
+
+	b.RemoveGauge("CPUutilization", map[string]string{"cpu": "0"})
+	mm, _ = b.batch(nil)
+	require.Len(t, mm, 1)
+	assert.Equal(t, "PollCount", mm[0].ID)
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	c, err := newRuntimeCollector(CollectorConfig{Interval: "5s"})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, c.Interval())
+
+	b := newMetricsBuffer()
+	require.NoError(t, c.Collect(b))
+	mm, _ := b.batch(nil)
+	names := make(map[string]string)
+	for _, m := range mm {
+		names[m.ID] = m.MType
+	}
+	assert.Equal(t, common.NameCounter, names["PollCount"])
+	assert.Equal(t, common.NameGauge, names["HeapAlloc"])
+	assert.Len(t, names, 29)
+}
+
+func TestNewCollectors(t *testing.T) {
+	saved := Config
+	defer func() { Config = saved }()
+
+	collectorNames := func() []string {
+		cc, err := newCollectors()
+		require.NoError(t, err)
+		var names []string
+		for _, c := range cc {
+			names = append(names, c.Name())
+		}
+		return names
+	}
+
+	Config.Collectors = nil
+	assert.Equal(t, []string{"psutil", "runtime"}, collectorNames())
+
+	disabled := false
+	Config.Collectors = map[string]CollectorConfig{"psutil": {Enabled: &disabled}}
+	assert.Equal(t, []string{"runtime"}, collectorNames())
+
+	Config.EnabledCollectors = []string{"psutil"}
+	assert.Equal(t, []string{"psutil"}, collectorNames())
+
+	Config.EnabledCollectors = []string{"nosuchcollector"}
+	_, err := newCollectors()
+	assert.Error(t, err)
+
+	Config.EnabledCollectors = nil
+	Config.Collectors = map[string]CollectorConfig{"runtime": {Interval: "soon"}}
+	_, err = newCollectors()
+	assert.Error(t, err)
+}
+
+func TestCollectorConfigJSON(t *testing.T) {
+	var cc map[string]CollectorConfig
+	err := json.Unmarshal([]byte(`{"psutil": {"enabled": false, "interval": "1m", "options": {"x": 1}}}`), &cc)
+	require.NoError(t, err)
+	assert.False(t, *cc["psutil"].Enabled)
+	interval, err := cc["psutil"].interval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, interval)
+
+	var opts struct{ X int }
+	require.NoError(t, cc["psutil"].decodeOptions(&opts))
+	assert.Equal(t, 1, opts.X)
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// baseCollector implements Name and Interval for collectors
+type baseCollector struct {
+	name     string
+	interval time.Duration
+}
+
+func newBaseCollector(name string, cfg CollectorConfig) (baseCollector, error) {
+	interval, err := cfg.interval()
+	if err != nil {
+		return baseCollector{}, err
+	}
+	return baseCollector{name: name, interval: interval}, nil
+}
+
+// Name returns the collector name
+func (c baseCollector) Name() string {
+	return c.name
+}
+
+// Interval returns the collect interval
+func (c baseCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// deltaTracker turns cumulative values read from the system into
+// counter deltas. The first value of a series only sets the base,
+// a value less than the previous one means the source was reset
+type deltaTracker struct {
+	last map[string]uint64
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{last: make(map[string]uint64)}
+}
+
+// counter puts the increase of the cumulative value into the sink
+func (d *deltaTracker) counter(sink Sink, name string, value uint64, labels map[string]string) {
+	key := common.SeriesKey(name, labels)
+	last, ok := d.last[key]
+	d.last[key] = value
+	switch {
+	case !ok:
+		return
+	case value < last:
+		sink.Counter(name, int64(value), labels)
+	default:
+		sink.Counter(name, int64(value-last), labels)
+	}
+}
+
+// nameFilter matches everything if empty, only listed names otherwise
+type nameFilter []string
+
+func (f nameFilter) match(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, n := range f {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+var errReconnectPostponed = errors.New("gRPC stream is down, reconnect is postponed")
+
+func grpcCredentials() (credentials.TransportCredentials, error) {
+	if !Config.GRPCTLS && Config.GRPCCAFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+	return grpcint.ClientCredentials(Config.GRPCCAFile, Config.GRPCCertFile, Config.GRPCKeyFile)
+}
+
+// streamSender keeps one connection and one stream to the server
+// for all batches. A broken stream is reopened with exponential backoff
+type streamSender struct {
+	nextAttempt time.Time
+	conn        *grpc.ClientConn
+	stream      pb.Metrices_StreamMetricesClient
+	cancel      context.CancelFunc
+	backoff     time.Duration
+	lastID      uint64
+	mu          sync.Mutex
+}
+
+var grpcSender streamSender
+
+// open dials the server if needed and opens a new stream
+func (s *streamSender) open() error {
+	if s.conn == nil {
+		creds, err := grpcCredentials()
+		if err != nil {
+			return err
+		}
+		s.conn, err = grpc.Dial(
+			Config.GRPCServer,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithPerRPCCredentials(grpcint.TokenCredentials(Config.APIToken)),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewMetricesClient(s.conn).StreamMetrices(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	s.stream = stream
+	s.cancel = cancel
+	return nil
+}
+
+// fail drops the stream and schedules the next reconnect
+func (s *streamSender) fail() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stream = nil
+	s.cancel = nil
+	if s.backoff == 0 {
+		s.backoff = minReconnectBackoff
+	} else if s.backoff < maxReconnectBackoff {
+		s.backoff *= 2
+		if s.backoff > maxReconnectBackoff {
+			s.backoff = maxReconnectBackoff
+		}
+	}
+	s.nextAttempt = time.Now().Add(s.backoff)
+}
+
+// send pushes the batch to the stream and waits for its ack
+func (s *streamSender) send(batch *pb.MetricsBatch, timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream == nil {
+		if time.Now().Before(s.nextAttempt) {
+			return errReconnectPostponed
+		}
+		if err := s.open(); err != nil {
+			s.fail()
+			return err
+		}
+	}
+
+	s.lastID++
+	batch.Id = s.lastID
+	// a stuck server must not block the agent forever
+	timer := time.AfterFunc(timeout, s.cancel)
+	defer timer.Stop()
+
+	if err := s.stream.Send(batch); err != nil {
+		s.fail()
+		return err
+	}
+	ack, err := s.stream.Recv()
+	if err != nil {
+		s.fail()
+		return err
+	}
+	s.backoff = 0
+	if ack.Id != batch.Id {
+		log.Printf("unexpected gRPC ack id %d, want %d", ack.Id, batch.Id)
+		s.fail()
+		return fmt.Errorf("unexpected ack id %d", ack.Id)
+	}
+	if ack.Error != "" {
+		if ackRefused(codes.Code(ack.Code)) {
+			return fmt.Errorf("%w: %s", errRejected, ack.Error)
+		}
+		return fmt.Errorf("gRPC ack %s: %s", codes.Code(ack.Code), ack.Error)
+	}
+	return nil
+}
+
+// ackRefused tells whether the server refused the batch for good.
+// Servers without ack codes send OK with the error
+func ackRefused(code codes.Code) bool {
+	return code == codes.InvalidArgument || code == codes.OK
+}
+
+func sendBatchGRPC(mm []common.Metrics) error {
+	pList := make([](*pb.Metrics), 0, len(mm))
+	for _, m := range mm {
+		pList = append(pList, grpcint.MetricsToPb(m))
+	}
+
+	batch := pb.MetricsBatch{Metrices: pList}
+	if Config.Key != "" {
+		env, err := grpcint.SignEnvelope(pList, Config.AgentID, Config.Key)
+		if err != nil {
+			return err
+		}
+		batch.Envelope = env
+	}
+	return grpcSender.send(&batch, Config.ReportInterval)
+}
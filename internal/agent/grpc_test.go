@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestAckRefused(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want bool
+	}{
+		{codes.InvalidArgument, true},
+		{codes.OK, true},
+		{codes.Unavailable, false},
+		{codes.ResourceExhausted, false},
+		{codes.PermissionDenied, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, ackRefused(tt.code))
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+const spoolExt = ".json"
+
+// errRejected is returned by senders when the server refuses the batch,
+// such a batch is not retried. Authentication errors are not rejections
+var errRejected = errors.New("batch rejected by the server")
+
+// spool keeps batches which failed to be sent in files in dir,
+// one file per batch. Batches are replayed in FIFO order.
+// When the spool grows over maxSize the oldest batches are merged
+type spool struct {
+	dir     string
+	files   []string
+	sizes   map[string]int64
+	size    int64
+	maxSize int64
+	seq     uint64
+	mu      sync.Mutex
+}
+
+func newSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:     dir,
+		maxSize: maxSize,
+		sizes:   make(map[string]int64),
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, name)
+		s.sizes[name] = info.Size()
+		s.size += info.Size()
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+	// names are zero-padded, so they sort in the order of writing
+	sort.Strings(s.files)
+	if len(s.files) > 0 {
+		log.Printf("spool %s has %d batches to replay", dir, len(s.files))
+	}
+	return s, nil
+}
+
+// empty reports if there are no batches waiting
+func (s *spool) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files) == 0
+}
+
+func (s *spool) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// writeFile stores the batch into the file atomically
+func (s *spool) writeFile(name string, mm []common.Metrics) error {
+	buf, err := json.Marshal(mm)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(name + ".tmp")
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.size += int64(len(buf)) - s.sizes[name]
+	s.sizes[name] = int64(len(buf))
+	return nil
+}
+
+func (s *spool) read(name string) ([]common.Metrics, error) {
+	buf, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var mm []common.Metrics
+	err = json.Unmarshal(buf, &mm)
+	return mm, err
+}
+
+func (s *spool) remove(name string) {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("can't remove spooled batch: %v", err)
+	}
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+	for i, f := range s.files {
+		if f == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			break
+		}
+	}
+}
+
+// push adds the batch to the end of the spool
+func (s *spool) push(mm []common.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolExt)
+	if err := s.writeFile(name, mm); err != nil {
+		return err
+	}
+	s.files = append(s.files, name)
+	return s.shrink()
+}
+
+// shrink merges the two oldest batches until the spool fits maxSize.
+// If merging does not help, the gauges of the oldest batch are dropped,
+// its counters are kept for the totals to stay accurate
+func (s *spool) shrink() error {
+	for s.size > s.maxSize && len(s.files) > 1 {
+		first, second := s.files[0], s.files[1]
+		older, err := s.read(first)
+		if err != nil {
+			log.Printf("dropping unreadable spooled batch %s: %v", first, err)
+			s.remove(first)
+			continue
+		}
+		newer, err := s.read(second)
+		if err != nil {
+			log.Printf("dropping unreadable spooled batch %s: %v", second, err)
+			s.remove(second)
+			continue
+		}
+		merged := mergeBatches(older, newer)
+		if len(merged) == len(older)+len(newer) {
+			// the batches have no series in common, merging does not
+			// free any space, so the older gauges are lost
+			log.Printf("spool is full, dropping the gauges of the oldest batch %s", first)
+			merged = mergeBatches(counters(older), newer)
+		}
+		// the merged batch replaces the newer one to keep the order
+		if err := s.writeFile(second, merged); err != nil {
+			return err
+		}
+		s.remove(first)
+	}
+	if s.size > s.maxSize && len(s.files) == 1 {
+		log.Printf("spooled batch %s is larger than spool size %d", s.files[0], s.maxSize)
+	}
+	return nil
+}
+
+// replay sends spooled batches oldest first until the spool is empty
+// or send fails. Batches rejected by the server are dropped
+func (s *spool) replay(send func([]common.Metrics) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.files) > 0 {
+		name := s.files[0]
+		mm, err := s.read(name)
+		if err != nil {
+			log.Printf("dropping unreadable spooled batch %s: %v", name, err)
+			s.remove(name)
+			continue
+		}
+		err = send(mm)
+		if errors.Is(err, errRejected) {
+			log.Printf("dropping spooled batch %s: %v", name, err)
+		} else if err != nil {
+			return err
+		}
+		s.remove(name)
+	}
+	return nil
+}
+
+// counters returns the counters of the batch
+func counters(mm []common.Metrics) []common.Metrics {
+	var cc []common.Metrics
+	for _, m := range mm {
+		if m.MType == common.NameCounter {
+			cc = append(cc, m)
+		}
+	}
+	return cc
+}
+
+// mergeBatches merges two batches into one: counter deltas of
+// the same series are summed, gauges keep the newer value
+func mergeBatches(older, newer []common.Metrics) []common.Metrics {
+	merged := make([]common.Metrics, 0, len(older)+len(newer))
+	index := make(map[string]int)
+	for _, batch := range [][]common.Metrics{older, newer} {
+		for _, m := range batch {
+			key := m.MType + ":" + m.Key()
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(merged)
+				m.Hash = ""
+				merged = append(merged, m)
+				continue
+			}
+			switch m.MType {
+			case common.NameCounter:
+				if m.Delta != nil {
+					delta := *m.Delta
+					if merged[i].Delta != nil {
+						delta += *merged[i].Delta
+					}
+					merged[i].Delta = &delta
+				}
+			default:
+				m.Hash = ""
+				merged[i] = m
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+func testBatch(delta int64, value float64) []common.Metrics {
+	return []common.Metrics{
+		{ID: "PollCount", MType: common.NameCounter, Delta: &delta},
+		{ID: "HeapAlloc", MType: common.NameGauge, Value: &value},
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 1<<20)
+	require.NoError(t, err)
+	assert.True(t, s.empty())
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, s.push(testBatch(int64(i), float64(i))))
+	}
+
+	// the spool survives the agent restart
+	s, err = newSpool(dir, 1<<20)
+	require.NoError(t, err)
+	require.False(t, s.empty())
+
+	var sent []int64
+	failAt := 2
+	send := func(mm []common.Metrics) error {
+		if len(sent) == failAt {
+			return errors.New("server is down")
+		}
+		sent = append(sent, *mm[0].Delta)
+		return nil
+	}
+	assert.Error(t, s.replay(send))
+	assert.Equal(t, []int64{1, 2}, sent)
+
+	failAt = -1
+	require.NoError(t, s.push(testBatch(4, 4)))
+	assert.NoError(t, s.replay(send))
+	assert.Equal(t, []int64{1, 2, 3, 4}, sent)
+	assert.True(t, s.empty())
+	assert.Zero(t, s.size)
+}
+
+func TestSpoolRejected(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, s.push(testBatch(1, 1)))
+	require.NoError(t, s.push(testBatch(2, 2)))
+
+	calls := 0
+	err = s.replay(func(mm []common.Metrics) error {
+		calls++
+		if calls == 1 {
+			return errRejected
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, s.empty())
+}
+
+func TestSpoolMerge(t *testing.T) {
+	one := testBatch(1, 1)
+	s, err := newSpool(t.TempDir(), 1)
+	require.NoError(t, err)
+
+	// every batch is over the limit, so all of them are merged into one
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, s.push(testBatch(int64(i), float64(i))))
+	}
+	require.Len(t, s.files, 1)
+
+	var got []common.Metrics
+	require.NoError(t, s.replay(func(mm []common.Metrics) error {
+		got = mm
+		return nil
+	}))
+	require.Len(t, got, len(one))
+	assert.Equal(t, int64(15), *got[0].Delta)
+	assert.Equal(t, 5.0, *got[1].Value)
+}
+
+func TestSpoolShrinkKeepsCounters(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 1)
+	require.NoError(t, err)
+
+	// the batches have no series in common, merging does not help
+	for i := 1; i <= 5; i++ {
+		delta, value := int64(i), float64(i)
+		require.NoError(t, s.push([]common.Metrics{
+			{ID: fmt.Sprintf("Count%d", i), MType: common.NameCounter, Delta: &delta},
+			{ID: fmt.Sprintf("Gauge%d", i), MType: common.NameGauge, Value: &value},
+		}))
+	}
+	require.Len(t, s.files, 1)
+
+	var sum int64
+	var gauges []string
+	require.NoError(t, s.replay(func(mm []common.Metrics) error {
+		for _, m := range mm {
+			if m.MType == common.NameCounter {
+				sum += *m.Delta
+			} else {
+				gauges = append(gauges, m.ID)
+			}
+		}
+		return nil
+	}))
+	assert.Equal(t, int64(15), sum)
+	assert.Equal(t, []string{"Gauge5"}, gauges)
+}
+
+func TestMergeBatches(t *testing.T) {
+	d1, d2 := int64(1), int64(2)
+	v1, v2 := 1.0, 2.0
+	older := []common.Metrics{
+		{ID: "PollCount", MType: common.NameCounter, Delta: &d1, Hash: "old"},
+		{ID: "HeapAlloc", MType: common.NameGauge, Value: &v1},
+	}
+	newer := []common.Metrics{
+		{ID: "PollCount", MType: common.NameCounter, Delta: &d2},
+		{ID: "PollCount", MType: common.NameCounter, Delta: &d2, Labels: map[string]string{"host": "a"}},
+		{ID: "HeapAlloc", MType: common.NameGauge, Value: &v2},
+	}
+	merged := mergeBatches(older, newer)
+	require.Len(t, merged, 3)
+	assert.Equal(t, int64(3), *merged[0].Delta)
+	assert.Empty(t, merged[0].Hash)
+	assert.Equal(t, 2.0, *merged[1].Value)
+	assert.Equal(t, int64(2), *merged[2].Delta)
+	// the input batches are not changed
+	assert.Equal(t, int64(1), *older[0].Delta)
+}
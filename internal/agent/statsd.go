@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"log"
+	"net"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/statsd"
+)
+
+// maxStatsDPacket is the largest UDP payload
+const maxStatsDPacket = 65535
+
+// agentStatsD accumulates StatsD metrics between reports,
+// nil if the listener is not started
+var agentStatsD *statsDSource
+
+// statsDSource is the StatsD aggregator with the gauges of its last flush
+type statsDSource struct {
+	agg    *statsd.Aggregator
+	gauges map[string]common.Metrics
+}
+
+func newStatsDSource() *statsDSource {
+	return &statsDSource{agg: statsd.NewAggregator()}
+}
+
+// startStatsD starts the StatsD listener on the UDP address
+func startStatsD(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("accepting StatsD metrics on %s", conn.LocalAddr())
+	agentStatsD = newStatsDSource()
+	go serveStatsD(conn, agentStatsD.agg, stats)
+	return nil
+}
+
+// serveStatsD reads StatsD packets until the connection is closed.
+// Packets with bad lines are counted by the StatsDBadPackets counter
+func serveStatsD(conn net.PacketConn, agg *statsd.Aggregator, sink Sink) {
+	buf := make([]byte, maxStatsDPacket)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("StatsD listener: %v", err)
+			continue
+		}
+		samples, err := statsd.ParsePacket(buf[:n])
+		if err != nil {
+			log.Printf("StatsD listener: %v", err)
+			sink.Counter("StatsDBadPackets", 1, nil)
+		}
+		for _, s := range samples {
+			agg.Add(s)
+		}
+	}
+}
+
+// flush puts the metrics aggregated since the last report into the sink.
+// Timer and set gauges are flushed for the intervals with samples only,
+// so the gauges of the last flush missing now are removed from the sink
+// rather than sent with the stale values
+func (s *statsDSource) flush(sink Sink) {
+	mm := s.agg.Flush()
+	gauges := make(map[string]common.Metrics)
+	for _, m := range mm {
+		if m.MType == common.NameGauge {
+			gauges[m.Key()] = m
+		}
+	}
+	for key, m := range s.gauges {
+		if _, ok := gauges[key]; !ok {
+			sink.RemoveGauge(m.ID, m.Labels)
+		}
+	}
+	s.gauges = gauges
+	putMetrics(sink, mm)
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	src := newStatsDSource()
+	b := newMetricsBuffer()
+	done := make(chan struct{})
+	go func() {
+		serveStatsD(conn, src.agg, b)
+		close(done)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	for _, packet := range []string{
+		"logins:1|c\nlogins:2|c|#app:web",
+		"queue:7|g\nbad line",
+		"rt:10|ms\nrt:30|ms\nusers:a|s",
+	} {
+		_, err := client.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	// the bad packet is counted by the listener
+	require.Eventually(t, func() bool {
+		mm, _ := b.batch(nil)
+		return len(mm) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		src.flush(b)
+		mm, _ := b.batch(nil)
+		got := make(map[string]bool)
+		for _, m := range mm {
+			got[m.Key()] = true
+		}
+		return got["users"]
+	}, time.Second, 10*time.Millisecond)
+
+	mm, _ := b.batch(map[string]string{"host": "h"})
+	got := make(map[string]string)
+	for _, m := range mm {
+		got[m.Key()] = m.String()
+	}
+	assert.Equal(t, `StatsDBadPackets{host="h"}:counter:1`, got[`StatsDBadPackets{host="h"}`])
+	assert.Equal(t, `logins{host="h"}:counter:1`, got[`logins{host="h"}`])
+	assert.Equal(t, `logins{app="web",host="h"}:counter:2`, got[`logins{app="web",host="h"}`])
+	assert.Equal(t, `queue{host="h"}:gauge:7.000000`, got[`queue{host="h"}`])
+	assert.Equal(t, `rt.mean{host="h"}:gauge:20.000000`, got[`rt.mean{host="h"}`])
+	assert.Equal(t, `rt.count{host="h"}:counter:2`, got[`rt.count{host="h"}`])
+	assert.Equal(t, `users{host="h"}:gauge:1.000000`, got[`users{host="h"}`])
+
+	// the timer and set gauges are gone without samples, the gauge stays
+	src.flush(b)
+	mm, _ = b.batch(nil)
+	got = make(map[string]string)
+	for _, m := range mm {
+		got[m.Key()] = m.String()
+	}
+	assert.Equal(t, "queue:gauge:7.000000", got["queue"])
+	assert.NotContains(t, got, "rt.mean")
+	assert.NotContains(t, got, "users")
+
+	conn.Close()
+	<-done
+}
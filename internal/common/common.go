@@ -6,6 +6,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -17,15 +21,89 @@ const (
 
 // Metrics is the struct to use for metrics updates
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Hash   string            `json:"hash,omitempty"`
+	// HashVersion is the version of the hash input, HashV1 if not set
+	HashVersion int `json:"hash_version,omitempty"`
+}
+
+// Hash versions. Metrics and envelopes without the version are hashed
+// as HashV1, so older senders keep working
+const (
+	// HashV1 hashes Metrics.String, which keeps only six decimals
+	// of gauge values
+	HashV1 = 1
+	// HashV2 hashes CanonicalMetric
+	HashV2 = 2
+)
+
+// CheckHashVersion returns an error for unknown hash versions
+func CheckHashVersion(version int) error {
+	if version < 0 || version > HashV2 {
+		return fmt.Errorf("unsupported hash version %d", version)
+	}
+	return nil
+}
+
+// CanonicalMetric returns the HashV2 input of the metric. It keeps the
+// exact bits of gauge values and can't be confused by separators in
+// names and labels, as strings are length prefixed:
+//
+//	v2;<len>:<id>;<type>;<value>;<count>;<len>:<label>=<len>:<value>;...
+//
+// Labels are sorted by name. The value is the decimal delta for counters
+// and 16 hex digits of the IEEE 754 bits for gauges
+func CanonicalMetric(id, mtype string, labels map[string]string, delta int64, value float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "v2;%d:%s;%s;", len(id), id, mtype)
+	switch mtype {
+	case NameCounter:
+		b.WriteString(strconv.FormatInt(delta, 10))
+	case NameGauge:
+		fmt.Fprintf(&b, "%016x", math.Float64bits(value))
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, ";%d", len(names))
+	for _, k := range names {
+		fmt.Fprintf(&b, ";%d:%s=%d:%s", len(k), k, len(labels[k]), labels[k])
+	}
+	return b.String()
+}
+
+// hashInput returns the string to hash in the metrics hash version
+func (m Metrics) hashInput() (string, error) {
+	if err := CheckHashVersion(m.HashVersion); err != nil {
+		return "", err
+	}
+	if m.HashVersion < HashV2 {
+		return m.String(), nil
+	}
+	var delta int64
+	var value float64
+	if m.Delta != nil {
+		delta = *m.Delta
+	}
+	if m.Value != nil {
+		value = *m.Value
+	}
+	return CanonicalMetric(m.ID, m.MType, m.Labels, delta, value), nil
+}
+
+// Key returns the key identifying the metric series, see SeriesKey
+func (m Metrics) Key() string {
+	return SeriesKey(m.ID, m.Labels)
 }
 
 func (m Metrics) String() string {
-	str := fmt.Sprintf("%s:%s:", m.ID, m.MType)
+	str := fmt.Sprintf("%s:%s:", m.Key(), m.MType)
 	if m.Value != nil {
 		str += fmt.Sprintf("%f", *m.Value)
 	} else if m.Delta != nil {
@@ -34,7 +112,7 @@ func (m Metrics) String() string {
 	return str
 }
 
-// ComputeHash calculates hash for metrics
+// ComputeHash calculates hash for metrics in the metrics hash version
 func (m Metrics) ComputeHash(key string) (*[]byte, error) {
 	if key == "" {
 		return nil, fmt.Errorf("no key")
@@ -43,8 +121,11 @@ func (m Metrics) ComputeHash(key string) (*[]byte, error) {
 		return nil, fmt.Errorf("empty ID field")
 	}
 
+	metricsStr, err := m.hashInput()
+	if err != nil {
+		return nil, err
+	}
 	h := hmac.New(sha256.New, []byte(key))
-	metricsStr := m.String()
 	h.Write([]byte(metricsStr))
 	hash := h.Sum(nil)
 
@@ -79,3 +160,40 @@ func (m Metrics) CheckHash(key string) error {
 	}
 	return nil
 }
+
+// ResponseKey returns the key the server signs the metrics it returns
+// with. It differs from the key, so the values read can't be sent back
+// as signed writes
+func ResponseKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return BodyHash(key, []byte("response"))
+}
+
+// BodyHashHeader carries the hex HMAC-SHA256 of the request body for
+// writes without per-metric hashes, e.g. line protocol
+const BodyHashHeader = "X-Body-Hash"
+
+func bodyMAC(key string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// BodyHash calculates the hex HMAC-SHA256 of the body
+func BodyHash(key string, body []byte) string {
+	return hex.EncodeToString(bodyMAC(key, body))
+}
+
+// CheckBodyHash checks the body hash, any hash is fine without the key
+func CheckBodyHash(key string, body []byte, hash string) error {
+	if key == "" {
+		return nil
+	}
+	got, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(got, bodyMAC(key, body)) {
+		return fmt.Errorf("body hash incorrect")
+	}
+	return nil
+}
@@ -164,3 +164,175 @@ func TestMetrics_CheckHash(t *testing.T) {
 		})
 	}
 }
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		name   string
+		id     string
+		want   string
+	}{
+		{
+			name: "no labels",
+			id:   "Alloc",
+			want: "Alloc",
+		},
+		{
+			name:   "sorted labels",
+			id:     "CPUutilization",
+			labels: map[string]string{"host": "h1", "cpu": "0"},
+			want:   `CPUutilization{cpu="0",host="h1"}`,
+		},
+		{
+			name:   "escaped value",
+			id:     "x",
+			labels: map[string]string{"path": `a"b\c`},
+			want:   `x{path="a\"b\\c"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := SeriesKey(tt.id, tt.labels)
+			assert.Equal(t, tt.want, key)
+
+			id, labels, err := ParseSeriesKey(key)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.id, id)
+			assert.Equal(t, tt.labels, labels)
+		})
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	assert.NoError(t, CheckName("CPUutilization"))
+	for _, bad := range []string{"", `x{k="v"}`, "x{", "x}", `x"`} {
+		assert.Error(t, CheckName(bad), bad)
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	assert.NoError(t, CheckLabels(nil))
+	assert.NoError(t, CheckLabels(map[string]string{"cpu": "0", "_dc2": `a"b`}))
+	for _, bad := range []string{"", `a="1",b`, "2xx", "service.name", "a-b", "k{"} {
+		assert.Error(t, CheckLabels(map[string]string{bad: "v"}), bad)
+	}
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "cpu", SanitizeLabelName("cpu"))
+	assert.Equal(t, "service_name", SanitizeLabelName("service.name"))
+	assert.Equal(t, "_xx", SanitizeLabelName("2xx"))
+	assert.Equal(t, "a__1__b", SanitizeLabelName(`a="1",b`))
+	assert.Equal(t, "_", SanitizeLabelName(""))
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("dc=east, rack=1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"dc": "east", "rack": "1"}, labels)
+
+	_, err = ParseLabels("dc")
+	assert.Error(t, err)
+	_, err = ParseLabels("data.center=east")
+	assert.Error(t, err)
+}
+
+func TestMetrics_HashCoversLabels(t *testing.T) {
+	m := Metrics{
+		ID:    "x",
+		MType: "counter",
+		Delta: &testInt,
+	}
+	labelled := m
+	labelled.Labels = map[string]string{"cpu": "0"}
+
+	plain, err := m.ComputeHash("abcdef")
+	assert.NoError(t, err)
+	withLabels, err := labelled.ComputeHash("abcdef")
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, withLabels)
+
+	labelled.StoreHash("abcdef")
+	labelled.Labels = map[string]string{"cpu": "1"}
+	assert.Error(t, labelled.CheckHash("abcdef"))
+}
+
+func TestCheckBodyHash(t *testing.T) {
+	body := []byte("cpu usage=1")
+	hash := BodyHash("key", body)
+	assert.NoError(t, CheckBodyHash("key", body, hash))
+	assert.Error(t, CheckBodyHash("other", body, hash))
+	assert.Error(t, CheckBodyHash("key", []byte("cpu usage=2"), hash))
+	assert.Error(t, CheckBodyHash("key", body, "not hex"))
+	assert.NoError(t, CheckBodyHash("", body, ""))
+}
+
+func TestEnvelope_Sign(t *testing.T) {
+	e := Envelope{Metrics: []Metrics{
+		{ID: "PollCount", MType: NameCounter, Delta: &testInt},
+		{ID: "Alloc", MType: NameGauge, Value: &testFloat},
+	}}
+	assert.Error(t, e.Sign(""))
+	assert.NoError(t, e.Sign("key"))
+	assert.NotZero(t, e.Timestamp)
+	assert.Len(t, e.Nonce, 2*nonceSize)
+	assert.NoError(t, e.CheckHash("key"))
+	assert.Error(t, e.CheckHash("other"))
+
+	nonce := e.Nonce
+	assert.NoError(t, e.Sign("key"))
+	assert.NotEqual(t, nonce, e.Nonce)
+
+	changed := e
+	changed.Timestamp++
+	assert.Error(t, changed.CheckHash("key"))
+	changed = e
+	changed.Nonce = nonce
+	assert.Error(t, changed.CheckHash("key"))
+	changed = e
+	changed.Metrics = e.Metrics[:1]
+	assert.Error(t, changed.CheckHash("key"))
+}
+
+func TestMetrics_HashV2(t *testing.T) {
+	a, b := 0.1234567, 0.1234568
+	m1 := Metrics{ID: "Alloc", MType: NameGauge, Value: &a}
+	m2 := Metrics{ID: "Alloc", MType: NameGauge, Value: &b}
+
+	// v1 keeps six decimals only
+	h1, err := m1.ComputeHash("key")
+	assert.NoError(t, err)
+	h2, err := m2.ComputeHash("key")
+	assert.NoError(t, err)
+	assert.Equal(t, *h1, *h2)
+
+	m1.HashVersion, m2.HashVersion = HashV2, HashV2
+	h1, err = m1.ComputeHash("key")
+	assert.NoError(t, err)
+	h2, err = m2.ComputeHash("key")
+	assert.NoError(t, err)
+	assert.NotEqual(t, *h1, *h2)
+
+	assert.NoError(t, m1.StoreHash("key"))
+	assert.NoError(t, m1.CheckHash("key"))
+	m1.HashVersion = HashV1
+	assert.Error(t, m1.CheckHash("key"))
+	m1.HashVersion = 3
+	assert.Error(t, m1.CheckHash("key"))
+}
+
+func TestCanonicalMetric(t *testing.T) {
+	assert.Equal(t,
+		`v2;5:Alloc;gauge;3ff8000000000000;2;3:cpu=1:0;4:host=2:h1`,
+		CanonicalMetric("Alloc", NameGauge, map[string]string{"host": "h1", "cpu": "0"}, 0, 1.5))
+	assert.Equal(t, `v2;9:PollCount;counter;-7;0`, CanonicalMetric("PollCount", NameCounter, nil, -7, 0))
+	// separators in labels can't make two label sets look the same
+	assert.NotEqual(t,
+		CanonicalMetric("m", NameGauge, map[string]string{"a": "1;1:b=1:2"}, 0, 1),
+		CanonicalMetric("m", NameGauge, map[string]string{"a": "1", "b": "2"}, 0, 1))
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, SortedKeys(map[string]int{"c": 3, "a": 1, "b": 2}))
+	assert.Empty(t, SortedKeys(map[string]bool{}))
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// nonceSize is the number of random bytes in a nonce
+const nonceSize = 16
+
+// MaxNonceLength limits the length of the nonce accepted by the server
+const MaxNonceLength = 64
+
+// Envelope is a signed batch of metrics. The hash covers the timestamp,
+// the nonce and all the metrics, so the server can reject a batch
+// captured and sent again. The metrics in the envelope have no hashes
+type Envelope struct {
+	// Timestamp is the signing time in Unix seconds
+	Timestamp int64     `json:"timestamp"`
+	Nonce     string    `json:"nonce"`
+	Metrics   []Metrics `json:"metrics"`
+	Hash      string    `json:"hash"`
+	// Version is the hash version of the metrics, HashV1 if not set
+	Version int `json:"version,omitempty"`
+	// AgentID is the sender, the server checks the hash with the key
+	// registered for it. The shared key is used if not set
+	AgentID string `json:"agent_id,omitempty"`
+}
+
+// NewNonce returns a random hex string unique for every batch
+func NewNonce() (string, error) {
+	b := make([]byte, nonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// EnvelopeHash calculates the hex HMAC-SHA256 of the agent ID, the
+// timestamp, the nonce and the metrics given as the hash input of the
+// version, Metrics.String for HashV1 and CanonicalMetric for HashV2.
+// It is shared by the JSON and gRPC envelopes
+func EnvelopeHash(key string, version int, agentID string, timestamp int64, nonce string, metrics []string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	if agentID != "" {
+		h.Write([]byte("agent:" + strconv.Itoa(len(agentID)) + ":" + agentID + "\n"))
+	}
+	if version >= HashV2 {
+		h.Write([]byte("v" + strconv.Itoa(version) + ":"))
+	}
+	h.Write([]byte(strconv.FormatInt(timestamp, 10) + ":" + nonce + "\n"))
+	for _, m := range metrics {
+		h.Write([]byte(m + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CheckEnvelopeHash checks the hash, any hash is fine without the key
+func CheckEnvelopeHash(key string, version int, agentID string, timestamp int64, nonce string, metrics []string, hash string) error {
+	if key == "" {
+		return nil
+	}
+	want := EnvelopeHash(key, version, agentID, timestamp, nonce, metrics)
+	if !hmac.Equal([]byte(hash), []byte(want)) {
+		return fmt.Errorf("envelope hash incorrect")
+	}
+	return nil
+}
+
+// hashInputs returns the hash inputs of the metrics in the envelope version
+func (e Envelope) hashInputs() ([]string, error) {
+	ss := make([]string, len(e.Metrics))
+	for i, m := range e.Metrics {
+		m.HashVersion = e.Version
+		in, err := m.hashInput()
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = in
+	}
+	return ss, nil
+}
+
+// Sign sets HashV2, the current time, a new nonce and the hash.
+// AgentID must be set before
+func (e *Envelope) Sign(key string) error {
+	if key == "" {
+		return fmt.Errorf("no key")
+	}
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	e.Version = HashV2
+	e.Timestamp = time.Now().Unix()
+	e.Nonce = nonce
+	inputs, err := e.hashInputs()
+	if err != nil {
+		return err
+	}
+	e.Hash = EnvelopeHash(key, e.Version, e.AgentID, e.Timestamp, e.Nonce, inputs)
+	return nil
+}
+
+// CheckHash checks the envelope hash. The timestamp and the nonce
+// are checked by the receiver
+func (e Envelope) CheckHash(key string) error {
+	inputs, err := e.hashInputs()
+	if err != nil {
+		return err
+	}
+	return CheckEnvelopeHash(key, e.Version, e.AgentID, e.Timestamp, e.Nonce, inputs, e.Hash)
+}
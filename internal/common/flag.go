@@ -26,6 +26,13 @@ type TimeFlag struct {
 	Set    bool
 }
 
+// Int64Flag holds int64 flags
+type Int64Flag struct {
+	Value  *int64
+	Option string
+	Set    bool
+}
+
 func (s StringFlag) String() string {
 	var a = AnyFlag{
 		value:  s.Value,
@@ -53,6 +60,15 @@ func (b BoolFlag) String() string {
 	return a.String()
 }
 
+func (i Int64Flag) String() string {
+	var a = AnyFlag{
+		value:  i.Value,
+		option: i.Option,
+		set:    i.Set,
+	}
+	return a.String()
+}
+
 // AnyFlag is used to convert flag values into a string
 type AnyFlag struct {
 	value  interface{}
@@ -71,6 +87,8 @@ func (s AnyFlag) String() string {
 			ret += fmt.Sprintf("value: %t", *v)
 		case *time.Duration:
 			ret += fmt.Sprintf("value: %v", *v)
+		case *int64:
+			ret += fmt.Sprintf("value: %d", *v)
 		}
 	} else {
 		ret += "(nil)"
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FormatLabels returns the canonical representation of labels:
+// k1="v1",k2="v2" sorted by the label name
+func FormatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// SeriesKey returns the key identifying the metric with the given labels,
+// e.g. CPUutilization{cpu="0"}. Without labels the key is the name itself.
+func SeriesKey(name string, labels map[string]string) string {
+	return JoinSeriesKey(name, FormatLabels(labels))
+}
+
+// CheckName checks the metric name can be a part of the series key.
+// A name with braces or quotes could pose as the key of another series,
+// x{k="v"} without labels as x with the label k="v"
+func CheckName(name string) error {
+	if name == "" {
+		return errors.New("empty metric name")
+	}
+	if strings.ContainsAny(name, `{}"`) {
+		return fmt.Errorf("metric name %q has braces or quotes", name)
+	}
+	return nil
+}
+
+// CheckLabels checks the label names match [a-zA-Z_][a-zA-Z0-9_]*.
+// Any other name could break the series key, the name a="1",b
+// makes the labels of another series
+func CheckLabels(labels map[string]string) error {
+	for k := range labels {
+		if !validLabelName(k) {
+			return fmt.Errorf("bad label name %q", k)
+		}
+	}
+	return nil
+}
+
+// SanitizeLabelName replaces the characters not allowed in label names
+// with underscores, e.g. service.name becomes service_name
+func SanitizeLabelName(name string) string {
+	if validLabelName(name) {
+		return name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if !labelNameChar(c, i == 0) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !labelNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func labelNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		!first && c >= '0' && c <= '9'
+}
+
+// SplitSeriesKey splits the series key into the name and
+// the canonical labels string without parsing the latter
+func SplitSeriesKey(key string) (string, string) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 || !strings.HasSuffix(key, "}") {
+		return key, ""
+	}
+	return key[:i], key[i+1 : len(key)-1]
+}
+
+// JoinSeriesKey is the reverse of SplitSeriesKey
+func JoinSeriesKey(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+// ParseSeriesKey parses the key made by SeriesKey
+func ParseSeriesKey(key string) (string, map[string]string, error) {
+	name, rawLabels := SplitSeriesKey(key)
+	if rawLabels == "" {
+		return name, nil, nil
+	}
+
+	labels := make(map[string]string)
+	s := rawLabels
+	for len(s) > 0 {
+		eq := strings.Index(s, `="`)
+		if eq <= 0 {
+			return "", nil, fmt.Errorf("bad labels in %q", key)
+		}
+		k := s[:eq]
+		s = s[eq+2:]
+
+		var v strings.Builder
+		i := 0
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					v.WriteByte('\n')
+				default:
+					v.WriteByte(s[i])
+				}
+				continue
+			}
+			v.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return "", nil, fmt.Errorf("unterminated label value in %q", key)
+		}
+		labels[k] = v.String()
+		s = strings.TrimPrefix(s[i+1:], ",")
+	}
+	return name, labels, nil
+}
+
+// ParseLabels parses labels given as k1=v1,k2=v2
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("bad label %q, want name=value", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	if err := CheckLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// MergeLabels returns a new map with labels from all the given maps,
+// later maps override earlier ones
+func MergeLabels(all ...map[string]string) map[string]string {
+	var ret map[string]string
+	for _, labels := range all {
+		for k, v := range labels {
+			if ret == nil {
+				ret = make(map[string]string)
+			}
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
@@ -1,6 +1,9 @@
 package common
 
-import "flag"
+import (
+	"flag"
+	"sort"
+)
 
 // IsFlagPassed checks if the specified flag was passed via the command line
 func IsFlagPassed(name string) bool {
@@ -19,3 +22,13 @@ func CopyIfNotNil(dst, src *string) {
 		*dst = *src
 	}
 }
+
+// SortedKeys returns the keys of the map in ascending order
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
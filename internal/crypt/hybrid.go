@@ -0,0 +1,80 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// EncryptionHeader is the HTTP header naming the encryption scheme
+// of the body. Bodies without it are encrypted with EncryptOAEP
+const EncryptionHeader = "X-Encryption"
+
+// SchemeHybrid is the EncryptionHeader value for EncryptHybrid bodies
+const SchemeHybrid = "rsa-oaep-aes256-gcm"
+
+const aesKeySize = 32
+
+// EncryptHybrid encrypts the message with a random AES-256-GCM key,
+// the key itself is encrypted with RSA-OAEP (SHA-256).
+// The result is the encrypted key, the nonce and the sealed message
+func EncryptHybrid(random io.Reader, public *rsa.PublicKey, msg []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), random, public, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedKey)+len(nonce)+len(msg)+gcm.Overhead())
+	out = append(out, encryptedKey...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, msg, nil), nil
+}
+
+// DecryptHybrid decrypts the message encrypted with EncryptHybrid
+func DecryptHybrid(random io.Reader, private *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	keyLen := private.PublicKey.Size()
+	if len(msg) < keyLen {
+		return nil, errors.New("encrypted message is too short")
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), random, private, msg[:keyLen], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != aesKeySize {
+		return nil, errors.New("wrong encrypted key size")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	msg = msg[keyLen:]
+	if len(msg) < gcm.NonceSize() {
+		return nil, errors.New("encrypted message is too short")
+	}
+	return gcm.Open(nil, msg[:gcm.NonceSize()], msg[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
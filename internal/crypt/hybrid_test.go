@@ -0,0 +1,42 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestHybrid(t *testing.T) {
+	keys := generateTestKeyPair(t)
+	msg := bytes.Repeat([]byte(`{"id":"PollCount","type":"counter","delta":1}`), 1000)
+
+	encrypted, err := EncryptHybrid(rand.Reader, &keys.privateKey.PublicKey, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oaep, err := EncryptOAEP(sha256.New(), rand.Reader, &keys.privateKey.PublicKey, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) >= len(oaep) {
+		t.Errorf("hybrid message length %d is not less than OAEP one %d",
+			len(encrypted), len(oaep))
+	}
+
+	decrypted, err := DecryptHybrid(rand.Reader, keys.privateKey, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, msg) {
+		t.Error("DecryptHybrid() returned different message")
+	}
+
+	encrypted[len(encrypted)-1] ^= 1
+	if _, err := DecryptHybrid(rand.Reader, keys.privateKey, encrypted); err == nil {
+		t.Error("DecryptHybrid() accepted tampered message")
+	}
+	if _, err := DecryptHybrid(rand.Reader, keys.privateKey, encrypted[:10]); err == nil {
+		t.Error("DecryptHybrid() accepted short message")
+	}
+}
@@ -23,6 +23,10 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricesClient interface {
 	UpdateMetrices(ctx context.Context, in *UpdateMetricesRequest, opts ...grpc.CallOption) (*UpdateMetricesResponse, error)
+	StreamMetrices(ctx context.Context, opts ...grpc.CallOption) (Metrices_StreamMetricesClient, error)
+	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error)
+	WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (Metrices_WatchMetricsClient, error)
 }
 
 type metricesClient struct {
@@ -42,11 +46,96 @@ func (c *metricesClient) UpdateMetrices(ctx context.Context, in *UpdateMetricesR
 	return out, nil
 }
 
+func (c *metricesClient) StreamMetrices(ctx context.Context, opts ...grpc.CallOption) (Metrices_StreamMetricesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Metrices_ServiceDesc.Streams[0], "/grpcint.Metrices/StreamMetrices", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricesStreamMetricesClient{stream}
+	return x, nil
+}
+
+type Metrices_StreamMetricesClient interface {
+	Send(*MetricsBatch) error
+	Recv() (*BatchAck, error)
+	grpc.ClientStream
+}
+
+type metricesStreamMetricesClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricesStreamMetricesClient) Send(m *MetricsBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricesStreamMetricesClient) Recv() (*BatchAck, error) {
+	m := new(BatchAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricesClient) GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error) {
+	out := new(GetMetricResponse)
+	err := c.cc.Invoke(ctx, "/grpcint.Metrices/GetMetric", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricesClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error) {
+	out := new(ListMetricsResponse)
+	err := c.cc.Invoke(ctx, "/grpcint.Metrices/ListMetrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricesClient) WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (Metrices_WatchMetricsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Metrices_ServiceDesc.Streams[1], "/grpcint.Metrices/WatchMetrics", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricesWatchMetricsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Metrices_WatchMetricsClient interface {
+	Recv() (*Metrics, error)
+	grpc.ClientStream
+}
+
+type metricesWatchMetricsClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricesWatchMetricsClient) Recv() (*Metrics, error) {
+	m := new(Metrics)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MetricesServer is the server API for Metrices service.
 // All implementations must embed UnimplementedMetricesServer
 // for forward compatibility
 type MetricesServer interface {
 	UpdateMetrices(context.Context, *UpdateMetricesRequest) (*UpdateMetricesResponse, error)
+	StreamMetrices(Metrices_StreamMetricesServer) error
+	GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error)
+	WatchMetrics(*WatchMetricsRequest, Metrices_WatchMetricsServer) error
 	mustEmbedUnimplementedMetricesServer()
 }
 
@@ -57,6 +146,18 @@ type UnimplementedMetricesServer struct {
 func (UnimplementedMetricesServer) UpdateMetrices(context.Context, *UpdateMetricesRequest) (*UpdateMetricesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetrices not implemented")
 }
+func (UnimplementedMetricesServer) StreamMetrices(Metrices_StreamMetricesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMetrices not implemented")
+}
+func (UnimplementedMetricesServer) GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
+}
+func (UnimplementedMetricesServer) ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
+func (UnimplementedMetricesServer) WatchMetrics(*WatchMetricsRequest, Metrices_WatchMetricsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMetrics not implemented")
+}
 func (UnimplementedMetricesServer) mustEmbedUnimplementedMetricesServer() {}
 
 // UnsafeMetricesServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +189,89 @@ func _Metrices_UpdateMetrices_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrices_StreamMetrices_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricesServer).StreamMetrices(&metricesStreamMetricesServer{stream})
+}
+
+type Metrices_StreamMetricesServer interface {
+	Send(*BatchAck) error
+	Recv() (*MetricsBatch, error)
+	grpc.ServerStream
+}
+
+type metricesStreamMetricesServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricesStreamMetricesServer) Send(m *BatchAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricesStreamMetricesServer) Recv() (*MetricsBatch, error) {
+	m := new(MetricsBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Metrices_GetMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricesServer).GetMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcint.Metrices/GetMetric",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricesServer).GetMetric(ctx, req.(*GetMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrices_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricesServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpcint.Metrices/ListMetrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricesServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrices_WatchMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMetricsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricesServer).WatchMetrics(m, &metricesWatchMetricsServer{stream})
+}
+
+type Metrices_WatchMetricsServer interface {
+	Send(*Metrics) error
+	grpc.ServerStream
+}
+
+type metricesWatchMetricsServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricesWatchMetricsServer) Send(m *Metrics) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Metrices_ServiceDesc is the grpc.ServiceDesc for Metrices service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,7 +283,27 @@ var Metrices_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetrices",
 			Handler:    _Metrices_UpdateMetrices_Handler,
 		},
+		{
+			MethodName: "GetMetric",
+			Handler:    _Metrices_GetMetric_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _Metrices_ListMetrics_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrices",
+			Handler:       _Metrices_StreamMetrices_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchMetrics",
+			Handler:       _Metrices_WatchMetrics_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/grpc.proto",
 }
@@ -0,0 +1,122 @@
+package grpcint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeCert creates a certificate signed by parent (self-signed if nil)
+// and writes it with its key into dir
+func writeCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func startTLSServer(t *testing.T, creds credentials.TransportCredentials) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := grpc.NewServer(grpc.Creds(creds))
+	pb.RegisterMetricesServer(s, pb.UnimplementedMetricesServer{})
+	go s.Serve(listen)
+	t.Cleanup(s.Stop)
+	return listen.Addr().String()
+}
+
+func callServer(addr string, creds credentials.TransportCredentials) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = pb.NewMetricesClient(conn).UpdateMetrices(ctx, &pb.UpdateMetricesRequest{})
+	return err
+}
+
+func TestCredentials(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	ca := writeCert(t, dir, "ca", nil, true)
+	writeCert(t, dir, "server", ca, false)
+	writeCert(t, dir, "client", ca, false)
+
+	serverCreds, err := ServerCredentials(path("server.crt"), path("server.key"), path("ca.crt"))
+	require.NoError(t, err)
+	addr := startTLSServer(t, serverCreds)
+
+	// the request reaches the service, which is not implemented here
+	clientCreds, err := ClientCredentials(path("ca.crt"), path("client.crt"), path("client.key"))
+	require.NoError(t, err)
+	err = callServer(addr, clientCreds)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// no client certificate with mTLS
+	clientCreds, err = ClientCredentials(path("ca.crt"), "", "")
+	require.NoError(t, err)
+	err = callServer(addr, clientCreds)
+	assert.Error(t, err)
+	assert.NotEqual(t, codes.Unimplemented, status.Code(err))
+
+	_, err = ServerCredentials(path("server.crt"), "", "")
+	assert.Error(t, err)
+	_, err = ClientCredentials(path("nosuchfile"), "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,28 @@
+package grpcint
+
+import (
+	"context"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// tokenCredentials sends the API token in the authorization metadata
+type tokenCredentials string
+
+// TokenCredentials returns per-RPC credentials sending the bearer token,
+// no metadata is sent if the token is empty. The token is sent without
+// TLS too, so the connection should use TLS if the network is not trusted
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	if t == "" {
+		return nil, nil
+	}
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
@@ -16,6 +17,8 @@ func MetricsToPb(m common.Metrics) *pb.Metrics {
 	p := pb.Metrics{}
 
 	p.Id = m.ID
+	p.Labels = m.Labels
+	p.HashVersion = int32(m.HashVersion)
 
 	if m.MType == common.NameGauge {
 		p.Mtype = pb.Metrics_GAUGE
@@ -31,7 +34,7 @@ func MetricsToPb(m common.Metrics) *pb.Metrics {
 // ToString converts pb.Metrics to string
 func ToString(p *pb.Metrics) string {
 
-	str := p.Id
+	str := common.SeriesKey(p.Id, p.Labels)
 
 	switch p.Mtype {
 	case pb.Metrics_GAUGE:
@@ -49,7 +52,27 @@ func ToString(p *pb.Metrics) string {
 	return str
 }
 
+// hashInput returns the string to hash in the given hash version,
+// the same as for common.Metrics
+func hashInput(p *pb.Metrics, version int32) (string, error) {
+	if err := common.CheckHashVersion(int(version)); err != nil {
+		return "", err
+	}
+	if version < common.HashV2 {
+		return ToString(p), nil
+	}
+	var mtype string
+	switch p.Mtype {
+	case pb.Metrics_GAUGE:
+		mtype = common.NameGauge
+	case pb.Metrics_COUNTER:
+		mtype = common.NameCounter
+	}
+	return common.CanonicalMetric(p.Id, mtype, p.Labels, p.Delta, p.Value), nil
+}
+
 // ComputeHash computes the hash for the given key and pb.Metrics
+// in the metrics hash version
 func ComputeHash(p *pb.Metrics, key string) (*[]byte, error) {
 	if key == "" {
 		return nil, fmt.Errorf("no key")
@@ -58,8 +81,11 @@ func ComputeHash(p *pb.Metrics, key string) (*[]byte, error) {
 		return nil, fmt.Errorf("empty ID field")
 	}
 
+	metricsStr, err := hashInput(p, p.HashVersion)
+	if err != nil {
+		return nil, err
+	}
 	h := hmac.New(sha256.New, []byte(key))
-	metricsStr := ToString(p)
 	h.Write([]byte(metricsStr))
 	hash := h.Sum(nil)
 
@@ -94,3 +120,49 @@ func CheckHash(p *pb.Metrics, key string) error {
 	}
 	return nil
 }
+
+func hashInputs(pp []*pb.Metrics, version int32) ([]string, error) {
+	ss := make([]string, len(pp))
+	for i, p := range pp {
+		in, err := hashInput(p, version)
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = in
+	}
+	return ss, nil
+}
+
+// SignEnvelope returns the envelope of the agent signing the metrices
+// with HashV2, the current time and a new nonce, see common.Envelope
+func SignEnvelope(pp []*pb.Metrics, agentID string, key string) (*pb.Envelope, error) {
+	if key == "" {
+		return nil, fmt.Errorf("no key")
+	}
+	nonce, err := common.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	e := pb.Envelope{
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+		Version:   common.HashV2,
+		AgentId:   agentID,
+	}
+	inputs, err := hashInputs(pp, e.Version)
+	if err != nil {
+		return nil, err
+	}
+	e.Hash = common.EnvelopeHash(key, int(e.Version), e.AgentId, e.Timestamp, e.Nonce, inputs)
+	return &e, nil
+}
+
+// CheckEnvelope checks the envelope hash of the metrices. The timestamp
+// and the nonce are checked by the receiver
+func CheckEnvelope(e *pb.Envelope, pp []*pb.Metrics, key string) error {
+	inputs, err := hashInputs(pp, e.Version)
+	if err != nil {
+		return err
+	}
+	return common.CheckEnvelopeHash(key, int(e.Version), e.AgentId, e.Timestamp, e.Nonce, inputs, e.Hash)
+}
@@ -0,0 +1,58 @@
+package grpcint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+func TestEnvelopeMatchesJSON(t *testing.T) {
+	delta := int64(3)
+	value := 2.5
+	mm := []common.Metrics{
+		{ID: "PollCount", MType: common.NameCounter, Delta: &delta},
+		{ID: "CPU", MType: common.NameGauge, Value: &value, Labels: map[string]string{"cpu": "0"}},
+	}
+	pp := []*pb.Metrics{MetricsToPb(mm[0]), MetricsToPb(mm[1])}
+
+	e, err := SignEnvelope(pp, "agent-1", "key")
+	require.NoError(t, err)
+	assert.NoError(t, CheckEnvelope(e, pp, "key"))
+	assert.Error(t, CheckEnvelope(e, pp[:1], "key"))
+
+	// the JSON envelope of the same batch has the same hash
+	je := common.Envelope{
+		Timestamp: e.Timestamp,
+		Nonce:     e.Nonce,
+		Metrics:   mm,
+		Hash:      e.Hash,
+		Version:   int(e.Version),
+		AgentID:   e.AgentId,
+	}
+	assert.NoError(t, je.CheckHash("key"))
+	je.AgentID = "agent-2"
+	assert.Error(t, je.CheckHash("key"))
+}
+
+func TestHashV2MatchesJSON(t *testing.T) {
+	value := 0.1234567
+	m := common.Metrics{
+		ID:          "CPU",
+		MType:       common.NameGauge,
+		Value:       &value,
+		Labels:      map[string]string{"cpu": "0"},
+		HashVersion: common.HashV2,
+	}
+	require.NoError(t, m.StoreHash("key"))
+
+	p := MetricsToPb(m)
+	require.NoError(t, StoreHash(p, "key"))
+	assert.Equal(t, m.Hash, p.Hash)
+
+	p.Value = 0.1234568
+	assert.Error(t, CheckHash(p, "key"))
+}
@@ -0,0 +1,199 @@
+// Package lineproto parses the InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+package lineproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Field value kinds
+const (
+	KindFloat = iota
+	KindInt
+	KindUint
+	KindBool
+	KindString
+)
+
+// Field is a point field. Value holds the number for numeric and
+// bool (1 or 0) fields, Int holds the exact value of integer fields,
+// Str holds the string value
+type Field struct {
+	Key   string
+	Str   string
+	Kind  int
+	Value float64
+	Int   int64
+}
+
+// Point is a parsed line. Timestamp is zero if not given
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Timestamp   int64
+}
+
+// Parse parses all lines of the body, empty lines and comments are skipped
+func Parse(body []byte) ([]Point, error) {
+	var points []Point
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		points = append(points, p)
+	}
+	return points, sc.Err()
+}
+
+// ParseLine parses one line
+func ParseLine(line string) (Point, error) {
+	var p Point
+	sections := split(line, ' ', true)
+	// several spaces may separate the sections
+	parts := sections[:0]
+	for _, s := range sections {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) < 2 || len(parts) > 3 {
+		return p, errors.New("expected measurement, fields and optional timestamp")
+	}
+
+	keys := split(parts[0], ',', false)
+	p.Measurement = unescape(keys[0])
+	if p.Measurement == "" {
+		return p, errors.New("empty measurement")
+	}
+	for _, kv := range keys[1:] {
+		k, v, err := splitPair(kv)
+		if err != nil {
+			return p, fmt.Errorf("bad tag %q: %w", kv, err)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	for _, kv := range split(parts[1], ',', true) {
+		k, v, err := splitPair(kv)
+		if err != nil {
+			return p, fmt.Errorf("bad field %q: %w", kv, err)
+		}
+		f, err := parseField(unescape(k), v)
+		if err != nil {
+			return p, fmt.Errorf("bad field %q: %w", kv, err)
+		}
+		p.Fields = append(p.Fields, f)
+	}
+
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("bad timestamp: %w", err)
+		}
+		p.Timestamp = ts
+	}
+	return p, nil
+}
+
+func parseField(key, v string) (Field, error) {
+	f := Field{Key: key}
+	var err error
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return f, errors.New("unterminated string")
+		}
+		f.Kind = KindString
+		f.Str = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+	case strings.HasSuffix(v, "i"):
+		f.Kind = KindInt
+		f.Int, err = strconv.ParseInt(v[:len(v)-1], 10, 64)
+		f.Value = float64(f.Int)
+	case strings.HasSuffix(v, "u"):
+		f.Kind = KindUint
+		var u uint64
+		u, err = strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err == nil && u > math.MaxInt64 {
+			err = errors.New("unsigned value out of range")
+		}
+		f.Int = int64(u)
+		f.Value = float64(u)
+	default:
+		switch v {
+		case "t", "T", "true", "True", "TRUE":
+			f.Kind, f.Value = KindBool, 1
+		case "f", "F", "false", "False", "FALSE":
+			f.Kind, f.Value = KindBool, 0
+		default:
+			f.Kind = KindFloat
+			f.Value, err = strconv.ParseFloat(v, 64)
+			// the protocol has no NaN or infinity, the stores can't keep them
+			if err == nil && (math.IsNaN(f.Value) || math.IsInf(f.Value, 0)) {
+				err = errors.New("float value is not finite")
+			}
+		}
+	}
+	return f, err
+}
+
+// split splits s by the separator not escaped with a backslash.
+// Separators in double quoted strings are skipped if quotes is set
+func split(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start := 0
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quotes:
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitPair splits key=value at the first unescaped equals sign
+func splitPair(s string) (string, string, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			if i == 0 || i == len(s)-1 {
+				return "", "", errors.New("empty key or value")
+			}
+			return s[:i], s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("no value")
+}
+
+// unescape removes backslashes before commas, equals signs and spaces
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, `,`, `\=`, `=`, `\ `, ` `).Replace(s)
+}
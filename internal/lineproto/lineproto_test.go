@@ -0,0 +1,66 @@
+package lineproto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine(`cpu,host=server\ 01,region=us\,west usage_idle=98.5,cores=4i,total=10u,up=t,note="a \"b\", c" 1465839830100400200`)
+	require.NoError(t, err)
+	assert.Equal(t, Point{
+		Measurement: "cpu",
+		Tags:        map[string]string{"host": "server 01", "region": "us,west"},
+		Fields: []Field{
+			{Key: "usage_idle", Kind: KindFloat, Value: 98.5},
+			{Key: "cores", Kind: KindInt, Value: 4, Int: 4},
+			{Key: "total", Kind: KindUint, Value: 10, Int: 10},
+			{Key: "up", Kind: KindBool, Value: 1},
+			{Key: "note", Kind: KindString, Str: `a "b", c`},
+		},
+		Timestamp: 1465839830100400200,
+	}, p)
+
+	p, err = ParseLine(`my\ measure  value=-1.5e3`)
+	require.NoError(t, err)
+	assert.Equal(t, "my measure", p.Measurement)
+	assert.Nil(t, p.Tags)
+	assert.Equal(t, -1500.0, p.Fields[0].Value)
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, bad := range []string{
+		"cpu",
+		"cpu value=1 123 extra",
+		",host=a value=1",
+		"cpu,host value=1",
+		"cpu,host= value=1",
+		"cpu value",
+		"cpu =1",
+		"cpu value=abc",
+		"cpu value=1.5i",
+		"cpu value=NaN",
+		"cpu value=inf",
+		"cpu value=-Inf",
+		"cpu value=1e999",
+		"cpu value=18446744073709551615u",
+		`cpu value="open`,
+		"cpu value=1 yesterday",
+	} {
+		_, err := ParseLine(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParse(t *testing.T) {
+	points, err := Parse([]byte("# comment\nmem used=1i\n\ndisk free=2 10\n"))
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, "disk", points[1].Measurement)
+	assert.Equal(t, int64(10), points[1].Timestamp)
+
+	_, err = Parse([]byte("mem used=1i\nbroken\n"))
+	assert.EqualError(t, err, "line 2: expected measurement, fields and optional timestamp")
+}
@@ -0,0 +1,266 @@
+package procfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CgroupStats are CPU, memory and IO statistics of a cgroup
+type CgroupStats struct {
+	CPUUsage         uint64 // microseconds
+	CPUUser          uint64 // microseconds
+	CPUSystem        uint64 // microseconds
+	ThrottledPeriods uint64
+	ThrottledTime    uint64 // microseconds
+	MemoryUsage      uint64 // bytes
+	MemoryLimit      uint64 // bytes, 0 if unlimited
+	MemoryRSS        uint64 // bytes
+	MemoryCache      uint64 // bytes
+	IO               map[string]CgroupIO
+}
+
+// CgroupIO are IO statistics of a cgroup per device ("major:minor")
+type CgroupIO struct {
+	ReadBytes  uint64
+	WriteBytes uint64
+	Reads      uint64
+	Writes     uint64
+}
+
+// v1 reports "unlimited" memory as a huge page aligned number
+const v1UnlimitedMemory = 1 << 62
+
+// IsCgroupV2 reports whether root is a cgroup v2 (unified) hierarchy
+func IsCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// ReadCgroup reads statistics of the cgroup at path relative to root,
+// e.g. /sys/fs/cgroup and system.slice/app.service.
+// Statistics of disabled controllers are left zero
+func ReadCgroup(root, path string) (CgroupStats, error) {
+	if IsCgroupV2(root) {
+		return readCgroupV2(filepath.Join(root, path))
+	}
+	return readCgroupV1(root, path)
+}
+
+// ignoreMissing hides errors of files of disabled controllers
+func ignoreMissing(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func readUint(file string) (uint64, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+}
+
+func readCgroupV2(dir string) (CgroupStats, error) {
+	st := CgroupStats{IO: make(map[string]CgroupIO)}
+	if _, err := os.Stat(dir); err != nil {
+		return st, err
+	}
+
+	cpu, err := readKeyValues(filepath.Join(dir, "cpu.stat"), " ")
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.CPUUsage = cpu["usage_usec"]
+	st.CPUUser = cpu["user_usec"]
+	st.CPUSystem = cpu["system_usec"]
+	st.ThrottledPeriods = cpu["nr_throttled"]
+	st.ThrottledTime = cpu["throttled_usec"]
+
+	if st.MemoryUsage, err = readUint(filepath.Join(dir, "memory.current")); ignoreMissing(err) != nil {
+		return st, err
+	}
+	// memory.max is "max" when unlimited
+	buf, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	if s := strings.TrimSpace(string(buf)); err == nil && s != "max" {
+		if st.MemoryLimit, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return st, err
+		}
+	}
+	mem, err := readKeyValues(filepath.Join(dir, "memory.stat"), " ")
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.MemoryRSS = mem["anon"]
+	st.MemoryCache = mem["file"]
+
+	err = readIOStatV2(filepath.Join(dir, "io.stat"), st.IO)
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// readIOStatV2 parses io.stat lines like
+// "8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0"
+func readIOStatV2(file string, ret map[string]CgroupIO) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var io CgroupIO
+		for _, kv := range fields[1:] {
+			key, value, _ := strings.Cut(kv, "=")
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				io.ReadBytes = v
+			case "wbytes":
+				io.WriteBytes = v
+			case "rios":
+				io.Reads = v
+			case "wios":
+				io.Writes = v
+			}
+		}
+		ret[fields[0]] = io
+	}
+	return sc.Err()
+}
+
+// v1Controller returns the directory of the cgroup in the v1 controller
+// hierarchy. Controllers may be co-mounted, like cpu,cpuacct
+func v1Controller(root, path string, names ...string) string {
+	for _, name := range names {
+		dir := filepath.Join(root, name, path)
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return filepath.Join(root, names[0], path)
+}
+
+// v1Exists reports whether the cgroup exists in any of the controllers
+func v1Exists(root, path string) bool {
+	for _, name := range []string{"cpuacct", "cpu,cpuacct", "cpu", "memory", "blkio"} {
+		if _, err := os.Stat(filepath.Join(root, name, path)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func readCgroupV1(root, path string) (CgroupStats, error) {
+	st := CgroupStats{IO: make(map[string]CgroupIO)}
+	if !v1Exists(root, path) {
+		return st, fmt.Errorf("cgroup %s not found in %s: %w", path, root, fs.ErrNotExist)
+	}
+
+	cpuacct := v1Controller(root, path, "cpuacct", "cpu,cpuacct", "cpuacct,cpu")
+	usage, err := readUint(filepath.Join(cpuacct, "cpuacct.usage"))
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.CPUUsage = usage / 1000
+	// cpuacct.stat is in ticks
+	cpu, err := readKeyValues(filepath.Join(cpuacct, "cpuacct.stat"), " ")
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.CPUUser = cpu["user"] * 1000000 / UserHZ
+	st.CPUSystem = cpu["system"] * 1000000 / UserHZ
+
+	cpuDir := v1Controller(root, path, "cpu", "cpu,cpuacct", "cpuacct,cpu")
+	throttling, err := readKeyValues(filepath.Join(cpuDir, "cpu.stat"), " ")
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.ThrottledPeriods = throttling["nr_throttled"]
+	st.ThrottledTime = throttling["throttled_time"] / 1000
+
+	memory := v1Controller(root, path, "memory")
+	if st.MemoryUsage, err = readUint(filepath.Join(memory, "memory.usage_in_bytes")); ignoreMissing(err) != nil {
+		return st, err
+	}
+	if st.MemoryLimit, err = readUint(filepath.Join(memory, "memory.limit_in_bytes")); ignoreMissing(err) != nil {
+		return st, err
+	}
+	if st.MemoryLimit >= v1UnlimitedMemory {
+		st.MemoryLimit = 0
+	}
+	mem, err := readKeyValues(filepath.Join(memory, "memory.stat"), " ")
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	st.MemoryRSS = mem["total_rss"]
+	st.MemoryCache = mem["total_cache"]
+
+	blkio := v1Controller(root, path, "blkio")
+	err = readBlkioV1(filepath.Join(blkio, "blkio.throttle.io_service_bytes"), st.IO, true)
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	err = readBlkioV1(filepath.Join(blkio, "blkio.throttle.io_serviced"), st.IO, false)
+	if ignoreMissing(err) != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// readBlkioV1 parses blkio lines like "8:0 Read 1024"
+func readBlkioV1(file string, ret map[string]CgroupIO, bytes bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			// the "Total" line
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		io := ret[fields[0]]
+		switch {
+		case fields[1] == "Read" && bytes:
+			io.ReadBytes = v
+		case fields[1] == "Write" && bytes:
+			io.WriteBytes = v
+		case fields[1] == "Read":
+			io.Reads = v
+		case fields[1] == "Write":
+			io.Writes = v
+		default:
+			continue
+		}
+		ret[fields[0]] = io
+	}
+	return sc.Err()
+}
@@ -0,0 +1,54 @@
+package procfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCgroup(t *testing.T) {
+	want := CgroupStats{
+		CPUUsage:         5000000,
+		CPUUser:          3000000,
+		CPUSystem:        2000000,
+		ThrottledPeriods: 4,
+		ThrottledTime:    40000,
+		MemoryUsage:      104857600,
+		MemoryRSS:        73400320,
+		MemoryCache:      20971520,
+		IO: map[string]CgroupIO{
+			"8:0": {ReadBytes: 1048576, WriteBytes: 2097152, Reads: 100, Writes: 200},
+		},
+	}
+
+	t.Run("v2", func(t *testing.T) {
+		assert.True(t, IsCgroupV2("testdata/cgroup/v2"))
+		st, err := ReadCgroup("testdata/cgroup/v2", "app.slice")
+		require.NoError(t, err)
+		want := want
+		want.MemoryLimit = 268435456
+		assert.Equal(t, want, st)
+
+		// memory and io controllers are not enabled at the root
+		st, err = ReadCgroup("testdata/cgroup/v2", "/")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(9000000), st.CPUUsage)
+		assert.Zero(t, st.MemoryUsage)
+		assert.Empty(t, st.IO)
+
+		_, err = ReadCgroup("testdata/cgroup/v2", "nonexistent")
+		assert.Error(t, err)
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		assert.False(t, IsCgroupV2("testdata/cgroup/v1"))
+		st, err := ReadCgroup("testdata/cgroup/v1", "app")
+		require.NoError(t, err)
+		// memory.limit_in_bytes means no limit
+		assert.Equal(t, want, st)
+
+		_, err = ReadCgroup("testdata/cgroup/v1", "nonexistent")
+		assert.Error(t, err)
+	})
+}
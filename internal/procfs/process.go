@@ -0,0 +1,194 @@
+// Package procfs reads process statistics from procfs and container
+// statistics from cgroup v1 and v2 filesystems.
+// All functions take the filesystem root, so they can work with
+// the host filesystems mounted elsewhere and with test trees.
+package procfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UserHZ is the clock tick rate of CPU times in procfs
+const UserHZ = 100
+
+// ProcStat is the part of /proc/<pid>/stat used by the agent
+type ProcStat struct {
+	Comm       string
+	State      string
+	PID        int
+	UTime      uint64 // ticks
+	STime      uint64 // ticks
+	NumThreads int64
+	VSize      uint64 // bytes
+	RSS        int64  // pages
+}
+
+// ProcStatus is the part of /proc/<pid>/status used by the agent
+type ProcStatus struct {
+	VmRSS                    uint64 // bytes
+	VmSwap                   uint64 // bytes
+	VoluntaryCtxtSwitches    uint64
+	NonvoluntaryCtxtSwitches uint64
+}
+
+// ProcIO is /proc/<pid>/io
+type ProcIO struct {
+	RChar      uint64
+	WChar      uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+func pidDir(root string, pid int) string {
+	return filepath.Join(root, strconv.Itoa(pid))
+}
+
+// ListPIDs returns PIDs of all processes in the proc root
+func ListPIDs(root string) ([]int, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// ReadProcStat parses /proc/<pid>/stat
+func ReadProcStat(root string, pid int) (ProcStat, error) {
+	buf, err := os.ReadFile(filepath.Join(pidDir(root, pid), "stat"))
+	if err != nil {
+		return ProcStat{}, err
+	}
+	return parseProcStat(string(buf))
+}
+
+func parseProcStat(s string) (ProcStat, error) {
+	var st ProcStat
+	// comm may contain spaces and parentheses, so it ends
+	// at the last closing parenthesis
+	open := strings.IndexByte(s, '(')
+	closing := strings.LastIndexByte(s, ')')
+	if open < 0 || closing < open {
+		return st, errors.New("bad stat format")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(s[:open]))
+	if err != nil {
+		return st, fmt.Errorf("bad stat pid: %w", err)
+	}
+	st.PID = pid
+	st.Comm = s[open+1 : closing]
+
+	// fields after comm, starting from state (field 3)
+	fields := strings.Fields(s[closing+1:])
+	if len(fields) < 22 {
+		return st, errors.New("bad stat format")
+	}
+	st.State = fields[0]
+	parsers := []struct {
+		dst   *uint64
+		field int
+	}{
+		{&st.UTime, 14},
+		{&st.STime, 15},
+		{&st.VSize, 23},
+	}
+	for _, p := range parsers {
+		*p.dst, err = strconv.ParseUint(fields[p.field-3], 10, 64)
+		if err != nil {
+			return st, fmt.Errorf("bad stat field %d: %w", p.field, err)
+		}
+	}
+	if st.NumThreads, err = strconv.ParseInt(fields[20-3], 10, 64); err != nil {
+		return st, fmt.Errorf("bad stat field 20: %w", err)
+	}
+	if st.RSS, err = strconv.ParseInt(fields[24-3], 10, 64); err != nil {
+		return st, fmt.Errorf("bad stat field 24: %w", err)
+	}
+	return st, nil
+}
+
+// readKeyValues parses files of "key: value" or "key value" lines.
+// Values with the kB unit are converted to bytes
+func readKeyValues(file string, sep string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]uint64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), sep)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		ret[strings.TrimSpace(key)] = v
+	}
+	return ret, sc.Err()
+}
+
+// ReadProcStatus parses /proc/<pid>/status
+func ReadProcStatus(root string, pid int) (ProcStatus, error) {
+	kv, err := readKeyValues(filepath.Join(pidDir(root, pid), "status"), ":")
+	if err != nil {
+		return ProcStatus{}, err
+	}
+	return ProcStatus{
+		VmRSS:                    kv["VmRSS"],
+		VmSwap:                   kv["VmSwap"],
+		VoluntaryCtxtSwitches:    kv["voluntary_ctxt_switches"],
+		NonvoluntaryCtxtSwitches: kv["nonvoluntary_ctxt_switches"],
+	}, nil
+}
+
+// ReadProcIO parses /proc/<pid>/io. The file is readable only
+// by the process owner
+func ReadProcIO(root string, pid int) (ProcIO, error) {
+	kv, err := readKeyValues(filepath.Join(pidDir(root, pid), "io"), ":")
+	if err != nil {
+		return ProcIO{}, err
+	}
+	return ProcIO{
+		RChar:      kv["rchar"],
+		WChar:      kv["wchar"],
+		ReadBytes:  kv["read_bytes"],
+		WriteBytes: kv["write_bytes"],
+	}, nil
+}
+
+// CountFDs returns the number of open file descriptors of the process
+func CountFDs(root string, pid int) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(pidDir(root, pid), "fd"))
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,61 @@
+package procfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPIDs(t *testing.T) {
+	pids, err := ListPIDs("testdata/proc")
+	require.NoError(t, err)
+	assert.Equal(t, []int{42, 43}, pids)
+}
+
+func TestReadProcStat(t *testing.T) {
+	st, err := ReadProcStat("testdata/proc", 42)
+	require.NoError(t, err)
+	assert.Equal(t, ProcStat{
+		Comm:       "my (app) srv",
+		State:      "S",
+		PID:        42,
+		UTime:      250,
+		STime:      50,
+		NumThreads: 7,
+		VSize:      104857600,
+		RSS:        2560,
+	}, st)
+
+	_, err = ReadProcStat("testdata/proc", 44)
+	assert.Error(t, err)
+
+	_, err = parseProcStat("42 (app) S 1 2 3")
+	assert.Error(t, err)
+}
+
+func TestReadProcStatus(t *testing.T) {
+	st, err := ReadProcStatus("testdata/proc", 42)
+	require.NoError(t, err)
+	assert.Equal(t, ProcStatus{
+		VmRSS:                    10240 * 1024,
+		VmSwap:                   512 * 1024,
+		VoluntaryCtxtSwitches:    120,
+		NonvoluntaryCtxtSwitches: 3,
+	}, st)
+}
+
+func TestReadProcIO(t *testing.T) {
+	io, err := ReadProcIO("testdata/proc", 42)
+	require.NoError(t, err)
+	assert.Equal(t, ProcIO{RChar: 4096, WChar: 2048, ReadBytes: 8192, WriteBytes: 1024}, io)
+
+	_, err = ReadProcIO("testdata/proc", 43)
+	assert.Error(t, err)
+}
+
+func TestCountFDs(t *testing.T) {
+	n, err := CountFDs("testdata/proc", 42)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
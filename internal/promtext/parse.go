@@ -0,0 +1,165 @@
+// Package promtext parses the Prometheus text exposition format
+package promtext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the TYPE lines
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+	TypeUntyped   = "untyped"
+)
+
+// Sample is a single line of the exposition
+type Sample struct {
+	// Name is the sample name, e.g. http_duration_seconds_bucket
+	Name string
+	// Family is the name of the metric the sample belongs to,
+	// e.g. http_duration_seconds for all histogram series
+	Family string
+	// Type is the type of the family, untyped if not declared
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Parse reads all samples. Timestamps and HELP lines are ignored
+func Parse(r io.Reader) ([]Sample, error) {
+	types := make(map[string]string)
+	var samples []Sample
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		s.Family, s.Type = family(types, s.Name)
+		samples = append(samples, s)
+	}
+	return samples, sc.Err()
+}
+
+// family finds the declared metric of the sample. Histogram and summary
+// samples have _bucket, _sum and _count suffixes
+func family(types map[string]string, name string) (string, string) {
+	if typ, ok := types[name]; ok {
+		return name, typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if base == name {
+			continue
+		}
+		typ := types[base]
+		if typ == TypeHistogram || (typ == TypeSummary && suffix != "_bucket") {
+			return base, typ
+		}
+	}
+	return name, TypeUntyped
+}
+
+// parseSample parses `name{label="value",...} value [timestamp]`
+func parseSample(line string) (Sample, error) {
+	var s Sample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, errors.New("no value")
+	}
+	s.Name = line[:end]
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, n, err := parseLabels(rest[1:])
+		if err != nil {
+			return s, err
+		}
+		s.Labels = labels
+		rest = rest[1+n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, errors.New("expected value and optional timestamp")
+	}
+	v, err := parseValue(fields[0])
+	if err != nil {
+		return s, err
+	}
+	s.Value = v
+	return s, nil
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseLabels parses labels after the opening brace and returns
+// the number of bytes read including the closing brace
+func parseLabels(s string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return nil, 0, errors.New("bad labels")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var v strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				if s[i] == 'n' {
+					v.WriteByte('\n')
+				} else {
+					v.WriteByte(s[i])
+				}
+				continue
+			}
+			v.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return nil, 0, errors.New("unterminated label value")
+		}
+		i++
+		labels[name] = v.String()
+	}
+}
@@ -0,0 +1,67 @@
+package promtext
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/metrics.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	samples, err := Parse(f)
+	require.NoError(t, err)
+	require.Len(t, samples, 14)
+
+	assert.Equal(t, Sample{
+		Name:   "http_requests_total",
+		Family: "http_requests_total",
+		Type:   TypeCounter,
+		Labels: map[string]string{"method": "post", "code": "200"},
+		Value:  1027,
+	}, samples[0])
+
+	assert.Equal(t, map[string]string{
+		"path":  `C:\DIR\FILE.TXT`,
+		"error": "Cannot find file:\n\"FILE.TXT\"",
+	}, samples[2].Labels)
+	assert.Equal(t, TypeUntyped, samples[2].Type)
+
+	assert.Equal(t, "metric_without_timestamp_and_labels", samples[3].Name)
+	assert.Nil(t, samples[3].Labels)
+	assert.Equal(t, 12.47, samples[3].Value)
+
+	assert.True(t, math.IsInf(samples[4].Value, -1))
+	assert.Equal(t, map[string]string{"room": "kitchen"}, samples[4].Labels)
+
+	for _, s := range samples[5:10] {
+		assert.Equal(t, "http_request_duration_seconds", s.Family)
+		assert.Equal(t, TypeHistogram, s.Type)
+	}
+	assert.Equal(t, "+Inf", samples[7].Labels["le"])
+
+	for _, s := range samples[10:] {
+		assert.Equal(t, "rpc_duration_seconds", s.Family)
+		assert.Equal(t, TypeSummary, s.Type)
+	}
+	assert.True(t, math.IsNaN(samples[11].Value))
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		"no_value",
+		"bad_value x",
+		`unterminated{a="b} 1`,
+		`no_quotes{a=b} 1`,
+		"too_many 1 2 3",
+	} {
+		_, err := Parse(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
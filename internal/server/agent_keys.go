@@ -0,0 +1,172 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// keyRegistry keeps the keys of the agents
+type keyRegistry interface {
+	// AgentKey returns the key of the agent, false if the agent
+	// is unknown or its key is revoked
+	AgentKey(agentID string) (string, bool)
+}
+
+// fileKeys is the registry kept in a JSON file, {"agent-id": "key", ...}.
+// The file is read again when it changes, so the keys can be added
+// and revoked without restarting the server
+type fileKeys struct {
+	path    string
+	keys    map[string]string
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+// newFileKeys reads the keys from the file
+func newFileKeys(path string) (*fileKeys, error) {
+	f := &fileKeys{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileKeys) load(modTime time.Time) error {
+	buf, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var keys map[string]string
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return fmt.Errorf("agent keys %s: %w", f.path, err)
+	}
+	for id, key := range keys {
+		if id == "" || key == "" {
+			return fmt.Errorf("agent keys %s: empty agent ID or key", f.path)
+		}
+	}
+	f.keys = keys
+	f.modTime = modTime
+	return nil
+}
+
+// AgentKey returns the key of the agent. If the file can't be read
+// again the keys read before are used
+func (f *fileKeys) AgentKey(agentID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Print(err)
+	} else if !info.ModTime().Equal(f.modTime) {
+		if err := f.load(info.ModTime()); err != nil {
+			log.Print(err)
+		}
+	}
+	key, ok := f.keys[agentID]
+	return key, ok
+}
+
+var initAgentKeysStatement = "CREATE TABLE IF NOT EXISTS agent_keys (agent_id VARCHAR (128) PRIMARY KEY, key TEXT NOT NULL)"
+
+// dbKeys is the registry kept in the agent_keys table,
+// a key is revoked by deleting its row
+type dbKeys struct {
+	db *sql.DB
+}
+
+func newDBKeys(db *sql.DB) (*dbKeys, error) {
+	if _, err := db.Exec(initAgentKeysStatement); err != nil {
+		return nil, fmt.Errorf("failed to init agent keys table: %v", err)
+	}
+	return &dbKeys{db: db}, nil
+}
+
+func (k *dbKeys) AgentKey(agentID string) (string, bool) {
+	var key string
+	err := k.db.QueryRow("SELECT key FROM agent_keys WHERE agent_id = $1", agentID).Scan(&key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Print(err)
+		}
+		return "", false
+	}
+	return key, true
+}
+
+// loadAgentKeys returns the registry configured, from the database
+// of the storage or from the file, nil if there is none
+func loadAgentKeys(cfg ConfigType, st Storage) (keyRegistry, error) {
+	switch {
+	case cfg.AgentKeysDB:
+		dbst, ok := st.(*DBStorage)
+		if !ok {
+			return nil, errors.New("agent keys in the database require the database storage")
+		}
+		return newDBKeys(dbst.db)
+	case cfg.AgentKeysFile != "":
+		return newFileKeys(cfg.AgentKeysFile)
+	}
+	return nil, nil
+}
+
+var errEnvelopeRequired = errors.New("writes must be signed with an envelope")
+
+// envelopeRequired tells if only batches signed with an envelope are
+// accepted, as configured or with the agent keys. With the agent keys
+// the shared key and unsigned writes are refused, so a revoked agent
+// can't fall back to them
+func (s *Server) envelopeRequired() bool {
+	return s.cfg.RequireEnvelope || s.agentKeys != nil
+}
+
+// envelopeKey returns the key to check the batch signed by the agent with
+func (s *Server) envelopeKey(agentID string) (string, error) {
+	if s.agentKeys == nil {
+		return s.cfg.Key, nil
+	}
+	if agentID == "" {
+		return "", errors.New("no agent ID")
+	}
+	key, ok := s.agentKeys.AgentKey(agentID)
+	if !ok || key == "" {
+		return "", fmt.Errorf("unknown agent %q", agentID)
+	}
+	return key, nil
+}
+
+// RequireEnvelope is chi middleware function refusing the writes,
+// which can't be signed with an envelope, if envelopes are required
+func (s *Server) RequireEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.envelopeRequired() {
+			log.Print(errEnvelopeRequired)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// agentLabels returns the labels with the agent label added if it is
+// configured. It overrides the label sent, so an agent can't pose as
+// another one
+func (s *Server) agentLabels(labels map[string]string, agentID string) map[string]string {
+	if s.cfg.AgentLabel == "" || agentID == "" {
+		return labels
+	}
+	return common.MergeLabels(labels, map[string]string{s.cfg.AgentLabel: agentID})
+}
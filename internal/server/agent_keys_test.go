@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+// agentKeysFile writes the keys file and returns its name
+func agentKeysFile(t *testing.T, keys string) string {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(file, []byte(keys), 0o600))
+	return file
+}
+
+func TestFileKeys(t *testing.T) {
+	file := agentKeysFile(t, `{"web1": "key1", "web2": "key2"}`)
+	agentKeys := newTestServer(t, ConfigType{AgentKeysFile: file}, NewMemStorage()).agentKeys
+
+	key, ok := agentKeys.AgentKey("web1")
+	assert.True(t, ok)
+	assert.Equal(t, "key1", key)
+	_, ok = agentKeys.AgentKey("web3")
+	assert.False(t, ok)
+
+	// web2 is revoked without restarting the server
+	require.NoError(t, os.WriteFile(file, []byte(`{"web1": "key1"}`), 0o600))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, later, later))
+	_, ok = agentKeys.AgentKey("web2")
+	assert.False(t, ok)
+
+	// a broken file keeps the keys read before
+	require.NoError(t, os.WriteFile(file, []byte(`{`), 0o600))
+	later = later.Add(time.Second)
+	require.NoError(t, os.Chtimes(file, later, later))
+	_, ok = agentKeys.AgentKey("web1")
+	assert.True(t, ok)
+
+	_, err := newFileKeys(filepath.Join(t.TempDir(), "none.json"))
+	assert.Error(t, err)
+	_, err = NewServer(ConfigType{AgentKeysDB: true}, NewMemStorage())
+	assert.Error(t, err)
+}
+
+func TestJSONUpdateAgentKeys(t *testing.T) {
+	cfg := ConfigType{
+		AgentKeysFile: agentKeysFile(t, `{"web1": "key1"}`),
+		AgentLabel:    "agent",
+	}
+
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, cfg, st)))
+	defer ts.Close()
+
+	post := func(agentID, key string) int {
+		delta := int64(1)
+		e := common.Envelope{
+			AgentID: agentID,
+			Metrics: []common.Metrics{{
+				ID:     "PollCount",
+				MType:  common.NameCounter,
+				Delta:  &delta,
+				Labels: map[string]string{"agent": "web2"},
+			}},
+		}
+		require.NoError(t, e.Sign(key))
+		body, err := json.Marshal(e)
+		require.NoError(t, err)
+		resp, _ := testRequest(t, ts, http.MethodPost, "/updates/", bytes.NewReader(body), true)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post("web1", "key1"))
+	assert.Equal(t, http.StatusBadRequest, post("web1", "key2"))
+	assert.Equal(t, http.StatusBadRequest, post("web2", "key1"))
+
+	// the agent label is set by the server
+	c, _ := st.GetCounter(`PollCount{agent="web1"}`)
+	assert.Equal(t, int64(1), c)
+	assert.Len(t, st.List().Counters, 1)
+}
+
+func TestGRPCAgentKeys(t *testing.T) {
+	cfg := ConfigType{
+		AgentKeysFile: agentKeysFile(t, `{"web1": "key1"}`),
+		AgentLabel:    "agent",
+	}
+
+	st := NewMemStorage()
+	client := startTestGRPC(t, cfg, st)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := func(agentID, key string) string {
+		mm := []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2}}
+		env, err := grpcint.SignEnvelope(mm, agentID, key)
+		require.NoError(t, err)
+		resp, err := client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 1, Metrices: mm, Envelope: env})
+		require.NoError(t, err)
+		return resp.Error
+	}
+
+	assert.Empty(t, update("web1", "key1"))
+	assert.NotEmpty(t, update("web1", "key2"))
+	assert.NotEmpty(t, update("web2", "key1"))
+
+	c, _ := st.GetCounter(`PollCount{agent="web1"}`)
+	assert.Equal(t, int64(2), c)
+	assert.Len(t, st.List().Counters, 1)
+}
+
+func TestRevokedAgent(t *testing.T) {
+	// web2 is revoked, the shared key is not set
+	cfg := ConfigType{AgentKeysFile: agentKeysFile(t, `{"web1": "key1"}`)}
+
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, cfg, st)))
+	defer ts.Close()
+
+	envelope := func(agentID, key string) string {
+		delta := int64(1)
+		e := common.Envelope{
+			AgentID: agentID,
+			Metrics: []common.Metrics{{ID: "PollCount", MType: common.NameCounter, Delta: &delta}},
+		}
+		require.NoError(t, e.Sign(key))
+		body, err := json.Marshal(e)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"revoked agent", "/updates/", envelope("web2", "key2"), http.StatusBadRequest},
+		{"no agent ID", "/updates/", envelope("", "key2"), http.StatusBadRequest},
+		{"legacy batch", "/updates/", `[{"id":"PollCount","type":"counter","delta":1}]`, http.StatusForbidden},
+		{"legacy update", "/update/", `{"id":"PollCount","type":"counter","delta":1}`, http.StatusForbidden},
+		{"URL update", "/update/counter/PollCount/1", "", http.StatusForbidden},
+		{"InfluxDB", "/write", "PollCount value=1i", http.StatusForbidden},
+		{"OTLP", "/v1/metrics", "{}", http.StatusForbidden},
+		{"remote write", "/api/v1/write", "", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodPost, tt.path, bytes.NewReader([]byte(tt.body)), true)
+			resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+
+	client := startTestGRPC(t, cfg, st)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mm := []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 1}}
+	for _, agentID := range []string{"", "web2", "-"} {
+		var env *pb.Envelope
+		if agentID != "-" {
+			var err error
+			env, err = grpcint.SignEnvelope(mm, agentID, "key2")
+			require.NoError(t, err)
+		}
+		resp, err := client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 1, Metrices: mm, Envelope: env})
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.Error, "agent %q", agentID)
+
+		stream, err := client.StreamMetrices(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.MetricsBatch{Id: 1, Metrices: mm, Envelope: env}))
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.NotEmpty(t, ack.Error, "agent %q", agentID)
+		require.NoError(t, stream.CloseSend())
+	}
+
+	assert.Empty(t, st.List().Counters)
+}
@@ -7,27 +7,78 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 	"github.com/alexey-mavrin/go-musthave-devops/internal/crypt"
 )
 
 // DecryptBody is chi middleware function used to decrypt the received body
-func DecryptBody(next http.Handler) http.Handler {
+// with the server private key
+func (s *Server) DecryptBody(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		r2 := r.Clone(r.Context())
-		if privateServerKey != nil {
+		if s.privateKey != nil {
 			body, _ := ioutil.ReadAll(r.Body)
-			decryptedBytes, err := crypt.DecryptOAEP(
-				sha256.New(),
-				crand.Reader,
-				privateServerKey,
-				body,
-				nil)
+			var decryptedBytes []byte
+			var err error
+			switch r.Header.Get(crypt.EncryptionHeader) {
+			case crypt.SchemeHybrid:
+				decryptedBytes, err = crypt.DecryptHybrid(crand.Reader, s.privateKey, body)
+			case "":
+				// legacy agents split the body into RSA-OAEP blocks
+				decryptedBytes, err = crypt.DecryptOAEP(
+					sha256.New(),
+					crand.Reader,
+					s.privateKey,
+					body,
+					nil)
+			default:
+				http.Error(rw, "unknown encryption scheme", http.StatusBadRequest)
+				return
+			}
 			if err != nil {
-				panic(err)
+				http.Error(rw, "unable to decrypt body", http.StatusBadRequest)
+				return
 			}
 			r2.Body = ioutil.NopCloser(bytes.NewReader(decryptedBytes))
-
+			r2.ContentLength = int64(len(decryptedBytes))
+			r2.Header.Del(crypt.EncryptionHeader)
 		}
 		next.ServeHTTP(rw, r2)
 	})
 }
+
+// CheckBodyHash is chi middleware function used to check the body hash
+// of writes which have no per-metric hashes
+func (s *Server) CheckBodyHash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if s.cfg.Key == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(rw, "unable to read body", http.StatusBadRequest)
+			return
+		}
+		if err := common.CheckBodyHash(s.cfg.Key, body, r.Header.Get(common.BodyHashHeader)); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// ReceiverAuth is chi middleware function authenticating the writes of
+// stock clients (Telegraf, Prometheus remote_write, OpenTelemetry), which
+// can send a bearer token but can't sign the metrics. With API
+// tokens configured the token checked by RequireScope is enough, without
+// tokens the body must be signed as CheckBodyHash requires. Such writes
+// can't be signed with an envelope, so they are refused if envelopes
+// are required, with or without tokens
+func (s *Server) ReceiverAuth(next http.Handler) http.Handler {
+	if s.apiTokens == nil {
+		next = s.CheckBodyHash(next)
+	}
+	return s.RequireEnvelope(next)
+}
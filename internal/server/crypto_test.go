@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/crypt"
+)
+
+func TestDecryptBody(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := newTestServer(t, ConfigType{}, NewMemStorage())
+	s.privateKey = key
+
+	msg := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	handler := s.DecryptBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	oaep, err := crypt.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, msg, nil)
+	require.NoError(t, err)
+	hybrid, err := crypt.EncryptHybrid(rand.Reader, &key.PublicKey, msg)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		scheme string
+		body   []byte
+		code   int
+	}{
+		{name: "legacy OAEP", body: oaep, code: http.StatusOK},
+		{name: "hybrid", scheme: crypt.SchemeHybrid, body: hybrid, code: http.StatusOK},
+		{name: "hybrid without header", body: hybrid, code: http.StatusBadRequest},
+		{name: "unknown scheme", scheme: "rot13", body: hybrid, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				req.Header.Set(crypt.EncryptionHeader, tt.scheme)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code == http.StatusOK {
+				assert.Equal(t, msg, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestReceiverAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	cfg := ConfigType{Key: "secret"}
+	// newServer starts the server with the private key
+	newServer := func(cfg ConfigType, st Storage) *httptest.Server {
+		s := newTestServer(t, cfg, st)
+		s.privateKey = key
+		return httptest.NewServer(Router(s))
+	}
+
+	remoteWrite, err := os.ReadFile("testdata/remote_write.bin")
+	require.NoError(t, err)
+	otlp, err := proto.Marshal(&colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+				{Name: "load", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+					DataPoints: []*metricspb.NumberDataPoint{
+						{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.5}},
+					},
+				}}},
+			}}},
+		}},
+	})
+	require.NoError(t, err)
+
+	// the headers sent by Prometheus and the OTLP/HTTP exporter,
+	// the bodies are neither signed nor encrypted
+	send := func(ts *httptest.Server, token string) (int, int) {
+		post := func(path string, body []byte, headers map[string]string) int {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+			require.NoError(t, err)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		return post("/api/v1/write", remoteWrite, map[string]string{
+				"Content-Encoding":                  "snappy",
+				"Content-Type":                      "application/x-protobuf",
+				"User-Agent":                        "Prometheus/2.37.0",
+				"X-Prometheus-Remote-Write-Version": "0.1.0",
+			}), post("/v1/metrics", otlp, map[string]string{
+				"Content-Type": "application/x-protobuf",
+				"User-Agent":   "OTel-OTLP-Exporter-Go/1.11.0",
+			})
+	}
+
+	// without tokens the unsigned bodies are refused
+	st := NewMemStorage()
+	ts := newServer(cfg, st)
+	defer ts.Close()
+	rw, ot := send(ts, "")
+	assert.Equal(t, http.StatusBadRequest, rw)
+	assert.Equal(t, http.StatusBadRequest, ot)
+	assert.Empty(t, st.List().Gauges)
+
+	// the bearer token authenticates them
+	cfg.TokensFile = tokensFile(t)
+	ts = newServer(cfg, st)
+	defer ts.Close()
+	rw, ot = send(ts, "")
+	assert.Equal(t, http.StatusUnauthorized, rw)
+	assert.Equal(t, http.StatusUnauthorized, ot)
+	rw, ot = send(ts, "w-token")
+	assert.Equal(t, http.StatusNoContent, rw)
+	assert.Equal(t, http.StatusOK, ot)
+	assert.Equal(t, 0.5, st.List().Gauges["load"])
+	assert.Equal(t, 5.2, st.List().Gauges[`process_cpu_seconds{job="api"}`])
+
+	// the token doesn't replace the required envelope
+	cfg.RequireEnvelope = true
+	st = NewMemStorage()
+	ts = newServer(cfg, st)
+	defer ts.Close()
+	rw, ot = send(ts, "w-token")
+	assert.Equal(t, http.StatusForbidden, rw)
+	assert.Equal(t, http.StatusForbidden, ot)
+	assert.Empty(t, st.List().Gauges)
+}
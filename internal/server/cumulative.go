@@ -0,0 +1,49 @@
+package server
+
+import "sync"
+
+// cumulativeCounters converts running totals reported by senders
+// into deltas. Each series is expected to have a single sender
+type cumulativeCounters struct {
+	last map[string]int64
+	mu   sync.Mutex
+}
+
+func newCumulativeCounters() *cumulativeCounters {
+	return &cumulativeCounters{last: make(map[string]int64)}
+}
+
+// add adds the increase of the series since the previous value to
+// the counter. The value becomes the base only once it is stored,
+// so the increase of a failed write is added with the next value
+func (c *cumulativeCounters) add(st Storage, name string, value int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := st.AddCounter(name, c.increase(st, name, value)); err != nil {
+		return err
+	}
+	c.last[name] = value
+	return nil
+}
+
+// increase returns the increase of the series since the previous value.
+// A value less than the previous one means the sender was restarted
+// and counts from zero again.
+// The first value of a series already stored (e.g. after the server
+// restart) only sets the base, as the server can't tell what part of
+// it is already counted
+func (c *cumulativeCounters) increase(st Storage, name string, value int64) int64 {
+	last, ok := c.last[name]
+	switch {
+	case !ok:
+		if _, known := st.GetCounter(name); known {
+			return 0
+		}
+		return value
+	case value < last:
+		return value
+	default:
+		return value - last
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cumulativeCounters(t *testing.T) {
+	st := NewMemStorage()
+	c := newCumulativeCounters()
+
+	for _, tt := range []struct {
+		value int64
+		total int64
+	}{
+		{value: 5, total: 5},
+		{value: 8, total: 8},
+		{value: 8, total: 8},
+		// the sender is restarted
+		{value: 2, total: 10},
+		{value: 4, total: 12},
+	} {
+		require.NoError(t, c.add(st, "PollCount", tt.value))
+		total, _ := st.GetCounter("PollCount")
+		assert.Equal(t, tt.total, total, "value %d", tt.value)
+	}
+
+	// the server is restarted with the stored total
+	c = newCumulativeCounters()
+	require.NoError(t, c.add(st, "PollCount", 10))
+	require.NoError(t, c.add(st, "PollCount", 11))
+	total, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(13), total)
+}
+
+// flakyStorage fails the counter writes while it is down
+type flakyStorage struct {
+	*MemStorage
+	down atomic.Bool
+}
+
+func (s *flakyStorage) AddCounter(name string, delta int64) (int64, error) {
+	if s.down.Load() {
+		return 0, errors.New("storage is down")
+	}
+	return s.MemStorage.AddCounter(name, delta)
+}
+
+func Test_cumulativeCountersFailedWrite(t *testing.T) {
+	st := &flakyStorage{MemStorage: NewMemStorage()}
+	c := newCumulativeCounters()
+
+	require.NoError(t, c.add(st, "PollCount", 5))
+	st.down.Store(true)
+	assert.Error(t, c.add(st, "PollCount", 8))
+	st.down.Store(false)
+	// the increase of the failed write is not lost
+	require.NoError(t, c.add(st, "PollCount", 8))
+	total, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(8), total)
+}
+
+func TestUpdateCumulative(t *testing.T) {
+	st := NewMemStorage()
+	s := newTestServer(t, ConfigType{CumulativeCounters: true}, st)
+	for _, v := range []int64{3, 7, 1} {
+		err := s.updateStatStorage(statReq{
+			statType:     statTypeCounter,
+			name:         "PollCount",
+			valueCounter: v,
+		})
+		assert.NoError(t, err)
+	}
+	total, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(8), total)
+}
+
+func TestUpdateCumulativeServers(t *testing.T) {
+	// every server keeps its own baselines
+	cfg := ConfigType{CumulativeCounters: true}
+	st1, st2 := NewMemStorage(), NewMemStorage()
+	s1, s2 := newTestServer(t, cfg, st1), newTestServer(t, cfg, st2)
+	stat := statReq{statType: statTypeCounter, name: "PollCount", valueCounter: 10}
+	require.NoError(t, s1.updateStatStorage(stat))
+	require.NoError(t, s2.updateStatStorage(stat))
+
+	total, _ := st2.GetCounter("PollCount")
+	assert.Equal(t, int64(10), total)
+}
@@ -5,110 +5,279 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"database/sql"
 	// use as a sql driver
 	_ "github.com/jackc/pgx/v4/stdlib"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 )
 
-var db *sql.DB
+// DBStorage keeps metrics in Postgres. The values are cached in memory,
+// every update is written through to the database.
+type DBStorage struct {
+	*MemStorage
+	db        *sql.DB
+	done      chan struct{}
+	wg        sync.WaitGroup
+	retention time.Duration
+	mu        sync.Mutex
+}
 
-func connectDB() error {
-	var err error
-	db, err = sql.Open("pgx", Config.DatabaseDSN)
+// NewDBStorage connects to the database and prepares the tables
+func NewDBStorage(dsn string, restore bool) (*DBStorage, error) {
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
-		return fmt.Errorf("unable to connect to database: %v", err)
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	s := &DBStorage{
+		MemStorage: NewMemStorage(),
+		db:         db,
+		done:       make(chan struct{}),
+	}
+
+	if err := s.initDBTable(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init db tables: %v", err)
+	}
+
+	if restore {
+		if err := s.load(); err != nil {
+			log.Print(err)
+		}
+	}
+	return s, nil
+}
+
+var initDBStatements = []string{
+	"CREATE TABLE IF NOT EXISTS gauges (id serial PRIMARY KEY, name VARCHAR (128) NOT NULL, labels TEXT NOT NULL DEFAULT '', value DOUBLE PRECISION NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS counters (id serial PRIMARY KEY, name VARCHAR (128) NOT NULL, labels TEXT NOT NULL DEFAULT '', value BIGINT NOT NULL)",
+	// tables created before labels were introduced are keyed by name only
+	"ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_name_key",
+	"ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_name_key",
+	"CREATE UNIQUE INDEX IF NOT EXISTS gauges_name_labels_idx ON gauges (name, labels)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS counters_name_labels_idx ON counters (name, labels)",
+	"CREATE TABLE IF NOT EXISTS gauge_history (name VARCHAR (128) NOT NULL, labels TEXT NOT NULL DEFAULT '', ts TIMESTAMPTZ NOT NULL, value DOUBLE PRECISION NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS counter_history (name VARCHAR (128) NOT NULL, labels TEXT NOT NULL DEFAULT '', ts TIMESTAMPTZ NOT NULL, value BIGINT NOT NULL)",
+	"CREATE INDEX IF NOT EXISTS gauge_history_idx ON gauge_history (name, labels, ts)",
+	"CREATE INDEX IF NOT EXISTS counter_history_idx ON counter_history (name, labels, ts)",
+}
+
+func (s *DBStorage) initDBTable() error {
+	for _, stmt := range initDBStatements {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func initDBTable() error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gauges (id serial PRIMARY KEY, name VARCHAR (128) UNIQUE NOT NULL, value DOUBLE PRECISION NOT NULL)")
+// SetGauge writes the gauge value to the database
+func (s *DBStorage) SetGauge(name string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metric, labels := common.SplitSeriesKey(name)
+	err := s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO gauges (name, labels, value) VALUES ($1, $2, $3) ON CONFLICT(name, labels) DO UPDATE set value = $3", metric, labels, value)
+		if err != nil || s.retention == 0 {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO gauge_history (name, labels, ts, value) VALUES ($1, $2, $3, $4)", metric, labels, time.Now(), value)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	return s.MemStorage.SetGauge(name, value)
+}
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS counters (id serial PRIMARY KEY, name VARCHAR (128) UNIQUE NOT NULL, value BIGINT NOT NULL)")
-	if err != nil {
+// AddCounter adds delta to the counter and writes the result to the database
+func (s *DBStorage) AddCounter(name string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, _ := s.MemStorage.GetCounter(name)
+	val += delta
+	metric, labels := common.SplitSeriesKey(name)
+	err := s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO counters (name, labels, value) VALUES ($1, $2, $3) ON CONFLICT(name, labels) DO UPDATE SET value = $3", metric, labels, val)
+		if err != nil || s.retention == 0 {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO counter_history (name, labels, ts, value) VALUES ($1, $2, $3, $4)", metric, labels, time.Now(), val)
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
-
-	return nil
+	return s.MemStorage.AddCounter(name, delta)
 }
 
-func storeGaugeDB(name string, gauge float64) error {
-	_, err := db.Exec("INSERT INTO gauges (name, value) VALUES ($1, $2) ON CONFLICT(name) DO UPDATE set value = $2", name, gauge)
+func (s *DBStorage) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func storeCounterDB(name string, counter int64) error {
-	_, err := db.Exec("INSERT INTO counters (name, value) VALUES ($1, $2) ON CONFLICT(name) DO UPDATE SET value = $2", name, counter)
+// EnableHistory makes the storage keep every sample in the history
+// tables, samples older than retention are removed periodically
+func (s *DBStorage) EnableHistory(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retention == 0 {
+		s.wg.Add(1)
+		go s.historyCleaner()
+	}
+	s.retention = retention
+}
+
+// History returns the samples of the metric within [from, to]
+func (s *DBStorage) History(typ, name string, from, to time.Time) ([]Point, error) {
+	var query string
+	switch typ {
+	case strTypGauge:
+		query = "SELECT ts, value FROM gauge_history WHERE name = $1 AND labels = $2 AND ts >= $3 AND ts <= $4 ORDER BY ts"
+	case strTypCounter:
+		query = "SELECT ts, value FROM counter_history WHERE name = $1 AND labels = $2 AND ts >= $3 AND ts <= $4 ORDER BY ts"
+	default:
+		return nil, errWrongType
+	}
+
+	metric, labels := common.SplitSeriesKey(name)
+	rows, err := s.db.Query(query, metric, labels, from, to)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	points := []Point{}
+	for rows.Next() {
+		var p Point
+		if typ == strTypGauge {
+			var value float64
+			err = rows.Scan(&p.Time, &value)
+			p.Value = &value
+		} else {
+			var total int64
+			err = rows.Scan(&p.Time, &total)
+			p.Total = &total
+		}
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *DBStorage) historyCleaner() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			cutoff := time.Now().Add(-s.retention)
+			s.mu.Unlock()
+			for _, table := range []string{"gauge_history", "counter_history"} {
+				if _, err := s.db.Exec("DELETE FROM "+table+" WHERE ts < $1", cutoff); err != nil {
+					log.Print(err)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close closes the database connection
+func (s *DBStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// Ping checks the database connection
+func (s *DBStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
-func loadStatsDB() error {
-	var name string
+func (s *DBStorage) load() error {
+	var name, labels string
 	var gauge float64
 	var counter int64
 
-	mu.Lock()
-	defer mu.Unlock()
+	stats := newStats()
 
-	gRows, err := db.Query("SELECT name, value FROM gauges")
+	gRows, err := s.db.Query("SELECT name, labels, value FROM gauges")
 	if err != nil {
 		return err
 	}
 	defer gRows.Close()
 	for gRows.Next() {
-		if err = gRows.Scan(&name, &gauge); err != nil {
+		if err = gRows.Scan(&name, &labels, &gauge); err != nil {
 			log.Print(err)
 			return err
 		}
-		statistics.Gauges[name] = gauge
+		stats.Gauges[common.JoinSeriesKey(name, labels)] = gauge
 	}
 	if err = gRows.Err(); err != nil {
 		return err
 	}
 
-	cRows, err := db.Query("SELECT name, value FROM counters")
+	cRows, err := s.db.Query("SELECT name, labels, value FROM counters")
 	if err != nil {
 		return err
 	}
 	defer cRows.Close()
 	for cRows.Next() {
-		if err = cRows.Scan(&name, &counter); err != nil {
+		if err = cRows.Scan(&name, &labels, &counter); err != nil {
 			log.Print(err)
 			return err
 		}
-		statistics.Counters[name] = counter
+		stats.Counters[common.JoinSeriesKey(name, labels)] = counter
 	}
 	if err = cRows.Err(); err != nil {
 		return err
 	}
 
+	s.replace(stats)
 	return nil
 }
 
-// DBPing tests if DB connection is working
-func DBPing(w http.ResponseWriter, r *http.Request) {
-	if db == nil {
-		log.Printf("database is not connected")
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", false)
-		return
-	}
-	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", false)
-		return
-	}
+type pinger interface {
+	Ping(ctx context.Context) error
+}
 
-	writeStatus(w, http.StatusOK, "OK", false)
+// DBPing tests if DB connection is working. It requires the read
+// scope if API tokens are configured
+func DBPing(st Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := st.(pinger)
+		if !ok {
+			log.Printf("database is not connected")
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", false)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", false)
+			return
+		}
+
+		writeStatus(w, http.StatusOK, "OK", false)
+	}
 }
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileStorage keeps metrics in memory and stores them into a JSON file,
+// either on every update or periodically
+type FileStorage struct {
+	*MemStorage
+	done          chan struct{}
+	file          string
+	wg            sync.WaitGroup
+	fileMu        sync.Mutex
+	storeInterval time.Duration
+}
+
+// NewFileStorage returns the storage backed by the given file.
+// With zero storeInterval the file is written on every update.
+func NewFileStorage(file string, storeInterval time.Duration, restore bool) (*FileStorage, error) {
+	s := &FileStorage{
+		MemStorage:    NewMemStorage(),
+		file:          file,
+		storeInterval: storeInterval,
+		done:          make(chan struct{}),
+	}
+
+	if restore {
+		if err := s.load(); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if storeInterval > 0 {
+		s.wg.Add(1)
+		go s.saver()
+	}
+	return s, nil
+}
+
+// SetGauge sets the gauge value
+func (s *FileStorage) SetGauge(name string, value float64) error {
+	if err := s.MemStorage.SetGauge(name, value); err != nil {
+		return err
+	}
+	return s.syncStore()
+}
+
+// AddCounter adds delta to the counter and returns the new value
+func (s *FileStorage) AddCounter(name string, delta int64) (int64, error) {
+	val, err := s.MemStorage.AddCounter(name, delta)
+	if err != nil {
+		return val, err
+	}
+	return val, s.syncStore()
+}
+
+// Snapshot writes all metrics into the file
+func (s *FileStorage) Snapshot() error {
+	stats := s.List()
+
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	f, err := os.OpenFile(s.file, flags, 0644)
+	if err != nil {
+		log.Print("cannot open file for writing: ", err)
+		return err
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(stats); err != nil {
+		log.Print("cannot encode statistics: ", err)
+		return err
+	}
+	return nil
+}
+
+// Close stops the periodic saver and writes the file for the last time
+func (s *FileStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.Snapshot()
+}
+
+func (s *FileStorage) syncStore() error {
+	if s.storeInterval > 0 {
+		return nil
+	}
+	return s.Snapshot()
+}
+
+func (s *FileStorage) saver() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.storeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Print(err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *FileStorage) load() error {
+	f, err := os.OpenFile(s.file, os.O_RDONLY, 0)
+	if err != nil {
+		log.Print("cannot open file for reading ", err)
+		return err
+	}
+	defer f.Close()
+
+	var stats Stats
+	if err := json.NewDecoder(f).Decode(&stats); err != nil {
+		log.Print("cannot decode statistics ", err)
+		return err
+	}
+	s.replace(stats)
+	return nil
+}
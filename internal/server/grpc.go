@@ -2,9 +2,15 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io"
 	"log"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
 	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
 )
@@ -12,11 +18,46 @@ import (
 // MetricesServer is to serve grps requests
 type MetricesServer struct {
 	pb.UnimplementedMetricesServer
+	storage Storage
+	srv     *Server
+}
+
+// NewMetricesServer returns gRPC server working with the storage
+// and the state of the server
+func NewMetricesServer(s *Server) *MetricesServer {
+	return &MetricesServer{storage: s.st, srv: s}
+}
+
+// newGRPCServer returns gRPC server with the Metrices service registered,
+// with TLS if the certificate is configured
+func newGRPCServer(srv *Server) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if srv.cfg.GRPCCertFile != "" || srv.cfg.GRPCKeyFile != "" {
+		creds, err := grpcint.ServerCredentials(
+			srv.cfg.GRPCCertFile,
+			srv.cfg.GRPCKeyFile,
+			srv.cfg.GRPCClientCAFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	} else if srv.cfg.GRPCClientCAFile != "" {
+		return nil, errors.New("gRPC client CA requires gRPC certificate and key")
+	}
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(srv.trackUnary, srv.unaryAuth),
+		grpc.ChainStreamInterceptor(srv.trackStream, srv.streamAuth),
+	)
+	s := grpc.NewServer(opts...)
+	pb.RegisterMetricesServer(s, NewMetricesServer(srv))
+	return s, nil
 }
 
 func pbToStatReq(p *pb.Metrics) statReq {
 	var req statReq
-	req.name = p.Id
+	req.name = common.SeriesKey(p.Id, p.Labels)
 	switch p.Mtype {
 	case pb.Metrics_GAUGE:
 		req.statType = statTypeGauge
@@ -28,27 +69,123 @@ func pbToStatReq(p *pb.Metrics) statReq {
 	return req
 }
 
+// storeMetrices checks and stores the metrices, stopping on the first error.
+// Metrices signed with the envelope have no own hashes. The error is
+// a status error telling the client whether to send the batch again:
+// the batch is refused with InvalidArgument, the failures which may
+// pass have other codes
+func (s *MetricesServer) storeMetrices(mm []*pb.Metrics, env *pb.Envelope) error {
+	if env == nil && s.srv.envelopeRequired() {
+		return status.Error(codes.PermissionDenied, errEnvelopeRequired.Error())
+	}
+	if env != nil {
+		key, err := s.srv.envelopeKey(env.AgentId)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		// the nonce is recorded only for batches with the right hash
+		if err := grpcint.CheckEnvelope(env, mm, key); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := s.srv.checkEnvelope(key, env.Timestamp, env.Nonce); err != nil {
+			if errors.Is(err, errNonceCacheFull) {
+				return status.Error(codes.ResourceExhausted, err.Error())
+			}
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		for _, m := range mm {
+			m.Labels = s.srv.agentLabels(m.Labels, env.AgentId)
+		}
+	}
+	// the whole batch is checked before anything is stored
+	for _, m := range mm {
+		if s.srv.cfg.Key != "" && env == nil {
+			err := grpcint.CheckHash(m, s.srv.cfg.Key)
+			if err != nil {
+				log.Printf("error validating %v", m)
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		if err := checkSeries(m.Id, m.Labels); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if m.Mtype != pb.Metrics_COUNTER && m.Mtype != pb.Metrics_GAUGE {
+			return status.Error(codes.InvalidArgument, errWrongType.Error())
+		}
+	}
+	for i, m := range mm {
+		log.Printf("received update %d: %v", i, m)
+		err := s.srv.updateStatStorage(pbToStatReq(m))
+		if err != nil {
+			log.Print(err)
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	return nil
+}
+
 // UpdateMetrices get the sequence of mertices and store them in the server
 func (s *MetricesServer) UpdateMetrices(
 	ctx context.Context,
 	in *pb.UpdateMetricesRequest,
 ) (*pb.UpdateMetricesResponse, error) {
 	var ret pb.UpdateMetricesResponse
-	for i := int32(0); i < in.Count; i++ {
-		if Config.Key != "" {
-			err := grpcint.CheckHash(in.Metrices[i], Config.Key)
+	if in.Count < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative count")
+	}
+	if int(in.Count) > len(in.Metrices) {
+		ret.Error = "count exceeds the number of metrices"
+		return &ret, nil
+	}
+	err := s.storeMetrices(in.Metrices[:in.Count], in.Envelope)
+	if err != nil {
+		ret.Error = status.Convert(err).Message()
+	}
+	return &ret, nil
+}
+
+// StreamMetrices receives batches of metrices over the stream
+// and acknowledges every batch after storing it. The stream is ended
+// when the server is shutting down
+func (s *MetricesServer) StreamMetrices(stream pb.Metrices_StreamMetricesServer) error {
+	batches := make(chan *pb.MetricsBatch)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			batch, err := stream.Recv()
 			if err != nil {
-				log.Printf("error validating %v", in.Metrices[i])
-				ret.Error = fmt.Sprintf("%v", err)
-				return &ret, nil
+				errs <- err
+				return
+			}
+			select {
+			case batches <- batch:
+			case <-stream.Context().Done():
+				return
 			}
 		}
-		log.Printf("received update %d: %v", i, in.Metrices[i])
-		err := updateStatStorage(pbToStatReq(in.Metrices[i]))
+	}()
+
+	for {
+		var batch *pb.MetricsBatch
+		select {
+		case <-s.srv.done:
+			return errShuttingDown
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case batch = <-batches:
+		}
+		ack := pb.BatchAck{Id: batch.Id}
+		err := s.storeMetrices(batch.Metrices, batch.Envelope)
 		if err != nil {
-			ret.Error = fmt.Sprintf("%v", err)
-			return &ret, nil
+			st := status.Convert(err)
+			ack.Error = st.Message()
+			ack.Code = uint32(st.Code())
+		}
+		if err := stream.Send(&ack); err != nil {
+			return err
 		}
 	}
-	return &ret, nil
 }
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var errBadPageToken = errors.New("bad page token")
+
+// seriesToPb converts the stored series to pb.Metrics signed with
+// the response key if the server key is set. Counter values are
+// returned in Delta
+func (s *MetricesServer) seriesToPb(key, typ string, delta int64, value float64) (*pb.Metrics, error) {
+	name, labels, err := common.ParseSeriesKey(key)
+	if err != nil {
+		return nil, err
+	}
+	p := pb.Metrics{
+		Id:     name,
+		Labels: labels,
+	}
+	switch typ {
+	case strTypGauge:
+		p.Mtype = pb.Metrics_GAUGE
+		p.Value = value
+	case strTypCounter:
+		p.Mtype = pb.Metrics_COUNTER
+		p.Delta = delta
+	default:
+		return nil, errWrongType
+	}
+	if err := grpcint.StoreHash(&p, common.ResponseKey(s.srv.cfg.Key)); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetMetric returns the current value of the metric
+func (s *MetricesServer) GetMetric(
+	ctx context.Context,
+	in *pb.GetMetricRequest,
+) (*pb.GetMetricResponse, error) {
+	var ret pb.GetMetricResponse
+	if err := checkSeries(in.Id, in.Labels); err != nil {
+		ret.Error = err.Error()
+		return &ret, nil
+	}
+	key := common.SeriesKey(in.Id, in.Labels)
+
+	var p *pb.Metrics
+	var err error
+	switch in.Mtype {
+	case pb.Metrics_GAUGE:
+		val, ok := s.storage.GetGauge(key)
+		if !ok {
+			ret.Error = "metric not found"
+			return &ret, nil
+		}
+		p, err = s.seriesToPb(key, strTypGauge, 0, val)
+	case pb.Metrics_COUNTER:
+		val, ok := s.storage.GetCounter(key)
+		if !ok {
+			ret.Error = "metric not found"
+			return &ret, nil
+		}
+		p, err = s.seriesToPb(key, strTypCounter, val, 0)
+	default:
+		err = errWrongType
+	}
+	if err != nil {
+		ret.Error = fmt.Sprintf("%v", err)
+		return &ret, nil
+	}
+	ret.Metric = p
+	return &ret, nil
+}
+
+type seriesRef struct {
+	key string
+	typ string
+}
+
+// pageToken is the position after the series in the sorted list
+func (r seriesRef) pageToken() string {
+	return r.typ + "/" + r.key
+}
+
+func (r seriesRef) less(o seriesRef) bool {
+	if r.key != o.key {
+		return r.key < o.key
+	}
+	return r.typ < o.typ
+}
+
+func parsePageToken(token string) (seriesRef, error) {
+	typ, key, ok := strings.Cut(token, "/")
+	if !ok || (typ != strTypGauge && typ != strTypCounter) {
+		return seriesRef{}, errBadPageToken
+	}
+	return seriesRef{key: key, typ: typ}, nil
+}
+
+// ListMetrics returns a page of metrices with ids starting with
+// the prefix, sorted by the series key
+func (s *MetricesServer) ListMetrics(
+	ctx context.Context,
+	in *pb.ListMetricsRequest,
+) (*pb.ListMetricsResponse, error) {
+	var ret pb.ListMetricsResponse
+
+	pageSize := int(in.PageSize)
+	switch {
+	case pageSize < 0:
+		ret.Error = "negative page size"
+		return &ret, nil
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	var after *seriesRef
+	if in.PageToken != "" {
+		ref, err := parsePageToken(in.PageToken)
+		if err != nil {
+			ret.Error = fmt.Sprintf("%v", err)
+			return &ret, nil
+		}
+		after = &ref
+	}
+
+	stats := s.storage.List()
+	refs := make([]seriesRef, 0, len(stats.Counters)+len(stats.Gauges))
+	for k := range stats.Counters {
+		refs = append(refs, seriesRef{key: k, typ: strTypCounter})
+	}
+	for k := range stats.Gauges {
+		refs = append(refs, seriesRef{key: k, typ: strTypGauge})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].less(refs[j]) })
+
+	var last seriesRef
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.key, in.Prefix) {
+			continue
+		}
+		if after != nil && !after.less(ref) {
+			continue
+		}
+		if len(ret.Metrices) == pageSize {
+			ret.NextPageToken = last.pageToken()
+			break
+		}
+		p, err := s.seriesToPb(ref.key, ref.typ, stats.Counters[ref.key], stats.Gauges[ref.key])
+		if err != nil {
+			ret.Metrices = nil
+			ret.Error = fmt.Sprintf("%v", err)
+			return &ret, nil
+		}
+		ret.Metrices = append(ret.Metrices, p)
+		last = ref
+	}
+	return &ret, nil
+}
+
+// WatchMetrics pushes every change of metrices with ids starting with
+// the prefix until the client goes away or the server is shutting down
+func (s *MetricesServer) WatchMetrics(
+	in *pb.WatchMetricsRequest,
+	stream pb.Metrices_WatchMetricsServer,
+) error {
+	updates, cancel := s.storage.Watch(in.Prefix)
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.srv.done:
+			return errShuttingDown
+		case u := <-updates:
+			p, err := s.seriesToPb(u.Name, u.MType, u.Delta, u.Value)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(p); err != nil {
+				return err
+			}
+		}
+	}
+}
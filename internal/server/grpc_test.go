@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+// startTestGRPC runs the gRPC server over in-memory connection
+// and returns a client for it
+func startTestGRPC(t *testing.T, cfg ConfigType, st Storage) pb.MetricesClient {
+	listen := bufconn.Listen(1 << 20)
+	s, err := newGRPCServer(newTestServer(t, cfg, st))
+	require.NoError(t, err)
+	go s.Serve(listen)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listen.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricesClient(conn)
+}
+
+func TestUpdateMetricesCount(t *testing.T) {
+	st := NewMemStorage()
+	client := startTestGRPC(t, ConfigType{}, st)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mm := []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2}}
+
+	_, err := client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: -1, Metrices: mm})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	resp, err := client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 2, Metrices: mm})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Error)
+	resp, err = client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 1, Metrices: mm})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Error)
+
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(2), c)
+
+	// nothing of the rejected batch is stored
+	mm = append(mm, &pb.Metrics{Id: `x{k="v"}`, Mtype: pb.Metrics_GAUGE, Value: 1})
+	resp, err = client.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 2, Metrices: mm})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Error)
+	c, _ = st.GetCounter("PollCount")
+	assert.Equal(t, int64(2), c)
+}
+
+func TestStreamMetrices(t *testing.T) {
+	st := NewMemStorage()
+	client := startTestGRPC(t, ConfigType{}, st)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamMetrices(ctx)
+	require.NoError(t, err)
+
+	for id := uint64(1); id <= 3; id++ {
+		err = stream.Send(&pb.MetricsBatch{
+			Id: id,
+			Metrices: []*pb.Metrics{
+				{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2},
+				{Id: "HeapAlloc", Mtype: pb.Metrics_GAUGE, Value: float64(id)},
+			},
+		})
+		require.NoError(t, err)
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, id, ack.Id)
+		assert.Empty(t, ack.Error)
+	}
+
+	require.NoError(t, stream.CloseSend())
+
+	// the server with the key refuses the batch with a bad hash
+	stream, err = startTestGRPC(t, ConfigType{Key: "secret"}, st).StreamMetrices(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&pb.MetricsBatch{
+		Id:       4,
+		Metrices: []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2, Hash: "bad"}},
+	})
+	require.NoError(t, err)
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), ack.Id)
+	assert.NotEmpty(t, ack.Error)
+	require.NoError(t, stream.CloseSend())
+
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(6), c)
+	g, _ := st.GetGauge("HeapAlloc")
+	assert.Equal(t, 3.0, g)
+}
+
+// downStorage fails every write as a database which is down
+type downStorage struct {
+	*MemStorage
+}
+
+func (downStorage) SetGauge(string, float64) error {
+	return errors.New("storage is down")
+}
+
+func (downStorage) AddCounter(string, int64) (int64, error) {
+	return 0, errors.New("storage is down")
+}
+
+func TestStreamMetricesAckCode(t *testing.T) {
+	client := startTestGRPC(t, ConfigType{}, downStorage{NewMemStorage()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamMetrices(ctx)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		m    *pb.Metrics
+		code codes.Code
+	}{
+		{"storage failure", &pb.Metrics{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2}, codes.Unavailable},
+		{"bad name", &pb.Metrics{Id: `x{k="v"}`, Mtype: pb.Metrics_GAUGE, Value: 1}, codes.InvalidArgument},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := uint64(i + 1)
+			err := stream.Send(&pb.MetricsBatch{Id: id, Metrices: []*pb.Metrics{tt.m}})
+			require.NoError(t, err)
+			ack, err := stream.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, id, ack.Id)
+			assert.NotEmpty(t, ack.Error)
+			assert.Equal(t, tt.code, codes.Code(ack.Code))
+		})
+	}
+}
+
+func TestReadMetrices(t *testing.T) {
+	const key = "secret"
+	st := NewMemStorage()
+	client := startTestGRPC(t, ConfigType{Key: key}, st)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	st.AddCounter("PollCount", 5)
+	st.SetGauge("HeapAlloc", 1.5)
+	st.SetGauge(`HeapInuse{host="a"}`, 2)
+	st.SetGauge("HeapSys", 3)
+	st.SetGauge("RandomValue", 4)
+
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{
+		Id: "HeapInuse", Mtype: pb.Metrics_GAUGE, Labels: map[string]string{"host": "a"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	assert.Equal(t, 2.0, resp.Metric.Value)
+	assert.NoError(t, grpcint.CheckHash(resp.Metric, common.ResponseKey(key)))
+	// the value read can't be sent back as a write
+	assert.Error(t, grpcint.CheckHash(resp.Metric, key))
+
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Mtype: pb.Metrics_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.Metric.Delta)
+
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Mtype: pb.Metrics_GAUGE})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Error)
+
+	var ids []string
+	token := ""
+	for {
+		list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{
+			Prefix: "Heap", PageSize: 2, PageToken: token,
+		})
+		require.NoError(t, err)
+		require.Empty(t, list.Error)
+		require.LessOrEqual(t, len(list.Metrices), 2)
+		for _, m := range list.Metrices {
+			assert.NoError(t, grpcint.CheckHash(m, common.ResponseKey(key)))
+			ids = append(ids, m.Id)
+		}
+		token = list.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"HeapAlloc", "HeapInuse", "HeapSys"}, ids)
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{PageToken: "bad"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, list.Error)
+}
+
+func TestWatchMetrics(t *testing.T) {
+	st := NewMemStorage()
+	client := startTestGRPC(t, ConfigType{}, st)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Prefix: "Poll"})
+	require.NoError(t, err)
+	// wait for the subscription, updates before it are not sent
+	require.Eventually(t, func() bool {
+		st.watchers.mu.Lock()
+		defer st.watchers.mu.Unlock()
+		return len(st.watchers.list) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	st.SetGauge("HeapAlloc", 1)
+	st.AddCounter("PollCount", 1)
+	st.AddCounter("PollCount", 2)
+
+	m, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "PollCount", m.Id)
+	assert.Equal(t, int64(1), m.Delta)
+	m, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), m.Delta)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+const (
+	// historyPruneInterval is how often all series are checked for
+	// points that are out of the retention period
+	historyPruneInterval = time.Minute
+	// historyMaxPoints is the most points kept in memory for a series,
+	// the oldest points are dropped first
+	historyMaxPoints = 10000
+)
+
+// Point is a single stored sample of a metric. For counters Total holds
+// the counter total after the update, not the increment.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Total *int64    `json:"total,omitempty"`
+	Value *float64  `json:"value,omitempty"`
+}
+
+// History is the answer of the history handler
+type History struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Points []Point           `json:"points"`
+}
+
+// history keeps samples in memory for the retention period
+type history struct {
+	series    map[string][]Point
+	lastPrune time.Time
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{
+		series:    make(map[string][]Point),
+		retention: retention,
+		lastPrune: time.Now(),
+	}
+}
+
+func historyKey(typ, key string) string {
+	return typ + ":" + key
+}
+
+func (h *history) add(typ, key string, p Point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hk := historyKey(typ, key)
+	h.series[hk] = append(h.series[hk], p)
+
+	if n := len(h.series[hk]); n > historyMaxPoints {
+		h.series[hk] = append([]Point(nil), h.series[hk][n-historyMaxPoints:]...)
+	}
+
+	cutoff := p.Time.Add(-h.retention)
+	h.series[hk] = prunePoints(h.series[hk], cutoff)
+	if p.Time.Sub(h.lastPrune) > historyPruneInterval {
+		h.lastPrune = p.Time
+		for k, points := range h.series {
+			h.series[k] = prunePoints(points, cutoff)
+			if len(h.series[k]) == 0 {
+				delete(h.series, k)
+			}
+		}
+	}
+}
+
+func (h *history) get(typ, key string, from, to time.Time) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	points := h.series[historyKey(typ, key)]
+	start := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(from)
+	})
+	end := sort.Search(len(points), func(i int) bool {
+		return points[i].Time.After(to)
+	})
+	if start >= end {
+		return []Point{}
+	}
+	ret := make([]Point, end-start)
+	copy(ret, points[start:end])
+	return ret
+}
+
+// prunePoints drops the points older than cutoff,
+// the points are sorted by time
+func prunePoints(points []Point, cutoff time.Time) []Point {
+	i := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(cutoff)
+	})
+	if i == 0 {
+		return points
+	}
+	// copy to let the old array be collected
+	return append([]Point(nil), points[i:]...)
+}
+
+func parseHistoryTime(s string, dflt time.Time) (time.Time, error) {
+	if s == "" {
+		return dflt, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// HistoryHandler returns the stored points of the metric.
+// from and to are given as RFC 3339 time or unix seconds, other
+// query parameters are the metric labels.
+func HistoryHandler(st Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		typ := chi.URLParam(r, "typ")
+		name := chi.URLParam(r, "name")
+		log.Println("GET history", typ, name)
+
+		if typ != strTypCounter && typ != strTypGauge {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+			return
+		}
+
+		query := r.URL.Query()
+		now := time.Now()
+		from, err := parseHistoryTime(query.Get("from"), time.Time{})
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+			return
+		}
+		to, err := parseHistoryTime(query.Get("to"), now)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+			return
+		}
+
+		var labels map[string]string
+		for k, v := range query {
+			if k == "from" || k == "to" {
+				continue
+			}
+			labels = common.MergeLabels(labels, map[string]string{k: v[0]})
+		}
+		key := common.SeriesKey(name, labels)
+
+		points, err := st.History(typ, key, from, to)
+		if err != nil {
+			log.Print(err)
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+			return
+		}
+		if len(points) == 0 {
+			var known bool
+			if typ == strTypCounter {
+				_, known = st.GetCounter(key)
+			} else {
+				_, known = st.GetGauge(key)
+			}
+			if !known {
+				writeStatus(w, http.StatusNotFound, "Not Found", true)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		h := History{
+			ID:     name,
+			MType:  typ,
+			Labels: labels,
+			Points: points,
+		}
+		if err := json.NewEncoder(w).Encode(h); err != nil {
+			log.Printf("history encoding: %v", err)
+		}
+	}
+}
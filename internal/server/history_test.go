@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_history(t *testing.T) {
+	h := newHistory(time.Minute)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		v := float64(i)
+		h.add(strTypGauge, "g", Point{Time: start.Add(time.Duration(i) * 20 * time.Second), Value: &v})
+	}
+
+	// the first point is out of the retention period after the last add
+	points := h.get(strTypGauge, "g", time.Time{}, start.Add(time.Hour))
+	require.Len(t, points, 4)
+	assert.Equal(t, 1.0, *points[0].Value)
+
+	points = h.get(strTypGauge, "g", start.Add(50*time.Second), start.Add(70*time.Second))
+	require.Len(t, points, 1)
+	assert.Equal(t, 3.0, *points[0].Value)
+
+	assert.Empty(t, h.get(strTypCounter, "g", time.Time{}, start.Add(time.Hour)))
+}
+
+func Test_historyMaxPoints(t *testing.T) {
+	h := newHistory(time.Hour)
+	start := time.Now()
+	for i := 0; i < historyMaxPoints+10; i++ {
+		v := float64(i)
+		h.add(strTypGauge, "g", Point{Time: start.Add(time.Duration(i) * time.Millisecond), Value: &v})
+	}
+	points := h.get(strTypGauge, "g", time.Time{}, start.Add(time.Hour))
+	require.Len(t, points, historyMaxPoints)
+	assert.Equal(t, 10.0, *points[0].Value)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	st := NewMemStorage()
+	st.EnableHistory(time.Hour)
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/update/counter/PollCount/1",
+		"/update/counter/PollCount/2",
+		"/update/gauge/HeapAlloc/10",
+		"/update/gauge/HeapAlloc/20",
+	} {
+		resp, _ := testRequest(t, ts, "POST", path, nil, false)
+		resp.Body.Close()
+	}
+
+	resp, body := testRequest(t, ts, "GET", "/history/counter/PollCount", nil, false)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	var h History
+	require.NoError(t, json.Unmarshal([]byte(body), &h))
+	require.Len(t, h.Points, 2)
+	assert.Equal(t, int64(1), *h.Points[0].Total)
+	assert.Equal(t, int64(3), *h.Points[1].Total)
+	assert.Contains(t, body, `"total":3`)
+
+	resp, body = testRequest(t, ts, "GET", "/history/gauge/HeapAlloc?from=0", nil, false)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &h))
+	require.Len(t, h.Points, 2)
+	assert.Equal(t, 20.0, *h.Points[1].Value)
+
+	resp, body = testRequest(t, ts, "GET", "/history/gauge/HeapAlloc?to=1", nil, false)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &h))
+	assert.Empty(t, h.Points)
+
+	resp, _ = testRequest(t, ts, "GET", "/history/gauge/nosuchname", nil, false)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, "GET", "/history/gauge/HeapAlloc?from=yesterday", nil, false)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
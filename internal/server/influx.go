@@ -0,0 +1,147 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/lineproto"
+)
+
+// InfluxWriteHandler stores metrics sent in InfluxDB line protocol.
+//
+// Every field becomes the metric <measurement>_<field>, or just
+// <measurement> for the field named value. Float and bool (1 or 0)
+// fields are gauges, string fields are skipped. Integer fields are
+// counters: Telegraf reports running totals, so the increase since the
+// previous value is added as with CumulativeCounters, and negative
+// values are skipped. With the ints=gauges query parameter integer
+// fields are gauges too. Tags become labels, with the tags=suffix query
+// parameter they are appended to the name as .<value> sorted by the
+// tag key. Timestamps are ignored.
+//
+// The body is decrypted as the bodies of other writes, so with the server
+// crypto key set it must be encrypted. The decrypted body may be gzip
+// compressed. See ReceiverAuth for the authentication: without API tokens
+// and with the server key set the decrypted body, i.e. still compressed,
+// must be signed with common.BodyHashHeader
+func InfluxWriteHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, " ", r.URL)
+
+		var opts influxOptions
+		switch r.URL.Query().Get("tags") {
+		case "", "labels":
+		case "suffix":
+			opts.tagsAsSuffix = true
+		default:
+			http.Error(w, "tags must be labels or suffix", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("ints") {
+		case "", "counters":
+		case "gauges":
+			opts.intGauges = true
+		default:
+			http.Error(w, "ints must be counters or gauges", http.StatusBadRequest)
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		points, err := lineproto.Parse(buf)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, p := range points {
+			for _, stat := range influxStats(p, opts) {
+				if err := s.updateStatStorage(stat); err != nil {
+					log.Print(err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// influxOptions are the query parameters of the write
+type influxOptions struct {
+	tagsAsSuffix bool
+	intGauges    bool
+}
+
+// influxStats maps the point fields onto the metrics
+func influxStats(p lineproto.Point, opts influxOptions) []statReq {
+	labels := p.Tags
+	suffix := ""
+	if !opts.tagsAsSuffix {
+		if err := common.CheckLabels(labels); err != nil {
+			log.Printf("line protocol: skipping point: %v", err)
+			return nil
+		}
+	} else {
+		labels = nil
+		keys := make([]string, 0, len(p.Tags))
+		for k := range p.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			suffix += "." + p.Tags[k]
+		}
+	}
+
+	var stats []statReq
+	for _, f := range p.Fields {
+		name := p.Measurement
+		if f.Key != "value" {
+			name += "_" + f.Key
+		}
+		if err := common.CheckName(name + suffix); err != nil {
+			log.Printf("line protocol: skipping field: %v", err)
+			continue
+		}
+		stat := statReq{name: common.SeriesKey(name+suffix, labels)}
+		switch {
+		case (f.Kind == lineproto.KindInt || f.Kind == lineproto.KindUint) && !opts.intGauges:
+			if f.Int < 0 {
+				log.Printf("line protocol: skipping negative counter %s", name+suffix)
+				continue
+			}
+			stat.statType = statTypeCounter
+			stat.valueCounter = f.Int
+			stat.isTotal = true
+		case f.Kind == lineproto.KindFloat, f.Kind == lineproto.KindInt,
+			f.Kind == lineproto.KindUint, f.Kind == lineproto.KindBool:
+			stat.statType = statTypeGauge
+			stat.valueGauge = f.Value
+		default:
+			continue
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
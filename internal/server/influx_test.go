@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/crypt"
+)
+
+func TestInfluxWriteHandler(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	body := `cpu,host=web1,cpu=cpu0 usage_idle=97.5,usage_user=2
+net,host=web1 bytes_recv=1024i,bytes_sent=512u,status="up"
+temperature,room=kitchen value=21.5
+`
+	resp, _ := testRequest(t, ts, http.MethodPost, "/write", strings.NewReader(body), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	stats := st.List()
+	assert.Equal(t, 97.5, stats.Gauges[`cpu_usage_idle{cpu="cpu0",host="web1"}`])
+	assert.Equal(t, 2.0, stats.Gauges[`cpu_usage_user{cpu="cpu0",host="web1"}`])
+	assert.Equal(t, int64(1024), stats.Counters[`net_bytes_recv{host="web1"}`])
+	assert.Equal(t, int64(512), stats.Counters[`net_bytes_sent{host="web1"}`])
+	assert.Equal(t, 21.5, stats.Gauges[`temperature{room="kitchen"}`])
+	assert.Len(t, stats.Gauges, 3)
+	assert.Len(t, stats.Counters, 2)
+
+	// running totals are not added up on every write
+	resp, _ = testRequest(t, ts, http.MethodPost, "/write", strings.NewReader("net,host=web1 bytes_recv=2048i\n"), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, int64(2048), st.List().Counters[`net_bytes_recv{host="web1"}`])
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/write?ints=gauges", strings.NewReader("mem available=4096i\n"), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, 4096.0, st.List().Gauges["mem_available"])
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/write?tags=suffix", strings.NewReader(body), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	stats = st.List()
+	assert.Equal(t, 97.5, stats.Gauges["cpu_usage_idle.cpu0.web1"])
+	assert.Equal(t, int64(1024), stats.Counters["net_bytes_recv.web1"])
+
+	// nothing is stored from a bad body
+	resp, _ = testRequest(t, ts, http.MethodPost, "/write", strings.NewReader("ok value=1\nbroken\n"), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, ok := st.List().Gauges["ok"]
+	assert.False(t, ok)
+
+	// the points with bad tag keys are skipped
+	resp, _ = testRequest(t, ts, http.MethodPost, "/write", strings.NewReader("bad,host-name=web1 value=1\n"), false)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, ok = st.List().Gauges[`bad{host-name="web1"}`]
+	assert.False(t, ok)
+
+	for _, query := range []string{"tags=prefix", "ints=floats"} {
+		resp, _ = testRequest(t, ts, http.MethodPost, "/write?"+query, strings.NewReader(body), false)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestInfluxWriteGzipAndHash(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{Key: "secret"}, st)))
+	defer ts.Close()
+
+	body := []byte("load value=0.5\n")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name string
+		hash string
+		code int
+	}{
+		{name: "no hash", code: http.StatusBadRequest},
+		{name: "body hash", hash: common.BodyHash("secret", body), code: http.StatusBadRequest},
+		{name: "wire hash", hash: common.BodyHash("secret", gz.Bytes()), code: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/write", bytes.NewReader(gz.Bytes()))
+			require.NoError(t, err)
+			req.Header.Set("Content-Encoding", "gzip")
+			if tt.hash != "" {
+				req.Header.Set(common.BodyHashHeader, tt.hash)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.code, resp.StatusCode)
+		})
+	}
+	assert.Equal(t, 0.5, st.List().Gauges["load"])
+}
+
+func TestInfluxWriteEncrypted(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	st := NewMemStorage()
+	s := newTestServer(t, ConfigType{Key: "secret"}, st)
+	s.privateKey = key
+	ts := httptest.NewServer(Router(s))
+	defer ts.Close()
+
+	body := []byte("load value=0.5\n")
+	encrypted, err := crypt.EncryptHybrid(rand.Reader, &key.PublicKey, body)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		scheme string
+		body   []byte
+		code   int
+	}{
+		{name: "plain", body: body, code: http.StatusBadRequest},
+		{name: "encrypted", scheme: crypt.SchemeHybrid, body: encrypted, code: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/write", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set(crypt.EncryptionHeader, tt.scheme)
+			// the hash is of the decrypted body
+			req.Header.Set(common.BodyHashHeader, common.BodyHash("secret", body))
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.code, resp.StatusCode)
+		})
+	}
+	assert.Equal(t, 0.5, st.List().Gauges["load"])
+}
@@ -6,13 +6,14 @@ import (
 	"net/http"
 )
 
-// CheckIP is chi middleware function used to decrypt the received body
-func CheckIP(next http.Handler) http.Handler {
+// CheckIP is chi middleware function used to check the X-Real-IP
+// header is in the trusted subnet
+func (s *Server) CheckIP(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		if Config.TrustedSubnet != nil {
+		if s.cfg.TrustedSubnet != nil {
 			xRealIP := r.Header.Get("X-Real-IP")
 			ip := net.ParseIP(xRealIP)
-			if !Config.TrustedSubnet.Contains(ip) {
+			if !s.cfg.TrustedSubnet.Contains(ip) {
 				log.Print(
 					"X-Real-IP is not set or address is not allowed:",
 					xRealIP,
@@ -24,3 +25,19 @@ func CheckIP(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 	})
 }
+
+// trustedAddr reports whether the peer address is in the trusted subnet,
+// any address is trusted if the subnet is not set
+func (s *Server) trustedAddr(addr net.Addr) bool {
+	if s.cfg.TrustedSubnet == nil {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	return s.cfg.TrustedSubnet.Contains(ip)
+}
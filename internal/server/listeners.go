@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/statsd"
+)
+
+const (
+	// maxStatsDPacket is the largest UDP payload
+	maxStatsDPacket = 65535
+	// defaultStatsDFlush is used if the flush interval is not set
+	defaultStatsDFlush = 10 * time.Second
+)
+
+// listeners accept metrics in Graphite plaintext and StatsD protocols.
+// The protocols have no authentication: the listeners are off unless
+// their addresses are set, only the trusted subnet is checked against
+// the peer address, and they can't be enabled with envelopes required
+type listeners struct {
+	graphite net.Listener
+	statsD   net.PacketConn
+	agg      *statsd.Aggregator
+	srv      *Server
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startListeners starts the listeners enabled by the server config
+func startListeners(srv *Server) (*listeners, error) {
+	l := &listeners{srv: srv, done: make(chan struct{})}
+	if (srv.cfg.GraphiteAddress != "" || srv.cfg.StatsDAddress != "") && srv.envelopeRequired() {
+		return nil, errors.New("Graphite and StatsD listeners can't be used with envelopes required")
+	}
+	if srv.cfg.GraphiteAddress != "" {
+		ln, err := net.Listen("tcp", srv.cfg.GraphiteAddress)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("accepting Graphite metrics on %s", ln.Addr())
+		l.graphite = ln
+		l.wg.Add(1)
+		go l.serveGraphite()
+	}
+	if srv.cfg.StatsDAddress != "" {
+		conn, err := net.ListenPacket("udp", srv.cfg.StatsDAddress)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		log.Printf("accepting StatsD metrics on %s", conn.LocalAddr())
+		l.statsD = conn
+		l.agg = statsd.NewAggregator()
+		interval := srv.cfg.StatsDFlushInterval
+		if interval <= 0 {
+			interval = defaultStatsDFlush
+		}
+		l.wg.Add(2)
+		go l.serveStatsD()
+		go l.flushStatsD(interval)
+	}
+	return l, nil
+}
+
+// close stops the listeners and stores StatsD metrics not flushed yet
+func (l *listeners) close() {
+	close(l.done)
+	if l.graphite != nil {
+		l.graphite.Close()
+	}
+	if l.statsD != nil {
+		l.statsD.Close()
+	}
+	l.wg.Wait()
+	if l.agg != nil {
+		l.storeStatsD()
+	}
+}
+
+func (l *listeners) serveGraphite() {
+	defer l.wg.Done()
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := l.graphite.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Graphite listener: %v", err)
+			continue
+		}
+		if !l.srv.trustedAddr(conn.RemoteAddr()) {
+			log.Printf("Graphite listener: address not allowed: %v", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			l.serveGraphiteConn(conn)
+		}()
+	}
+}
+
+// serveGraphiteConn reads lines until the client or the server
+// closes the connection. Bad lines are logged and skipped
+func (l *listeners) serveGraphiteConn(conn net.Conn) {
+	finished := make(chan struct{})
+	defer close(finished)
+	defer conn.Close()
+	go func() {
+		// unblock the reader on shutdown
+		select {
+		case <-l.done:
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		stat, err := parseGraphiteLine(line)
+		if err != nil {
+			log.Printf("Graphite listener: %v", err)
+			continue
+		}
+		if err := l.srv.updateStatStorage(stat); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// parseGraphiteLine parses "path value [timestamp]" into a gauge.
+// The path may have tags, "path;tag=value;...", they become labels.
+// Timestamps are ignored
+func parseGraphiteLine(line string) (statReq, error) {
+	var stat statReq
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return stat, fmt.Errorf("bad line %q: expected path, value and timestamp", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return stat, fmt.Errorf("bad line %q: %w", line, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return stat, fmt.Errorf("bad line %q: value is not finite", line)
+	}
+
+	parts := strings.Split(fields[0], ";")
+	if err := common.CheckName(parts[0]); err != nil {
+		return stat, fmt.Errorf("bad line %q: %w", line, err)
+	}
+	var labels map[string]string
+	for _, tag := range parts[1:] {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok || k == "" || v == "" {
+			return stat, fmt.Errorf("bad line %q: bad tag %q", line, tag)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = v
+	}
+	if err := common.CheckLabels(labels); err != nil {
+		return stat, fmt.Errorf("bad line %q: %w", line, err)
+	}
+
+	stat.name = common.SeriesKey(parts[0], labels)
+	stat.statType = statTypeGauge
+	stat.valueGauge = value
+	return stat, nil
+}
+
+func (l *listeners) serveStatsD() {
+	defer l.wg.Done()
+	buf := make([]byte, maxStatsDPacket)
+	for {
+		n, addr, err := l.statsD.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("StatsD listener: %v", err)
+			continue
+		}
+		if !l.srv.trustedAddr(addr) {
+			log.Printf("StatsD listener: address not allowed: %v", addr)
+			continue
+		}
+		samples, err := statsd.ParsePacket(buf[:n])
+		if err != nil {
+			log.Printf("StatsD listener: %v", err)
+		}
+		for _, s := range samples {
+			l.agg.Add(s)
+		}
+	}
+}
+
+func (l *listeners) flushStatsD(interval time.Duration) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.storeStatsD()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// storeStatsD stores the metrics aggregated since the last flush
+func (l *listeners) storeStatsD() {
+	for _, m := range l.agg.Flush() {
+		stat := statReq{name: m.Key()}
+		switch m.MType {
+		case common.NameGauge:
+			stat.statType = statTypeGauge
+			stat.valueGauge = *m.Value
+		case common.NameCounter:
+			stat.statType = statTypeCounter
+			stat.valueCounter = *m.Delta
+			stat.isDelta = true
+		}
+		if err := l.srv.updateStatStorage(stat); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/statsd"
+)
+
+func TestParseGraphiteLine(t *testing.T) {
+	stat, err := parseGraphiteLine("servers.web1.load 0.5 1650000000")
+	require.NoError(t, err)
+	assert.Equal(t, statReq{name: "servers.web1.load", statType: statTypeGauge, valueGauge: 0.5}, stat)
+
+	stat, err = parseGraphiteLine("disk.used;host=web1;mount=/ 42")
+	require.NoError(t, err)
+	assert.Equal(t, `disk.used{host="web1",mount="/"}`, stat.name)
+
+	for _, bad := range []string{"load", "load x", "load 1 2 3", " ;a=b 1", "load;host 1", "load;=x 1", `load{k="v"} 1`, "load;a-b=x 1", "load NaN", "load +Inf", "load -inf 1650000000"} {
+		_, err := parseGraphiteLine(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	cfg := ConfigType{
+		GraphiteAddress:     "127.0.0.1:0",
+		StatsDAddress:       "127.0.0.1:0",
+		StatsDFlushInterval: 50 * time.Millisecond,
+		CumulativeCounters:  true,
+	}
+
+	st := NewMemStorage()
+	ls, err := startListeners(newTestServer(t, cfg, st))
+	require.NoError(t, err)
+
+	graphite, err := net.Dial("tcp", ls.graphite.Addr().String())
+	require.NoError(t, err)
+	fmt.Fprint(graphite, "web1.load 0.5 1650000000\nbroken\nweb1.mem;unit=mb 512\n")
+
+	statsD, err := net.Dial("udp", ls.statsD.LocalAddr().String())
+	require.NoError(t, err)
+	defer statsD.Close()
+	_, err = statsD.Write([]byte("logins:2|c\nlogins:3|c\nqueue:7|g"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		stats := st.List()
+		return stats.Gauges["web1.load"] == 0.5 &&
+			stats.Gauges[`web1.mem{unit="mb"}`] == 512 &&
+			stats.Gauges["queue"] == 7 &&
+			stats.Counters["logins"] == 5
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// StatsD counters are deltas even with cumulative counters
+	_, err = statsD.Write([]byte("logins:1|c"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return st.List().Counters["logins"] == 6
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the open Graphite connection doesn't block the shutdown
+	ls.close()
+	graphite.Close()
+}
+
+func TestListenersFlushOnClose(t *testing.T) {
+	cfg := ConfigType{
+		StatsDAddress:       "127.0.0.1:0",
+		StatsDFlushInterval: time.Hour,
+	}
+
+	st := NewMemStorage()
+	ls, err := startListeners(newTestServer(t, cfg, st))
+	require.NoError(t, err)
+	assert.Nil(t, ls.graphite)
+
+	ls.agg.Add(statsd.Sample{Name: "rt", Type: statsd.Timer, Value: 10, Rate: 1})
+	ls.close()
+	assert.Equal(t, 10.0, st.List().Gauges["rt.max"])
+	assert.Equal(t, int64(1), st.List().Counters["rt.count"])
+}
+
+func TestListenersTrustedSubnet(t *testing.T) {
+	cfg := ConfigType{
+		GraphiteAddress:     "127.0.0.1:0",
+		StatsDAddress:       "127.0.0.1:0",
+		StatsDFlushInterval: time.Hour,
+	}
+	_, cfg.TrustedSubnet, _ = net.ParseCIDR("10.0.0.0/8")
+
+	st := NewMemStorage()
+	ls, err := startListeners(newTestServer(t, cfg, st))
+	require.NoError(t, err)
+
+	graphite, err := net.Dial("tcp", ls.graphite.Addr().String())
+	require.NoError(t, err)
+	defer graphite.Close()
+	fmt.Fprint(graphite, "web1.load 0.5\n")
+	// the connection from the untrusted address is closed unread
+	_, err = graphite.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	statsD, err := net.Dial("udp", ls.statsD.LocalAddr().String())
+	require.NoError(t, err)
+	defer statsD.Close()
+	_, err = statsD.Write([]byte("logins:2|c"))
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	ls.close()
+	assert.Empty(t, st.List().Gauges)
+	assert.Empty(t, st.List().Counters)
+}
+
+func TestListenersRequireEnvelope(t *testing.T) {
+	cfg := ConfigType{
+		StatsDAddress:   "127.0.0.1:0",
+		RequireEnvelope: true,
+		Key:             "secret",
+	}
+
+	_, err := startListeners(newTestServer(t, cfg, NewMemStorage()))
+	assert.Error(t, err)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// OTLP/HTTP content types
+const (
+	otlpProtobuf = "application/x-protobuf"
+	otlpJSON     = "application/json"
+)
+
+// OTLPMetricsHandler stores metrics sent with OTLP/HTTP, either
+// protobuf or JSON encoded ExportMetricsServiceRequest.
+//
+// Resource and data point attributes become labels, the data point
+// ones take precedence. Characters not allowed in label names are
+// replaced with underscores, service.name becomes service_name.
+// Attributes of array, map and bytes types are skipped. The metrics are mapped as follows:
+//   - Gauge becomes a gauge,
+//   - monotonic Sum becomes a counter; cumulative sums are converted
+//     into deltas, values are truncated to integers,
+//   - non-monotonic cumulative Sum becomes a gauge, delta ones are
+//     rejected as a single increment can't give the current value,
+//   - Histogram is flattened like in the Prometheus exposition:
+//     name_bucket with the le label and name_count become counters,
+//     name_sum becomes a gauge with the reported sum,
+//   - ExponentialHistogram is flattened into name_count and name_sum
+//     only, its buckets are dropped,
+//   - Summary quantiles become the gauge name with the quantile label,
+//     name_count and name_sum are stored as for Histogram.
+//
+// Data points with NaN or infinite values, negative counters or
+// unspecified temporality are rejected and reported in the partial
+// success of the response. The body may be gzip compressed, it is
+// never encrypted. See ReceiverAuth for the authentication
+func OTLPMetricsHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, " ", r.URL)
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType != otlpProtobuf && contentType != otlpJSON {
+			http.Error(w, "content type must be "+otlpProtobuf+" or "+otlpJSON,
+				http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req colmetricspb.ExportMetricsServiceRequest
+		if contentType == otlpJSON {
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(buf, &req)
+		} else {
+			err = proto.Unmarshal(buf, &req)
+		}
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, rejected := otlpStats(&req)
+		for _, stat := range stats {
+			if err := s.updateStatStorage(stat); err != nil {
+				log.Print(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		var resp colmetricspb.ExportMetricsServiceResponse
+		if rejected > 0 {
+			resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+				RejectedDataPoints: int64(rejected),
+				ErrorMessage:       "data points with bad values or unspecified temporality",
+			}
+		}
+		var out []byte
+		if contentType == otlpJSON {
+			out, err = protojson.Marshal(&resp)
+		} else {
+			out, err = proto.Marshal(&resp)
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(out); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// otlpStats maps the request onto the metrics and returns them
+// with the number of rejected data points
+func otlpStats(req *colmetricspb.ExportMetricsServiceRequest) ([]statReq, int) {
+	var conv otlpConverter
+	for _, rm := range req.GetResourceMetrics() {
+		resLabels := otlpLabels(nil, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				conv.metric(m, resLabels)
+			}
+		}
+	}
+	return conv.stats, conv.rejected
+}
+
+type otlpConverter struct {
+	stats    []statReq
+	rejected int
+}
+
+func (c *otlpConverter) metric(m *metricspb.Metric, resLabels map[string]string) {
+	name := m.GetName()
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range data.Gauge.GetDataPoints() {
+			c.gauge(name, otlpLabels(resLabels, p.GetAttributes()), numberValue(p))
+		}
+	case *metricspb.Metric_Sum:
+		temp := data.Sum.GetAggregationTemporality()
+		for _, p := range data.Sum.GetDataPoints() {
+			labels := otlpLabels(resLabels, p.GetAttributes())
+			switch {
+			case data.Sum.GetIsMonotonic():
+				c.counter(name, labels, numberValue(p), temp)
+			case temp == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+				c.rejected++
+			default:
+				c.gauge(name, labels, numberValue(p))
+			}
+		}
+	case *metricspb.Metric_Histogram:
+		temp := data.Histogram.GetAggregationTemporality()
+		for _, p := range data.Histogram.GetDataPoints() {
+			labels := otlpLabels(resLabels, p.GetAttributes())
+			var count uint64
+			bounds := p.GetExplicitBounds()
+			for i, n := range p.GetBucketCounts() {
+				count += n
+				le := "+Inf"
+				if i < len(bounds) {
+					le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+				}
+				bucketLabels := common.MergeLabels(labels, map[string]string{"le": le})
+				c.counter(name+"_bucket", bucketLabels, float64(count), temp)
+			}
+			c.counter(name+"_count", labels, float64(p.GetCount()), temp)
+			c.gauge(name+"_sum", labels, p.GetSum())
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		temp := data.ExponentialHistogram.GetAggregationTemporality()
+		for _, p := range data.ExponentialHistogram.GetDataPoints() {
+			labels := otlpLabels(resLabels, p.GetAttributes())
+			c.counter(name+"_count", labels, float64(p.GetCount()), temp)
+			c.gauge(name+"_sum", labels, p.GetSum())
+		}
+	case *metricspb.Metric_Summary:
+		// summaries are always cumulative
+		temp := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, p := range data.Summary.GetDataPoints() {
+			labels := otlpLabels(resLabels, p.GetAttributes())
+			for _, q := range p.GetQuantileValues() {
+				quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+				c.gauge(name, common.MergeLabels(labels, map[string]string{"quantile": quantile}), q.GetValue())
+			}
+			c.counter(name+"_count", labels, float64(p.GetCount()), temp)
+			c.gauge(name+"_sum", labels, p.GetSum())
+		}
+	}
+}
+
+func (c *otlpConverter) gauge(name string, labels map[string]string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) || common.CheckName(name) != nil {
+		c.rejected++
+		return
+	}
+	c.stats = append(c.stats, statReq{
+		name:       common.SeriesKey(name, labels),
+		statType:   statTypeGauge,
+		valueGauge: value,
+	})
+}
+
+// counter adds the counter. Cumulative values are converted into deltas
+// when stored regardless of the CumulativeCounters setting, as OTLP
+// tells the temporality of every metric
+func (c *otlpConverter) counter(
+	name string,
+	labels map[string]string,
+	value float64,
+	temp metricspb.AggregationTemporality,
+) {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 || value > math.MaxInt64 ||
+		common.CheckName(name) != nil {
+		c.rejected++
+		return
+	}
+	stat := statReq{
+		name:         common.SeriesKey(name, labels),
+		statType:     statTypeCounter,
+		valueCounter: int64(value),
+	}
+	switch temp {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		stat.isDelta = true
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		stat.isTotal = true
+	default:
+		c.rejected++
+		return
+	}
+	c.stats = append(c.stats, stat)
+}
+
+func numberValue(p *metricspb.NumberDataPoint) float64 {
+	if v, ok := p.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return p.GetAsDouble()
+}
+
+// otlpLabels returns the base labels with the scalar attributes added.
+// The attribute keys are sanitized as Prometheus does, service.name
+// becomes service_name
+func otlpLabels(base map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		var v string
+		switch val := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			v = val.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			v = strconv.FormatBool(val.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			v = strconv.FormatInt(val.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			v = strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+		default:
+			continue
+		}
+		if kv.GetKey() != "" {
+			labels[common.SanitizeLabelName(kv.GetKey())] = v
+		}
+	}
+	return common.MergeLabels(base, labels)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const otlpJSONPayload = `{
+  "resourceMetrics": [{
+    "resource": {"attributes": [
+      {"key": "service.name", "value": {"stringValue": "shop"}},
+      {"key": "host", "value": {"stringValue": "web1"}}
+    ]},
+    "scopeMetrics": [{
+      "scope": {"name": "meter"},
+      "metrics": [
+        {"name": "temperature", "gauge": {"dataPoints": [
+          {"asDouble": 21.5, "attributes": [{"key": "room", "value": {"stringValue": "kitchen"}}]}
+        ]}},
+        {"name": "requests", "sum": {
+          "aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA",
+          "isMonotonic": true,
+          "dataPoints": [{"asInt": "5", "attributes": [{"key": "host", "value": {"stringValue": "web2"}}]}]
+        }},
+        {"name": "queue", "sum": {
+          "aggregationTemporality": "AGGREGATION_TEMPORALITY_CUMULATIVE",
+          "dataPoints": [{"asInt": "-3"}]
+        }},
+        {"name": "inflight", "sum": {
+          "aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA",
+          "dataPoints": [{"asInt": "2"}]
+        }},
+        {"name": "latency", "histogram": {
+          "aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA",
+          "dataPoints": [{"count": "6", "sum": 1.5, "explicitBounds": [0.1, 1], "bucketCounts": ["3", "2", "1"]}]
+        }},
+        {"name": "bad", "gauge": {"dataPoints": [{"asDouble": "NaN"}]}}
+      ]
+    }]
+  }]
+}`
+
+func TestOTLPMetricsHandlerJSON(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/v1/metrics", strings.NewReader(otlpJSONPayload), true)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `"rejectedDataPoints":"2"`)
+
+	stats := st.List()
+	res := `host="web1",service_name="shop"`
+	assert.Equal(t, 21.5, stats.Gauges[`temperature{host="web1",room="kitchen",service_name="shop"}`])
+	// the data point attribute overrides the resource one
+	assert.Equal(t, int64(5), stats.Counters[`requests{host="web2",service_name="shop"}`])
+	assert.Equal(t, -3.0, stats.Gauges[`queue{`+res+`}`])
+	assert.Equal(t, int64(3), stats.Counters[`latency_bucket{host="web1",le="0.1",service_name="shop"}`])
+	assert.Equal(t, int64(5), stats.Counters[`latency_bucket{host="web1",le="1",service_name="shop"}`])
+	assert.Equal(t, int64(6), stats.Counters[`latency_bucket{host="web1",le="+Inf",service_name="shop"}`])
+	assert.Equal(t, int64(6), stats.Counters[`latency_count{`+res+`}`])
+	assert.Equal(t, 1.5, stats.Gauges[`latency_sum{`+res+`}`])
+	assert.Len(t, stats.Gauges, 3)
+	assert.Len(t, stats.Counters, 5)
+}
+
+func TestOTLPMetricsHandlerProtobuf(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	request := func(total int64, p50 float64) *colmetricspb.ExportMetricsServiceRequest {
+		return &colmetricspb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*metricspb.ResourceMetrics{{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+					Key:   "pid",
+					Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 42}},
+				}}},
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+					{Name: "bytes", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						IsMonotonic:            true,
+						DataPoints: []*metricspb.NumberDataPoint{
+							{Value: &metricspb.NumberDataPoint_AsInt{AsInt: total}},
+						},
+					}}},
+					{Name: "rpc", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+						DataPoints: []*metricspb.SummaryDataPoint{{
+							Count: uint64(total),
+							Sum:   2.5,
+							QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{
+								{Quantile: 0.5, Value: p50},
+							},
+						}},
+					}}},
+					{Name: "unspecified", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						IsMonotonic: true,
+						DataPoints: []*metricspb.NumberDataPoint{
+							{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}},
+						},
+					}}},
+				}}},
+			}},
+		}
+	}
+
+	post := func(req *colmetricspb.ExportMetricsServiceRequest) *colmetricspb.ExportMetricsServiceResponse {
+		body, err := proto.Marshal(req)
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/v1/metrics", "application/x-protobuf", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		out, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var ret colmetricspb.ExportMetricsServiceResponse
+		require.NoError(t, proto.Unmarshal(out, &ret))
+		return &ret
+	}
+
+	ret := post(request(100, 0.2))
+	assert.Equal(t, int64(1), ret.GetPartialSuccess().GetRejectedDataPoints())
+	ret = post(request(130, 0.3))
+	assert.Equal(t, int64(1), ret.GetPartialSuccess().GetRejectedDataPoints())
+
+	// cumulative sums are stored as the increase
+	stats := st.List()
+	assert.Equal(t, int64(130), stats.Counters[`bytes{pid="42"}`])
+	assert.Equal(t, int64(130), stats.Counters[`rpc_count{pid="42"}`])
+	assert.Equal(t, 0.3, stats.Gauges[`rpc{pid="42",quantile="0.5"}`])
+	assert.Equal(t, 2.5, stats.Gauges[`rpc_sum{pid="42"}`])
+	_, ok := stats.Counters[`unspecified{pid="42"}`]
+	assert.False(t, ok)
+}
+
+func TestOTLPMetricsHandlerRetry(t *testing.T) {
+	st := &flakyStorage{MemStorage: NewMemStorage()}
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	post := func(total int64) int {
+		body, err := proto.Marshal(&colmetricspb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*metricspb.ResourceMetrics{{
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+					{Name: "bytes", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						IsMonotonic:            true,
+						DataPoints: []*metricspb.NumberDataPoint{
+							{Value: &metricspb.NumberDataPoint_AsInt{AsInt: total}},
+						},
+					}}},
+				}}},
+			}},
+		})
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/v1/metrics", "application/x-protobuf", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post(100))
+	st.down.Store(true)
+	assert.Equal(t, http.StatusInternalServerError, post(130))
+	st.down.Store(false)
+	// the exporter sends the same total again
+	assert.Equal(t, http.StatusOK, post(130))
+	total, _ := st.GetCounter("bytes")
+	assert.Equal(t, int64(130), total)
+}
+
+func TestOTLPMetricsHandlerErrors(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/metrics", "text/plain", strings.NewReader("x"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/v1/metrics", "application/x-protobuf", strings.NewReader("\xff\xff"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/v1/metrics", "application/json", strings.NewReader("{"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
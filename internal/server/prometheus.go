@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+const promContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler renders all stored metrics in the Prometheus text
+// exposition format. If withRuntime is set, Go runtime and process metrics
+// of the server itself are added.
+func PrometheusHandler(st Storage, withRuntime bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := st.List()
+
+		var buf = dumpPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer dumpPool.Put(buf)
+
+		pw := newPromWriter()
+		for _, n := range common.SortedKeys(stats.Counters) {
+			pw.write(n, strTypCounter, float64(stats.Counters[n]))
+		}
+		for _, n := range common.SortedKeys(stats.Gauges) {
+			pw.write(n, strTypGauge, stats.Gauges[n])
+		}
+		if withRuntime {
+			writeRuntimeMetrics(pw)
+		}
+		pw.flush(buf)
+
+		w.Header().Set("Content-Type", promContentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+// promFamily holds all series of one metric name
+type promFamily struct {
+	typ     string
+	samples []string
+}
+
+// promWriter groups the samples by the metric name, so that every
+// name gets exactly one TYPE line
+type promWriter struct {
+	families map[string]*promFamily
+	series   map[string]struct{}
+	order    []string
+}
+
+func newPromWriter() *promWriter {
+	return &promWriter{
+		families: make(map[string]*promFamily),
+		series:   make(map[string]struct{}),
+	}
+}
+
+// write adds a single sample for the series key (see common.SeriesKey).
+// Series which end up the same after sanitising are reported once, and
+// a name can't be used with two different types.
+func (pw *promWriter) write(key, typ string, value float64) {
+	name, labels, err := common.ParseSeriesKey(key)
+	if err != nil {
+		log.Printf("prometheus: %v", err)
+		name, labels = key, nil
+	}
+
+	promName := sanitizePromName(name, true)
+	series := promName
+	if len(labels) > 0 {
+		promLabels := make(map[string]string, len(labels))
+		for k, v := range labels {
+			promLabels[sanitizePromName(k, false)] = v
+		}
+		series += "{" + common.FormatLabels(promLabels) + "}"
+	}
+
+	f, ok := pw.families[promName]
+	if !ok {
+		f = &promFamily{typ: typ}
+		pw.families[promName] = f
+		pw.order = append(pw.order, promName)
+	}
+	if f.typ != typ {
+		log.Printf("prometheus: skipping %s %s, name %s is already used by %s",
+			typ, key, promName, f.typ)
+		return
+	}
+	if _, ok := pw.series[series]; ok {
+		log.Printf("prometheus: skipping %s %s, series %s is already written",
+			typ, key, series)
+		return
+	}
+	pw.series[series] = struct{}{}
+
+	f.samples = append(f.samples, series+" "+formatPromValue(value))
+}
+
+func (pw *promWriter) flush(buf *bytes.Buffer) {
+	for _, name := range pw.order {
+		f := pw.families[name]
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.typ)
+		for _, s := range f.samples {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// sanitizePromName converts the name to match [a-zA-Z_:][a-zA-Z0-9_:]*,
+// colons are not allowed in label names
+func sanitizePromName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+	b := []byte(name)
+	for i, c := range b {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (c == ':' && allowColon)
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && i > 0) {
+			b[i] = '_'
+		}
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return "_" + name[:1] + string(b[1:])
+	}
+	return string(b)
+}
+
+func formatPromValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeRuntimeMetrics(pw *promWriter) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	pw.write("go_goroutines", strTypGauge, float64(runtime.NumGoroutine()))
+	pw.write("go_memstats_alloc_bytes", strTypGauge, float64(ms.Alloc))
+	pw.write("go_memstats_alloc_bytes_total", strTypCounter, float64(ms.TotalAlloc))
+	pw.write("go_memstats_sys_bytes", strTypGauge, float64(ms.Sys))
+	pw.write("go_memstats_heap_alloc_bytes", strTypGauge, float64(ms.HeapAlloc))
+	pw.write("go_memstats_heap_inuse_bytes", strTypGauge, float64(ms.HeapInuse))
+	pw.write("go_memstats_heap_objects", strTypGauge, float64(ms.HeapObjects))
+	pw.write("go_memstats_mallocs_total", strTypCounter, float64(ms.Mallocs))
+	pw.write("go_memstats_frees_total", strTypCounter, float64(ms.Frees))
+	pw.write("go_memstats_gc_cpu_fraction", strTypGauge, ms.GCCPUFraction)
+	pw.write("go_memstats_last_gc_time_seconds", strTypGauge, float64(ms.LastGC)/1e9)
+	pw.write("go_gc_cycles_total", strTypCounter, float64(ms.NumGC))
+
+	p, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if t, err := p.CreateTime(); err == nil {
+		pw.write("process_start_time_seconds", strTypGauge, float64(t)/1000)
+	}
+	if t, err := p.Times(); err == nil {
+		pw.write("process_cpu_seconds_total", strTypCounter, t.User+t.System)
+	}
+	if m, err := p.MemoryInfo(); err == nil {
+		pw.write("process_resident_memory_bytes", strTypGauge, float64(m.RSS))
+		pw.write("process_virtual_memory_bytes", strTypGauge, float64(m.VMS))
+	}
+	if n, err := p.NumFDs(); err == nil {
+		pw.write("process_open_fds", strTypGauge, float64(n))
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sanitizePromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "HeapAlloc", want: "HeapAlloc"},
+		{name: "cpu.util-0", want: "cpu_util_0"},
+		{name: "0day", want: "_0day"},
+		{name: "ns:metric_1", want: "ns:metric_1"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizePromName(tt.name, true))
+		})
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	st := NewMemStorage()
+	_, err := st.AddCounter("PollCount", 5)
+	require.NoError(t, err)
+	require.NoError(t, st.SetGauge("Alloc", 2128506))
+	require.NoError(t, st.SetGauge("Heap.Alloc", 0.5))
+	require.NoError(t, st.SetGauge("Heap_Alloc", 1.5))
+	require.NoError(t, st.SetGauge(`CPUutilization{cpu="1"}`, 20))
+	require.NoError(t, st.SetGauge(`CPUutilization{cpu="0"}`, 10))
+	require.NoError(t, st.SetGauge(`PollCount{host="a"}`, 1))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	res := httptest.NewRecorder()
+	PrometheusHandler(st, false)(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, promContentType, res.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE PollCount counter
+PollCount 5
+# TYPE Alloc gauge
+Alloc 2.128506e+06
+# TYPE CPUutilization gauge
+CPUutilization{cpu="0"} 10
+CPUutilization{cpu="1"} 20
+# TYPE Heap_Alloc gauge
+Heap_Alloc 0.5
+`, res.Body.String())
+
+	res = httptest.NewRecorder()
+	PrometheusHandler(st, true)(res, req)
+	assert.Contains(t, res.Body.String(), "# TYPE go_goroutines gauge\n")
+	assert.Contains(t, res.Body.String(), "# TYPE go_memstats_alloc_bytes_total counter\n")
+}
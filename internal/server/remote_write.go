@@ -0,0 +1,175 @@
+package server
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/prompb"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/promtext"
+)
+
+// remoteWriteTypes keeps the metric types from the metadata. Prometheus
+// sends the metadata in separate requests, so it is kept between them
+type remoteWriteTypes struct {
+	types map[string]string
+	mu    sync.Mutex
+}
+
+// RemoteWriteHandler stores metrics sent with Prometheus remote_write,
+// a snappy compressed WriteRequest protobuf.
+//
+// The __name__ label is the metric name, other labels are kept.
+// The metric type is taken from the metadata sent earlier or in the
+// same request:
+//   - counters become counters with the increase of the value,
+//     truncated to integers,
+//   - histogram name_bucket and name_count become counters,
+//     name_sum becomes a gauge,
+//   - summary name_count becomes a counter, quantiles and name_sum
+//     become gauges,
+//   - other types become gauges.
+//
+// Without metadata the names ending with _total, _count and _bucket
+// are counters, the rest are gauges. NaN (including staleness markers)
+// and infinite values are skipped, as are negative counters, series
+// without the name and series with label names other than
+// [a-zA-Z_][a-zA-Z0-9_]*.
+// Timestamps are ignored, the samples of a series are applied in order.
+//
+// The body is never encrypted. See ReceiverAuth for the authentication
+func RemoteWriteHandler(s *Server) http.HandlerFunc {
+	types := &remoteWriteTypes{types: make(map[string]string)}
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, " ", r.URL)
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		buf, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req prompb.WriteRequest
+		if err := proto.Unmarshal(buf, &req); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		types.update(req.GetMetadata())
+		var stats []statReq
+		for _, ts := range req.GetTimeseries() {
+			stats = append(stats, types.stats(ts)...)
+		}
+		for _, stat := range stats {
+			if err := s.updateStatStorage(stat); err != nil {
+				log.Print(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (t *remoteWriteTypes) update(metadata []*prompb.MetricMetadata) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range metadata {
+		var typ string
+		switch m.GetType() {
+		case prompb.MetricMetadata_COUNTER:
+			typ = promtext.TypeCounter
+		case prompb.MetricMetadata_GAUGE:
+			typ = promtext.TypeGauge
+		case prompb.MetricMetadata_HISTOGRAM:
+			typ = promtext.TypeHistogram
+		case prompb.MetricMetadata_SUMMARY:
+			typ = promtext.TypeSummary
+		default:
+			typ = promtext.TypeUntyped
+		}
+		t.types[m.GetMetricFamilyName()] = typ
+	}
+}
+
+// isCounter tells if the series of the name is a counter
+func (t *remoteWriteTypes) isCounter(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if typ, ok := t.types[name]; ok {
+		return typ == promtext.TypeCounter
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		base := strings.TrimSuffix(name, suffix)
+		if base == name {
+			continue
+		}
+		switch t.types[base] {
+		case promtext.TypeHistogram:
+			return suffix != "_sum"
+		case promtext.TypeSummary:
+			return suffix == "_count"
+		}
+	}
+	return strings.HasSuffix(name, "_total") ||
+		strings.HasSuffix(name, "_count") ||
+		strings.HasSuffix(name, "_bucket")
+}
+
+// stats maps the samples of the series onto the metrics
+func (t *remoteWriteTypes) stats(ts *prompb.TimeSeries) []statReq {
+	var name string
+	labels := make(map[string]string, len(ts.GetLabels()))
+	for _, l := range ts.GetLabels() {
+		if l.GetName() == "__name__" {
+			name = l.GetValue()
+			continue
+		}
+		labels[l.GetName()] = l.GetValue()
+	}
+	err := common.CheckName(name)
+	if err == nil {
+		err = common.CheckLabels(labels)
+	}
+	if err != nil {
+		log.Printf("remote write: skipping series: %v", err)
+		return nil
+	}
+	key := common.SeriesKey(name, labels)
+	isCounter := t.isCounter(name)
+
+	var stats []statReq
+	for _, s := range ts.GetSamples() {
+		v := s.GetValue()
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if !isCounter {
+			stats = append(stats, statReq{name: key, statType: statTypeGauge, valueGauge: v})
+			continue
+		}
+		if v < 0 || v > math.MaxInt64 {
+			continue
+		}
+		// Prometheus counters are running totals
+		stats = append(stats, statReq{
+			name:         key,
+			statType:     statTypeCounter,
+			valueCounter: int64(v),
+			isTotal:      true,
+		})
+	}
+	return stats
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/prompb"
+)
+
+// postRemoteWrite posts the recorded snappy compressed WriteRequest
+func postRemoteWrite(t *testing.T, ts *httptest.Server, file string) int {
+	body, err := os.ReadFile(file)
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/api/v1/write", "application/x-protobuf", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRemoteWriteHandler(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	assert.Equal(t, http.StatusNoContent, postRemoteWrite(t, ts, "testdata/remote_write_metadata.bin"))
+	assert.Equal(t, http.StatusNoContent, postRemoteWrite(t, ts, "testdata/remote_write.bin"))
+
+	stats := st.List()
+	assert.Equal(t, 1.0, stats.Gauges[`up{instance="localhost:9100",job="node"}`])
+	assert.Equal(t, int64(25), stats.Counters[`http_requests_total{code="200",job="api"}`])
+	assert.Equal(t, int64(5), stats.Counters[`process_cpu_seconds{job="api"}`])
+	// the metadata type wins over the name suffix
+	assert.Equal(t, 7.0, stats.Gauges[`queue_count{job="api"}`])
+	assert.Equal(t, 0.05, stats.Gauges[`rpc_duration_seconds{quantile="0.5"}`])
+	assert.Equal(t, int64(40), stats.Counters["rpc_duration_seconds_count"])
+	assert.Equal(t, 2.5, stats.Gauges["rpc_duration_seconds_sum"])
+	// the staleness marker is skipped
+	assert.Equal(t, 1.0, stats.Gauges["stale"])
+	assert.Len(t, stats.Gauges, 5)
+	assert.Len(t, stats.Counters, 3)
+}
+
+func TestRemoteWriteBadLabelName(t *testing.T) {
+	types := &remoteWriteTypes{types: make(map[string]string)}
+	ts := &prompb.TimeSeries{
+		Labels: []*prompb.Label{
+			{Name: "__name__", Value: "up"},
+			{Name: `a="1",b`, Value: "2"},
+		},
+		Samples: []*prompb.Sample{{Value: 1}},
+	}
+	assert.Empty(t, types.stats(ts))
+}
+
+func TestRemoteWriteHandlerRetry(t *testing.T) {
+	st := &flakyStorage{MemStorage: NewMemStorage()}
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	post := func(total float64) int {
+		buf, err := proto.Marshal(&prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+			Labels:  []*prompb.Label{{Name: "__name__", Value: "requests_total"}},
+			Samples: []*prompb.Sample{{Value: total}},
+		}}})
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/api/v1/write", "application/x-protobuf",
+			bytes.NewReader(snappy.Encode(nil, buf)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNoContent, post(10))
+	st.down.Store(true)
+	assert.Equal(t, http.StatusInternalServerError, post(15))
+	st.down.Store(false)
+	// Prometheus sends the same samples again
+	assert.Equal(t, http.StatusNoContent, post(15))
+	total, _ := st.GetCounter("requests_total")
+	assert.Equal(t, int64(15), total)
+}
+
+func TestRemoteWriteHandlerNoMetadata(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	assert.Equal(t, http.StatusNoContent, postRemoteWrite(t, ts, "testdata/remote_write.bin"))
+
+	stats := st.List()
+	assert.Equal(t, int64(7), stats.Counters[`queue_count{job="api"}`])
+	assert.Equal(t, 5.2, stats.Gauges[`process_cpu_seconds{job="api"}`])
+	assert.Equal(t, 0.05, stats.Gauges[`rpc_duration_seconds{quantile="0.5"}`])
+
+	resp, err := http.Post(ts.URL+"/api/v1/write", "application/x-protobuf", strings.NewReader("not snappy"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
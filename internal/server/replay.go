@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+const (
+	// nonceCacheSize limits the number of nonces remembered, i.e. the
+	// signed batches accepted within the replay window
+	nonceCacheSize = 100000
+	// defaultReplayWindow is used if the replay window is not set
+	defaultReplayWindow = 5 * time.Minute
+)
+
+var (
+	errReplayed       = errors.New("batch replayed")
+	errNonceCacheFull = errors.New("too many signed batches within the replay window")
+)
+
+// nonceCache remembers the nonces of signed batches to reject
+// the batches sent again. Batches older than the replay window are
+// rejected by the timestamp, so their nonces are dropped. If the cache
+// is full new batches are rejected until the nonces expire, dropping
+// a nonce within the window would allow its batch to be replayed
+type nonceCache struct {
+	seen  map[string]time.Time
+	order []string
+	size  int
+	mu    sync.Mutex
+}
+
+func newNonceCache(size int) *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time), size: size}
+}
+
+// check checks the timestamp is within the window from now and
+// records the nonce, it fails if the nonce is already known
+func (c *nonceCache) check(timestamp int64, nonce string, window time.Duration, now time.Time) error {
+	if nonce == "" || len(nonce) > common.MaxNonceLength {
+		return fmt.Errorf("bad nonce")
+	}
+	ts := time.Unix(timestamp, 0)
+	if ts.Before(now.Add(-window)) || ts.After(now.Add(window)) {
+		return fmt.Errorf("timestamp %v is out of the %v window", ts.UTC(), window)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 {
+		oldest := c.order[0]
+		if !c.seen[oldest].Before(now.Add(-window)) {
+			break
+		}
+		delete(c.seen, oldest)
+		c.order = c.order[1:]
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return errReplayed
+	}
+	if len(c.order) >= c.size {
+		return errNonceCacheFull
+	}
+	c.seen[nonce] = ts
+	c.order = append(c.order, nonce)
+	return nil
+}
+
+// checkEnvelope checks the timestamp and the nonce of a batch signed
+// with the key, the hash is checked by the caller. Nothing is checked
+// without the key
+func (s *Server) checkEnvelope(key string, timestamp int64, nonce string) error {
+	if key == "" {
+		return nil
+	}
+	window := s.cfg.ReplayWindow
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	return s.nonces.check(timestamp, nonce, window, time.Now())
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+func TestNonceCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	window := time.Minute
+	c := newNonceCache(3)
+
+	assert.NoError(t, c.check(now.Unix(), "a", window, now))
+	assert.ErrorIs(t, c.check(now.Unix(), "a", window, now), errReplayed)
+	assert.Error(t, c.check(now.Add(-2*window).Unix(), "b", window, now))
+	assert.Error(t, c.check(now.Add(2*window).Unix(), "b", window, now))
+	assert.Error(t, c.check(now.Unix(), "", window, now))
+
+	// the cache is bounded, nonces within the window are kept
+	assert.NoError(t, c.check(now.Unix(), "b", window, now))
+	assert.NoError(t, c.check(now.Unix(), "c", window, now))
+	assert.ErrorIs(t, c.check(now.Unix(), "d", window, now), errNonceCacheFull)
+	assert.Len(t, c.seen, 3)
+	assert.ErrorIs(t, c.check(now.Unix(), "a", window, now), errReplayed)
+
+	// expired nonces are dropped
+	later := now.Add(2 * window)
+	assert.NoError(t, c.check(later.Unix(), "e", window, later))
+	assert.Len(t, c.seen, 1)
+}
+
+func TestJSONUpdateEnvelope(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{Key: "secret"}, st)))
+	defer ts.Close()
+
+	delta := int64(5)
+	e := common.Envelope{Metrics: []common.Metrics{{ID: "PollCount", MType: common.NameCounter, Delta: &delta}}}
+	require.NoError(t, e.Sign("secret"))
+	body, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	post := func(body []byte) int {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/updates/", bytes.NewReader(body), true)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, post(body))
+	// the captured batch is rejected
+	assert.Equal(t, http.StatusBadRequest, post(body))
+
+	// a HashV1 envelope, as older agents send
+	stale := common.Envelope{
+		Timestamp: e.Timestamp - int64(time.Hour.Seconds()),
+		Nonce:     "other",
+		Metrics:   e.Metrics,
+	}
+	stale.Hash = common.EnvelopeHash("secret", 0, "", stale.Timestamp, stale.Nonce, []string{e.Metrics[0].String()})
+	body, err = json.Marshal(stale)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, post(body))
+	stale.Timestamp = e.Timestamp
+	stale.Hash = common.EnvelopeHash("secret", 0, "", stale.Timestamp, stale.Nonce, []string{e.Metrics[0].String()})
+	body, err = json.Marshal(stale)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, post(body))
+
+	stale.Nonce = "another"
+	stale.Version = 3
+	body, err = json.Marshal(stale)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, post(body))
+
+	require.NoError(t, e.Sign("wrong"))
+	body, err = json.Marshal(e)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, post(body))
+
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(10), c)
+}
+
+func TestGRPCEnvelope(t *testing.T) {
+	st := NewMemStorage()
+	client := startTestGRPC(t, ConfigType{Key: "secret"}, st)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mm := []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 2}}
+	env, err := grpcint.SignEnvelope(mm, "", "secret")
+	require.NoError(t, err)
+	req := pb.UpdateMetricesRequest{Count: 1, Metrices: mm, Envelope: env}
+
+	resp, err := client.UpdateMetrices(ctx, &req)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Error)
+	resp, err = client.UpdateMetrices(ctx, &req)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Error)
+
+	stream, err := client.StreamMetrices(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.MetricsBatch{Id: 1, Metrices: mm, Envelope: env}))
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.NotEmpty(t, ack.Error)
+	require.NoError(t, stream.CloseSend())
+
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(2), c)
+}
+
+func TestRequireEnvelope(t *testing.T) {
+	cfg := ConfigType{RequireEnvelope: true}
+	_, err := NewServer(cfg, NewMemStorage())
+	assert.Error(t, err)
+
+	cfg.Key = "secret"
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, cfg, st)))
+	defer ts.Close()
+
+	delta := int64(1)
+	m := common.Metrics{ID: "PollCount", MType: common.NameCounter, Delta: &delta}
+	require.NoError(t, m.StoreHash("secret"))
+	single, err := json.Marshal(m)
+	require.NoError(t, err)
+	batch, err := json.Marshal([]common.Metrics{m})
+	require.NoError(t, err)
+	e := common.Envelope{Metrics: []common.Metrics{{ID: "PollCount", MType: common.NameCounter, Delta: &delta}}}
+	require.NoError(t, e.Sign("secret"))
+	signed, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		path string
+		body []byte
+		want int
+	}{
+		{"/update/", single, http.StatusForbidden},
+		{"/updates/", batch, http.StatusForbidden},
+		{"/update/counter/PollCount/1", nil, http.StatusForbidden},
+		{"/updates/", signed, http.StatusOK},
+	} {
+		resp, _ := testRequest(t, ts, http.MethodPost, tt.path, bytes.NewReader(tt.body), true)
+		resp.Body.Close()
+		assert.Equal(t, tt.want, resp.StatusCode, tt.path)
+	}
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(1), c)
+}
+
+func TestResponseNotWrite(t *testing.T) {
+	st := NewMemStorage()
+	st.AddCounter("PollCount", 5)
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{Key: "secret"}, st)))
+	defer ts.Close()
+
+	_, body := testRequest(t, ts, http.MethodPost, "/value/",
+		bytes.NewReader([]byte(`{"id":"PollCount","type":"counter"}`)), true)
+	var m common.Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &m))
+	assert.NoError(t, m.CheckHash(common.ResponseKey("secret")))
+
+	// the signed value read is refused as a write
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/", bytes.NewReader([]byte(body)), true)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(5), c)
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,12 +21,9 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"google.golang.org/grpc"
 
 	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
 	"github.com/alexey-mavrin/go-musthave-devops/internal/crypt"
-
-	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
 )
 
 type statType int
@@ -38,18 +36,59 @@ type ConfigType struct {
 	CryptoKey     string
 	DatabaseDSN   string
 	TrustedSubnet *net.IPNet
-	StoreInterval time.Duration
-	Restore       bool
-}
-
-// Config stores server configuration
-var Config ConfigType = ConfigType{}
-
-var mu sync.Mutex
-
-var statistics struct {
-	Counters map[string]int64
-	Gauges   map[string]float64
+	// GRPCAddress is the gRPC listen address
+	GRPCAddress string
+	// GRPCCertFile and GRPCKeyFile enable TLS for gRPC
+	GRPCCertFile string
+	GRPCKeyFile  string
+	// GRPCClientCAFile makes gRPC require client certificates signed by it
+	GRPCClientCAFile string
+	StoreInterval    time.Duration
+	// ShutdownTimeout limits the time to wait for running requests on exit
+	ShutdownTimeout time.Duration
+	// HistoryRetention is how long every accepted sample is kept,
+	// zero disables the history
+	HistoryRetention time.Duration
+	Restore          bool
+	// PromRuntimeMetrics adds server runtime and process metrics to /metrics
+	PromRuntimeMetrics bool
+	// GRPCEnabled starts the gRPC server
+	GRPCEnabled bool
+	// CumulativeCounters makes the server treat received counter values
+	// as running totals of the sender instead of deltas
+	CumulativeCounters bool
+	// GraphiteAddress is the TCP address to accept Graphite plaintext
+	// metrics on, no listener if empty
+	GraphiteAddress string
+	// StatsDAddress is the UDP address to accept StatsD metrics on,
+	// no listener if empty
+	StatsDAddress string
+	// StatsDFlushInterval is how often aggregated StatsD metrics are stored
+	StatsDFlushInterval time.Duration
+	// ReplayWindow is the allowed difference between the timestamp of
+	// a signed batch and the server clock
+	ReplayWindow time.Duration
+	// AgentKeysFile is the JSON file with the keys of the agents,
+	// {"agent-id": "key", ...}. With the agent keys only batches signed
+	// by a known agent are accepted
+	AgentKeysFile string
+	// AgentKeysDB makes the server look up the keys of the agents
+	// in the agent_keys table of the database
+	AgentKeysDB bool
+	// AgentLabel is the label to add with the agent ID to the metrics
+	// of signed batches, no label if empty
+	AgentLabel string
+	// TokensFile is the JSON file with the hashed API tokens and their
+	// scopes, no authentication if neither it nor TokensDB is set
+	TokensFile string
+	// TokensDB makes the server look up the API tokens in the
+	// api_tokens table of the database
+	TokensDB bool
+	// RequireEnvelope makes the server accept only writes signed with
+	// a replay-protected envelope, it requires the key or the agent keys.
+	// The Telegraf, remote_write and OTLP receivers are closed then,
+	// even for API tokens
+	RequireEnvelope bool
 }
 
 const (
@@ -69,80 +108,127 @@ var (
 	errBadValue  = fmt.Errorf("bad value")
 )
 
-var privateServerKey *rsa.PrivateKey
+// Server is a metrics server: the config, the storage and the state kept
+// between requests. Every server has its own config and state, so several
+// servers can run in one process
+type Server struct {
+	cfg ConfigType
+	st  Storage
+	// privateKey decrypts the bodies of writes, nil if not configured
+	privateKey *rsa.PrivateKey
+	cumulative *cumulativeCounters
+	nonces     *nonceCache
+	// agentKeys is the registry to look up the keys of signed batches
+	// with the agent ID, the shared cfg.Key is used if it is nil
+	agentKeys keyRegistry
+	// apiTokens is the registry to authenticate requests with,
+	// no authentication if it is nil
+	apiTokens tokenRegistry
+	// running counts the requests being served
+	running sync.WaitGroup
+	// done is closed when the server is shutting down
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer returns the server with the config storing metrics in st,
+// with the private key, the agent keys and the API tokens of the config
+func NewServer(cfg ConfigType, st Storage) (*Server, error) {
+	s := &Server{
+		cfg:        cfg,
+		st:         st,
+		cumulative: newCumulativeCounters(),
+		nonces:     newNonceCache(nonceCacheSize),
+		done:       make(chan struct{}),
+	}
+	var err error
+	if cfg.CryptoKey != "" {
+		if s.privateKey, err = crypt.ReadPrivateKey(cfg.CryptoKey); err != nil {
+			return nil, err
+		}
+	}
+	if s.agentKeys, err = loadAgentKeys(cfg, st); err != nil {
+		return nil, err
+	}
+	if s.apiTokens, err = loadTokens(cfg, st); err != nil {
+		return nil, err
+	}
+	if cfg.RequireEnvelope && cfg.Key == "" && s.agentKeys == nil {
+		return nil, errors.New("required envelopes need the key or the agent keys")
+	}
+	if cfg.AgentLabel != "" {
+		if err := common.CheckLabels(map[string]string{cfg.AgentLabel: ""}); err != nil {
+			return nil, fmt.Errorf("agent label: %w", err)
+		}
+	}
+	return s, nil
+}
 
 type statReq struct {
 	name         string
 	statType     statType
 	valueCounter int64
 	valueGauge   float64
+	// isDelta means the counter value is a delta even with
+	// CumulativeCounters configured, e.g. aggregated by the server
+	isDelta bool
+	// isTotal means the counter value is a running total of the sender
+	// even without CumulativeCounters, e.g. an OTLP cumulative sum
+	isTotal bool
 }
 
-func init() {
-	statistics.Counters = make(map[string]int64)
-	statistics.Gauges = make(map[string]float64)
-}
-
-// ReadServerKey reads server private key if provided
-func ReadServerKey() error {
-	if Config.CryptoKey == "" {
-		return nil
+// StartServer starts server with the config
+func StartServer(cfg ConfigType) error {
+	st, err := NewStorage(cfg)
+	if err != nil {
+		return err
 	}
-	var err error
-	privateServerKey, err = crypt.ReadPrivateKey(Config.CryptoKey)
-	return err
-}
 
-// StartServer starts server
-func StartServer() error {
-	if err := connectDB(); err != nil {
-		log.Printf("failed to connect db: %v", err)
+	srv, err := NewServer(cfg, st)
+	if err != nil {
+		st.Close()
+		return err
 	}
 
-	if Config.Restore {
-		if Config.DatabaseDSN != "" {
-			if err := loadStatsDB(); err != nil {
-				log.Print(err)
-			}
-		} else if Config.StoreFile != "" {
-			if err := loadStats(); err != nil {
-				log.Print(err)
-			}
-		}
+	httpServer := &http.Server{
+		Addr:    cfg.Address,
+		Handler: Router(srv),
 	}
-
-	if Config.StoreInterval > 0 && Config.StoreFile != "" {
-		go statSaver()
+	grpcServer, err := newGRPCServer(srv)
+	if err != nil {
+		st.Close()
+		return err
 	}
-	if Config.DatabaseDSN != "" {
-		if err := initDBTable(); err != nil {
-			log.Printf("failed to init db tables: %v", err)
-		}
+	ls, err := startListeners(srv)
+	if err != nil {
+		st.Close()
+		return err
 	}
 
-	r := Router()
-
-	c := make(chan error)
+	// both goroutines may fail, so the channel must not block them
+	c := make(chan error, 2)
 	go func() {
-		err := http.ListenAndServe(Config.Address, r)
-		c <- err
-	}()
-
-	go func() {
-		listen, err := net.Listen("tcp", ":3200")
-		if err != nil {
+		err := httpServer.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
 			c <- err
 		}
-		s := grpc.NewServer()
-		pb.RegisterMetricesServer(s, &MetricesServer{})
-		log.Print("Serving gRPC...")
-		err = s.Serve(listen)
-		if err != nil {
-			c <- err
-		}
-
 	}()
 
+	if cfg.GRPCEnabled {
+		go func() {
+			listen, err := net.Listen("tcp", cfg.GRPCAddress)
+			if err != nil {
+				c <- err
+				return
+			}
+			log.Printf("Serving gRPC on %s...", cfg.GRPCAddress)
+			err = grpcServer.Serve(listen)
+			if err != nil {
+				c <- err
+			}
+		}()
+	}
+
 	signalChannel := make(chan os.Signal, 2)
 	// Сервер должен штатно завершаться по сигналам: syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
@@ -160,74 +246,37 @@ func StartServer() error {
 		}
 	case err := <-c:
 		log.Print(err)
+		srv.stop()
+		shutdown(cfg.ShutdownTimeout, httpServer, grpcServer)
+		ls.close()
+		srv.wait()
+		st.Close()
 		return err
 	}
 
-	mu.Lock()
-	log.Print("server finished, storing stats")
-	if Config.StoreFile != "" && Config.DatabaseDSN == "" {
-		if err := storeStats(); err != nil {
-			log.Print(err)
-			return err
-		}
-	}
-	mu.Unlock()
-
-	if db != nil {
-		db.Close()
-	}
-	return nil
-}
-
-func statSaver() {
-	ticker := time.NewTicker(Config.StoreInterval)
-	for {
-		<-ticker.C
-		mu.Lock()
-		if Config.DatabaseDSN == "" {
-			if err := storeStats(); err != nil {
-				log.Print(err)
-			}
-		}
-		mu.Unlock()
-	}
-
-}
-
-func storeStats() error {
-	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-
-	f, err := os.OpenFile(Config.StoreFile, flags, 0644)
-	if err != nil {
-		log.Print("cannot open file for writing: ", err)
-		return err
+	srv.stop()
+	if err := shutdown(cfg.ShutdownTimeout, httpServer, grpcServer); err != nil {
+		log.Print(err)
 	}
-	defer f.Close()
+	ls.close()
+	srv.wait()
 
-	if err := json.NewEncoder(f).Encode(statistics); err != nil {
-		log.Print("cannot encode statistics: ", err)
+	// all the writers are finished now
+	log.Print("server finished, storing stats")
+	if err := st.Close(); err != nil {
+		log.Print(err)
 		return err
 	}
 	return nil
 }
 
-func loadStats() error {
-	flags := os.O_RDONLY
-	mu.Lock()
-	defer mu.Unlock()
-
-	f, err := os.OpenFile(Config.StoreFile, flags, 0)
-	if err != nil {
-		log.Print("cannot open file for reading ", err)
-		return err
-	}
-	defer f.Close()
-
-	if err := json.NewDecoder(f).Decode(&statistics); err != nil {
-		log.Print("cannot decode statistics ", err)
+// checkSeries checks the metric name and the label names
+// can make the series key
+func checkSeries(name string, labels map[string]string) error {
+	if err := common.CheckName(name); err != nil {
 		return err
 	}
-	return nil
+	return common.CheckLabels(labels)
 }
 
 func parseReq(r *http.Request) (statReq, error) {
@@ -239,6 +288,9 @@ func parseReq(r *http.Request) (statReq, error) {
 	if len(name) == 0 {
 		return stat, errNoName
 	}
+	if common.CheckName(name) != nil {
+		return stat, errBadValue
+	}
 
 	switch typ {
 	case strTypCounter:
@@ -279,98 +331,102 @@ func Handler400(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Bad Request"))
 }
 
-// JSONMetricHandler reports required metrics
-func JSONMetricHandler(w http.ResponseWriter, r *http.Request) {
-	log.Print(r.Method, " ", r.URL)
-	body, err := ioutil.ReadAll(r.Body)
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Header.Get("Content-Type") != "application/json" {
-		writeStatus(w, http.StatusBadRequest, "Bad Request", false)
-		return
-	}
-
-	if err != nil {
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
-		return
-	}
-
-	var m common.Metrics
-
-	err = json.Unmarshal(body, &m)
-	if err != nil {
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-		return
-	}
+// JSONMetricHandler reports required metrics. With the key set the metric
+// is signed with common.ResponseKey, so it can't be sent back as a write
+func JSONMetricHandler(s *Server) http.HandlerFunc {
+	st := s.st
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, " ", r.URL)
+		body, err := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", false)
+			return
+		}
 
-	if m.ID == "" {
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-		return
-	}
+		if err != nil {
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+			return
+		}
 
-	log.Print("type: ", m.MType, ", id: ", m.ID)
-	mu.Lock()
-	defer mu.Unlock()
+		var m common.Metrics
 
-	switch m.MType {
-	case strTypCounter:
-		val, ok := statistics.Counters[m.ID]
-		if !ok {
-			writeStatus(w, http.StatusNotFound, "Not Found", true)
+		err = json.Unmarshal(body, &m)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 			return
 		}
-		m.Delta = &val
-		err = m.StoreHash(Config.Key)
-		if err != nil {
-			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+
+		if err := checkSeries(m.ID, m.Labels); err != nil {
+			log.Print(err)
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 			return
 		}
-	case strTypGauge:
-		val, ok := statistics.Gauges[m.ID]
-		if !ok {
-			writeStatus(w, http.StatusNotFound, "Not Found", true)
+
+		log.Print("type: ", m.MType, ", id: ", m.ID)
+
+		switch m.MType {
+		case strTypCounter:
+			val, ok := st.GetCounter(m.Key())
+			if !ok {
+				writeStatus(w, http.StatusNotFound, "Not Found", true)
+				return
+			}
+			m.Delta = &val
+			err = m.StoreHash(common.ResponseKey(s.cfg.Key))
+			if err != nil {
+				writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+				return
+			}
+		case strTypGauge:
+			val, ok := st.GetGauge(m.Key())
+			if !ok {
+				writeStatus(w, http.StatusNotFound, "Not Found", true)
+				return
+			}
+			m.Value = &val
+			err = m.StoreHash(common.ResponseKey(s.cfg.Key))
+			if err != nil {
+				writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+				return
+			}
+		default:
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 			return
 		}
-		m.Value = &val
-		err = m.StoreHash(Config.Key)
-		if err != nil {
+		if err := json.NewEncoder(w).Encode(m); err != nil {
 			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
 			return
 		}
-	default:
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-		return
+		log.Printf("answer: %+v", m)
 	}
-	if err := json.NewEncoder(w).Encode(m); err != nil {
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
-		return
-	}
-	log.Printf("answer: %+v", m)
 }
 
-// MetricHandler prints all available metrics
-func MetricHandler(w http.ResponseWriter, r *http.Request) {
-	typ := chi.URLParam(r, "typ")
-	name := chi.URLParam(r, "name")
-	log.Println("GET", typ, name)
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	if typ == strTypCounter {
-		val, ok := statistics.Counters[name]
-		if !ok {
-			writeStatus(w, http.StatusNotFound, "Not Found", true)
-		}
-		w.Write([]byte(fmt.Sprint(val)))
-	} else if typ == strTypGauge {
-		val, ok := statistics.Gauges[name]
-		if !ok {
-			writeStatus(w, http.StatusNotFound, "Not Found", true)
+// MetricHandler prints the requested metric
+func MetricHandler(st Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		typ := chi.URLParam(r, "typ")
+		name := chi.URLParam(r, "name")
+		log.Println("GET", typ, name)
+
+		if typ == strTypCounter {
+			val, ok := st.GetCounter(name)
+			if !ok {
+				writeStatus(w, http.StatusNotFound, "Not Found", true)
+				return
+			}
+			w.Write([]byte(fmt.Sprint(val)))
+		} else if typ == strTypGauge {
+			val, ok := st.GetGauge(name)
+			if !ok {
+				writeStatus(w, http.StatusNotFound, "Not Found", true)
+				return
+			}
+			w.Write([]byte(fmt.Sprint(val)))
+		} else {
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 		}
-		w.Write([]byte(fmt.Sprint(val)))
-	} else {
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 	}
 }
 
@@ -379,183 +435,252 @@ var dumpPool = sync.Pool{
 }
 
 // DumpHandler prints all available metrics
-func DumpHandler(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
+func DumpHandler(st Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := st.List()
 
-	cNames := make([]string, 0, len(statistics.Counters))
-	for k := range statistics.Counters {
-		cNames = append(cNames, k)
-	}
-	sort.Strings(cNames)
-
-	gNames := make([]string, 0, len(statistics.Gauges))
-	for k := range statistics.Gauges {
-		gNames = append(gNames, k)
-	}
-	sort.Strings(gNames)
-
-	var buf = dumpPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer dumpPool.Put(buf)
-
-	for _, n := range cNames {
-		fmt.Fprintf(buf, "%s %v\n", n, statistics.Counters[n])
-	}
-	for _, n := range gNames {
-		fmt.Fprintf(buf, "%s %v\n", n, statistics.Gauges[n])
-	}
-
-	mu.Unlock()
-	w.Header().Set("Content-Type", "text/html")
-	w.Write(buf.Bytes())
-}
+		cNames := make([]string, 0, len(stats.Counters))
+		for k := range stats.Counters {
+			cNames = append(cNames, k)
+		}
+		sort.Strings(cNames)
 
-// JSONUpdateHandler — stores metrics in server from json updates
-func JSONUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	log.Print(r.Method, " ", r.URL)
+		gNames := make([]string, 0, len(stats.Gauges))
+		for k := range stats.Gauges {
+			gNames = append(gNames, k)
+		}
+		sort.Strings(gNames)
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Print(err)
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
-		return
-	}
+		var buf = dumpPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer dumpPool.Put(buf)
 
-	w.Header().Set("Content-Type", "application/json")
+		for _, n := range cNames {
+			fmt.Fprintf(buf, "%s %v\n", n, stats.Counters[n])
+		}
+		for _, n := range gNames {
+			fmt.Fprintf(buf, "%s %v\n", n, stats.Gauges[n])
+		}
 
-	if r.Header.Get("Content-Type") != "application/json" {
-		log.Print("wrong content type")
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-		return
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(buf.Bytes())
 	}
+}
 
-	var mm []common.Metrics
+// JSONUpdateHandler — stores metrics in server from json updates.
+// /updates/ accepts either an array of metrics with their own hashes
+// or a common.Envelope protected against replays
+func JSONUpdateHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, " ", r.URL)
 
-	if r.URL.String() == "/update/" {
-		var m common.Metrics
-		if err = json.Unmarshal(body, &m); err != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
 			log.Print(err)
-			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
 			return
 		}
-		mm = append(mm, m)
-	} else {
-		if err = json.Unmarshal(body, &mm); err != nil {
-			log.Print(err)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			log.Print("wrong content type")
 			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
 			return
 		}
-	}
 
-	log.Printf("%+v", mm)
+		var mm []common.Metrics
+		// metrics of a signed envelope have no own hashes
+		signed := false
 
-	for _, m := range mm {
-		if err = m.CheckHash(Config.Key); err != nil {
-			log.Print(err)
-			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+		isEnvelope := bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) && r.URL.String() != "/update/"
+		if !isEnvelope && s.envelopeRequired() {
+			log.Print(errEnvelopeRequired)
+			writeStatus(w, http.StatusForbidden, "Forbidden", true)
 			return
 		}
 
-		log.Print("type: ", m.MType, ", id: ", m.ID)
-		var stat statReq
-		switch m.MType {
-		case strTypCounter:
-			stat.statType = statTypeCounter
-			stat.valueCounter = *m.Delta
-			log.Print("delta: ", *m.Delta)
-		case strTypGauge:
-			stat.statType = statTypeGauge
-			stat.valueGauge = *m.Value
-			log.Print("value: ", *m.Value)
-		default:
-			writeStatus(w, http.StatusNotImplemented, "Not Implemented", true)
-			return
+		if r.URL.String() == "/update/" {
+			var m common.Metrics
+			if err = json.Unmarshal(body, &m); err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
+			mm = append(mm, m)
+		} else if isEnvelope {
+			var e common.Envelope
+			if err = json.Unmarshal(body, &e); err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
+			// the nonce is recorded only for batches with the right hash
+			key, err := s.envelopeKey(e.AgentID)
+			if err == nil {
+				err = e.CheckHash(key)
+			}
+			if err == nil {
+				err = s.checkEnvelope(key, e.Timestamp, e.Nonce)
+			}
+			// the batch may pass later, the agent keeps it
+			if errors.Is(err, errNonceCacheFull) {
+				log.Print(err)
+				writeStatus(w, http.StatusServiceUnavailable, "Service Unavailable", true)
+				return
+			}
+			if err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
+			for i := range e.Metrics {
+				e.Metrics[i].Labels = s.agentLabels(e.Metrics[i].Labels, e.AgentID)
+			}
+			mm = e.Metrics
+			signed = true
+		} else {
+			if err = json.Unmarshal(body, &mm); err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
 		}
 
-		if m.ID == "" {
-			log.Print("no id given")
-			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-			return
+		log.Printf("%+v", mm)
+
+		// the whole batch is checked before anything is stored, so a
+		// rejected batch doesn't leave its first metrics counted
+		stats := make([]statReq, 0, len(mm))
+		for _, m := range mm {
+			if err = m.CheckHash(s.cfg.Key); err != nil && !signed {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
+
+			log.Print("type: ", m.MType, ", id: ", m.ID)
+			var stat statReq
+			switch {
+			case m.MType == strTypCounter && m.Delta != nil:
+				stat.statType = statTypeCounter
+				stat.valueCounter = *m.Delta
+				log.Print("delta: ", *m.Delta)
+			case m.MType == strTypGauge && m.Value != nil:
+				stat.statType = statTypeGauge
+				stat.valueGauge = *m.Value
+				log.Print("value: ", *m.Value)
+			case m.MType == strTypCounter, m.MType == strTypGauge:
+				log.Print("no value given")
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			default:
+				writeStatus(w, http.StatusNotImplemented, "Not Implemented", true)
+				return
+			}
+
+			if err := checkSeries(m.ID, m.Labels); err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+				return
+			}
+
+			stat.name = m.Key()
+			stats = append(stats, stat)
 		}
 
-		stat.name = m.ID
+		for _, stat := range stats {
+			if err = s.updateStatStorage(stat); err != nil {
+				log.Print(err)
+				writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+				return
+			}
+		}
 
-		updateStatStorage(stat)
+		writeStatus(w, http.StatusOK, "OK", true)
 	}
-
-	writeStatus(w, http.StatusOK, "OK", true)
 }
 
 // UpdateHandler — stores metrics in server
-func UpdateHandler(w http.ResponseWriter, r *http.Request) {
-	log.Print(r.Method, r.URL)
-	stat, err := parseReq(r)
+func UpdateHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Method, r.URL)
+		stat, err := parseReq(r)
 
-	switch err {
-	case errWrongOp, errNoName:
-		writeStatus(w, http.StatusNotFound, "Not Found", true)
-		return
-	case errWrongType:
-		writeStatus(w, http.StatusNotImplemented, "Not Implemented", true)
-		return
-	case errBadValue:
-		writeStatus(w, http.StatusBadRequest, "Bad Request", true)
-		return
-	}
+		switch err {
+		case errWrongOp, errNoName:
+			writeStatus(w, http.StatusNotFound, "Not Found", true)
+			return
+		case errWrongType:
+			writeStatus(w, http.StatusNotImplemented, "Not Implemented", true)
+			return
+		case errBadValue:
+			writeStatus(w, http.StatusBadRequest, "Bad Request", true)
+			return
+		}
 
-	if err := updateStatStorage(stat); err != nil {
-		writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
-		return
-	}
+		if err := s.updateStatStorage(stat); err != nil {
+			log.Print(err)
+			writeStatus(w, http.StatusInternalServerError, "Internal Server Error", true)
+			return
+		}
 
-	writeStatus(w, http.StatusOK, "OK", true)
+		writeStatus(w, http.StatusOK, "OK", true)
+	}
 }
 
-func updateStatStorage(stat statReq) error {
-	mu.Lock()
-	defer mu.Unlock()
+func (s *Server) updateStatStorage(stat statReq) error {
 	switch stat.statType {
 	case statTypeCounter:
-		statistics.Counters[stat.name] += stat.valueCounter
-		if Config.DatabaseDSN != "" {
-			err := storeCounterDB(stat.name, statistics.Counters[stat.name])
-			if err != nil {
-				log.Print(err)
-			}
+		if stat.isTotal || s.cfg.CumulativeCounters && !stat.isDelta {
+			return s.cumulative.add(s.st, stat.name, stat.valueCounter)
 		}
+		_, err := s.st.AddCounter(stat.name, stat.valueCounter)
+		return err
 	case statTypeGauge:
-		statistics.Gauges[stat.name] = stat.valueGauge
-		if Config.DatabaseDSN != "" {
-			err := storeGaugeDB(stat.name, stat.valueGauge)
-			if err != nil {
-				log.Print(err)
-			}
-		}
+		return s.st.SetGauge(stat.name, stat.valueGauge)
 	}
-
-	if Config.StoreInterval == 0 && Config.StoreFile != "" {
-		if err := storeStats(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return errWrongType
 }
 
 // Router return chi.Router for testing and actual work
-func Router() chi.Router {
+func Router(s *Server) chi.Router {
+	st := s.st
 	r := chi.NewRouter()
+	r.Use(s.trackRequests)
 	r.Use(middleware.Compress(5))
-	r.Use(DecryptBody)
-	r.Use(CheckIP)
-	r.Get("/", DumpHandler)
-	r.Get("/ping", DBPing)
-	r.Get("/value/{typ}/{name}", MetricHandler)
-	r.Post("/value/", JSONMetricHandler)
-	r.Post("/update/", JSONUpdateHandler)
-	r.Post("/updates/", JSONUpdateHandler)
-	r.Post("/update/{typ}/{name}/", Handler400)
-	r.Post("/update/{typ}/{name}/{rawVal}", UpdateHandler)
-
-	r.Mount("/debug", middleware.Profiler())
+	r.Use(s.CheckIP)
+
+	// the scopes are checked before anything is decrypted or queried
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireScope(scopeRead))
+		r.Use(s.DecryptBody)
+		// ping queries the database, so it isn't public
+		r.Get("/ping", DBPing(st))
+		r.Get("/", DumpHandler(st))
+		r.Get("/metrics", PrometheusHandler(st, s.cfg.PromRuntimeMetrics))
+		r.Get("/value/{typ}/{name}", MetricHandler(st))
+		r.Post("/value/", JSONMetricHandler(s))
+		r.Get("/history/{typ}/{name}", HistoryHandler(st))
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireScope(scopeWrite))
+		// the OTLP and remote_write receivers get the bodies of stock
+		// clients, never encrypted
+		r.With(s.ReceiverAuth).Post("/v1/metrics", OTLPMetricsHandler(s))
+		r.With(s.ReceiverAuth).Post("/api/v1/write", RemoteWriteHandler(s))
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.DecryptBody)
+			r.With(s.ReceiverAuth).Post("/write", InfluxWriteHandler(s))
+			r.Post("/update/", JSONUpdateHandler(s))
+			r.Post("/updates/", JSONUpdateHandler(s))
+			r.Post("/update/{typ}/{name}/", Handler400)
+			r.With(s.RequireEnvelope).Post("/update/{typ}/{name}/{rawVal}", UpdateHandler(s))
+		})
+	})
+
+	r.With(s.RequireScope(scopeAdmin)).Mount("/debug", middleware.Profiler())
 	return r
 }
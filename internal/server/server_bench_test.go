@@ -57,7 +57,11 @@ func (tv *testValues) setBody(b string) *testValues {
 }
 
 func BenchmarkUpdate(b *testing.B) {
-	router := server.Router()
+	srv, err := server.NewServer(server.ConfigType{}, server.NewMemStorage())
+	if err != nil {
+		b.Fatal(err)
+	}
+	router := server.Router(srv)
 
 	b.Run("updateCounter", func(b *testing.B) {
 		tv := newBenchTest(router).
@@ -21,13 +21,15 @@ func ExampleHandler400() {
 
 func ExampleJSONMetricHandler() {
 	var body bytes.Buffer
+	st := server.NewMemStorage()
+	srv, _ := server.NewServer(server.ConfigType{}, st)
 
 	body.Write([]byte(`{"id":"x100","type":"counter","delta":100}`))
 	req, _ := http.NewRequest("POST", "/update/", &body)
 	req.Header.Set("Content-Type", "application/json")
 	res := httptest.NewRecorder()
 	// set the value to retrieve later.
-	server.JSONUpdateHandler(res, req)
+	server.JSONUpdateHandler(srv)(res, req)
 
 	body.Reset()
 	body.Write([]byte(`{"id":"x100","type":"counter"}`))
@@ -35,7 +37,7 @@ func ExampleJSONMetricHandler() {
 	req.Header.Set("Content-Type", "application/json")
 	res = httptest.NewRecorder()
 	// retrieve the value.
-	server.JSONMetricHandler(res, req)
+	server.JSONMetricHandler(srv)(res, req)
 	fmt.Println(res.Code)
 	// Output:
 	// 200
@@ -24,7 +24,7 @@ func TestRouter(t *testing.T) {
 		body []string
 		code int
 	}
-	router := Router()
+	router := Router(newTestServer(t, ConfigType{}, NewMemStorage()))
 	ts := httptest.NewServer(router)
 	defer ts.Close()
 	tests := []struct {
@@ -203,6 +203,35 @@ func TestRouter(t *testing.T) {
 			},
 		},
 
+		{
+			name:    "update json labelled gauge",
+			method:  "POST",
+			args:    "/update/",
+			useJSON: true,
+			body:    `{"id":"CPUutilization","type":"gauge","value":12.5,"labels":{"cpu":"1"}}`,
+			want:    want{code: 200},
+		},
+		{
+			name:    "get json labelled gauge",
+			method:  "POST",
+			args:    "/value/",
+			useJSON: true,
+			body:    `{"id":"CPUutilization","type":"gauge","labels":{"cpu":"1"}}`,
+			want: want{
+				code: 200,
+				body: []string{
+					`{"id":"CPUutilization","type":"gauge","value":12.5,"labels":{"cpu":"1"}}`,
+				},
+			},
+		},
+		{
+			name:    "get json gauge without labels",
+			method:  "POST",
+			args:    "/value/",
+			useJSON: true,
+			body:    `{"id":"CPUutilization","type":"gauge"}`,
+			want:    want{code: 404},
+		},
 		{
 			name:    "update json counter wrong content type",
 			method:  "POST",
@@ -251,6 +280,22 @@ func TestRouter(t *testing.T) {
 			body:    `{"type":"counter"}`,
 			want:    want{code: 400},
 		},
+		{
+			name:    "update json ID posing as labels",
+			method:  "POST",
+			args:    "/update/",
+			useJSON: true,
+			body:    `{"id":"CPUutilization{cpu=\"1\"}","type":"gauge","value":1}`,
+			want:    want{code: 400},
+		},
+		{
+			name:    "value json ID posing as labels",
+			method:  "POST",
+			args:    "/value/",
+			useJSON: true,
+			body:    `{"id":"CPUutilization{cpu=\"1\"}","type":"gauge"}`,
+			want:    want{code: 400},
+		},
 		{
 			name:    "value json counter unknown ID",
 			method:  "POST",
@@ -290,23 +335,31 @@ func TestRouter(t *testing.T) {
 		})
 	}
 
-	tmpDir := os.TempDir()
-	os.Mkdir(tmpDir, 0755)
-	tmpFile := tmpDir + "/1.json"
-	Config.StoreFile = tmpFile
+}
+
+func TestRouterFileStorage(t *testing.T) {
+	tmpFile := t.TempDir() + "/1.json"
+
+	st, err := NewFileStorage(tmpFile, 0, false)
+	require.NoError(t, err)
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
 
 	resp, _ := testRequest(t, ts, "POST", "/update/counter/c123/123", nil, false)
 	resp.Body.Close()
 
 	tmpF, _ := os.OpenFile(tmpFile, os.O_RDONLY, 0)
+	defer tmpF.Close()
 
 	tmpBuf, _ := io.ReadAll(tmpF)
 	assert.Contains(t, string(tmpBuf), `"c123":123`)
 	t.Logf(string(tmpBuf))
 
-	statistics.Counters["c123"] = 0
-	loadStats()
-	assert.Equal(t, statistics.Counters["c123"], int64(123))
+	restored, err := NewFileStorage(tmpFile, 0, true)
+	require.NoError(t, err)
+	val, ok := restored.GetCounter("c123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(123), val)
 }
 
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, r io.Reader, useJSON bool) (*http.Response, string) {
@@ -325,3 +378,31 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, r io.Re
 
 	return resp, string(respBody)
 }
+
+// newTestServer returns the server with the config and the storage
+func newTestServer(t testing.TB, cfg ConfigType, st Storage) *Server {
+	s, err := NewServer(cfg, st)
+	require.NoError(t, err)
+	return s
+}
+
+func TestJSONUpdateBatchRejected(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	for _, bad := range []string{
+		`{"id":"x{k=\"v\"}","type":"gauge","value":1}`,
+		`{"id":"Alloc","type":"gauge","value":1,"labels":{"a=\"1\",b":"2"}}`,
+		`{"id":"Alloc","type":"gauge"}`,
+		`{"id":"Alloc","type":"histogram","value":1}`,
+	} {
+		body := `[{"id":"PollCount","type":"counter","delta":1},` + bad + `]`
+		resp, _ := testRequest(t, ts, http.MethodPost, "/updates/", strings.NewReader(body), true)
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode, bad)
+	}
+	// the counters before the bad metric are not stored
+	_, ok := st.GetCounter("PollCount")
+	assert.False(t, ok)
+}
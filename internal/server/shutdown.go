@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down")
+
+// stop ends the streams, which otherwise keep the gRPC server from
+// stopping gracefully. The servers are shut down by the caller
+func (s *Server) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// wait waits for the running requests. The servers closed forcibly
+// don't wait for their handlers, so it is called before the storage
+// is closed
+func (s *Server) wait() {
+	s.running.Wait()
+}
+
+// trackRequests is chi middleware function counting the running requests
+func (s *Server) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.running.Add(1)
+		defer s.running.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackUnary is the gRPC interceptor equivalent of trackRequests
+func (s *Server) trackUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	s.running.Add(1)
+	defer s.running.Done()
+	return handler(ctx, req)
+}
+
+// trackStream is the gRPC stream interceptor equivalent of trackRequests
+func (s *Server) trackStream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	s.running.Add(1)
+	defer s.running.Done()
+	return handler(srv, ss)
+}
+
+// shutdown stops the servers from accepting new requests and waits
+// for the running ones to finish, but not longer than timeout.
+// Connections still active after timeout are closed forcibly.
+func shutdown(timeout time.Duration, httpServer *http.Server, grpcServer *grpc.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var httpErr error
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if httpErr = httpServer.Shutdown(ctx); httpErr != nil {
+			httpServer.Close()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Print("gRPC server drain timed out, stopping")
+			grpcServer.Stop()
+		}
+	}()
+
+	wg.Wait()
+	if httpErr != nil {
+		return fmt.Errorf("http server shutdown: %w", httpErr)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+func Test_shutdownWaitsForRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		}),
+	}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go httpServer.Serve(listen)
+
+	respCode := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listen.Addr().String())
+		if err != nil {
+			respCode <- 0
+			return
+		}
+		resp.Body.Close()
+		respCode <- resp.StatusCode
+	}()
+	<-started
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- shutdown(5*time.Second, httpServer, grpc.NewServer())
+	}()
+
+	select {
+	case <-finished:
+		t.Fatal("shutdown returned before the request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-finished)
+	assert.Equal(t, http.StatusOK, <-respCode)
+}
+
+func Test_shutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}),
+	}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go httpServer.Serve(listen)
+	go func() {
+		resp, err := http.Get("http://" + listen.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	err = shutdown(50*time.Millisecond, httpServer, grpc.NewServer())
+	assert.Error(t, err)
+}
+
+func Test_shutdownWaitsForHandlers(t *testing.T) {
+	srv := newTestServer(t, ConfigType{}, NewMemStorage())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	httpServer := &http.Server{
+		Handler: srv.trackRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})),
+	}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go httpServer.Serve(listen)
+	go func() {
+		resp, err := http.Get("http://" + listen.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// the connection is closed forcibly, but the handler still runs
+	assert.Error(t, shutdown(50*time.Millisecond, httpServer, grpc.NewServer()))
+	waited := make(chan struct{})
+	go func() {
+		srv.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned before the handler finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-waited
+}
+
+func Test_shutdownEndsStreams(t *testing.T) {
+	srv := newTestServer(t, ConfigType{}, NewMemStorage())
+	listen := bufconn.Listen(1 << 20)
+	s, err := newGRPCServer(srv)
+	require.NoError(t, err)
+	go s.Serve(listen)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listen.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewMetricesClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamMetrices(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.MetricsBatch{Id: 1}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	watch, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{})
+	require.NoError(t, err)
+
+	srv.stop()
+	start := time.Now()
+	assert.NoError(t, shutdown(5*time.Second, &http.Server{}, s))
+	assert.Less(t, time.Since(start), time.Second)
+	srv.wait()
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
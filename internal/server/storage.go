@@ -0,0 +1,191 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Stats is a copy of all metrics kept by a storage.
+// It is also the layout of the JSON store file.
+type Stats struct {
+	Counters map[string]int64
+	Gauges   map[string]float64
+}
+
+// Storage is the interface every metrics storage backend implements
+type Storage interface {
+	// GetGauge returns the gauge value and whether it is known
+	GetGauge(name string) (float64, bool)
+	// GetCounter returns the counter value and whether it is known
+	GetCounter(name string) (int64, bool)
+	// SetGauge sets the gauge value
+	SetGauge(name string, value float64) error
+	// AddCounter adds delta to the counter and returns the new value
+	AddCounter(name string, delta int64) (int64, error)
+	// List returns a copy of all stored metrics
+	List() Stats
+	// EnableHistory makes the storage keep every sample for
+	// the retention period
+	EnableHistory(retention time.Duration)
+	// History returns the samples of the metric within [from, to]
+	History(typ, name string, from, to time.Time) ([]Point, error)
+	// Watch subscribes for updates of metrics with names starting
+	// with prefix. The returned function cancels the subscription
+	Watch(prefix string) (<-chan Update, func())
+	// Snapshot persists the current state if the backend supports it
+	Snapshot() error
+	// Close flushes and releases the storage
+	Close() error
+}
+
+func newStats() Stats {
+	return Stats{
+		Counters: make(map[string]int64),
+		Gauges:   make(map[string]float64),
+	}
+}
+
+// NewStorage returns the storage backend chosen by the config:
+// Postgres if DatabaseDSN is set, JSON file if StoreFile is set,
+// memory otherwise
+func NewStorage(cfg ConfigType) (Storage, error) {
+	var st Storage
+	var err error
+	switch {
+	case cfg.DatabaseDSN != "":
+		st, err = NewDBStorage(cfg.DatabaseDSN, cfg.Restore)
+	case cfg.StoreFile != "":
+		st, err = NewFileStorage(cfg.StoreFile, cfg.StoreInterval, cfg.Restore)
+	default:
+		log.Print("no persistent storage configured, keeping metrics in memory")
+		st = NewMemStorage()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HistoryRetention > 0 {
+		st.EnableHistory(cfg.HistoryRetention)
+	}
+	return st, nil
+}
+
+// MemStorage keeps metrics in memory only
+type MemStorage struct {
+	history  *history
+	watchers *watchers
+	stats    Stats
+	mu       sync.Mutex
+}
+
+// NewMemStorage returns an empty in-memory storage
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		stats:    newStats(),
+		watchers: newWatchers(),
+	}
+}
+
+// GetGauge returns the gauge value and whether it is known
+func (s *MemStorage) GetGauge(name string) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.stats.Gauges[name]
+	return val, ok
+}
+
+// GetCounter returns the counter value and whether it is known
+func (s *MemStorage) GetCounter(name string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.stats.Counters[name]
+	return val, ok
+}
+
+// SetGauge sets the gauge value
+func (s *MemStorage) SetGauge(name string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Gauges[name] = value
+	if s.history != nil {
+		s.history.add(strTypGauge, name, Point{Time: time.Now(), Value: &value})
+	}
+	s.watchers.publish(Update{Name: name, MType: strTypGauge, Value: value})
+	return nil
+}
+
+// AddCounter adds delta to the counter and returns the new value
+func (s *MemStorage) AddCounter(name string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Counters[name] += delta
+	val := s.stats.Counters[name]
+	if s.history != nil {
+		s.history.add(strTypCounter, name, Point{Time: time.Now(), Total: &val})
+	}
+	s.watchers.publish(Update{Name: name, MType: strTypCounter, Delta: val})
+	return val, nil
+}
+
+// List returns a copy of all stored metrics
+func (s *MemStorage) List() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := newStats()
+	for k, v := range s.stats.Counters {
+		ret.Counters[k] = v
+	}
+	for k, v := range s.stats.Gauges {
+		ret.Gauges[k] = v
+	}
+	return ret
+}
+
+// EnableHistory makes the storage keep every sample in memory
+// for the retention period
+func (s *MemStorage) EnableHistory(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = newHistory(retention)
+}
+
+// History returns the samples of the metric within [from, to]
+func (s *MemStorage) History(typ, name string, from, to time.Time) ([]Point, error) {
+	s.mu.Lock()
+	h := s.history
+	s.mu.Unlock()
+	if h == nil {
+		return []Point{}, nil
+	}
+	return h.get(typ, name, from, to), nil
+}
+
+// Watch subscribes for updates of metrics with names starting
+// with prefix. The returned function cancels the subscription
+func (s *MemStorage) Watch(prefix string) (<-chan Update, func()) {
+	return s.watchers.add(prefix)
+}
+
+// Snapshot does nothing for the memory storage
+func (s *MemStorage) Snapshot() error {
+	return nil
+}
+
+// Close does nothing for the memory storage
+func (s *MemStorage) Close() error {
+	return nil
+}
+
+// replace replaces all stored metrics with the given ones
+func (s *MemStorage) replace(stats Stats) {
+	if stats.Counters == nil {
+		stats.Counters = make(map[string]int64)
+	}
+	if stats.Gauges == nil {
+		stats.Gauges = make(map[string]float64)
+	}
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorage(t *testing.T) {
+	st := NewMemStorage()
+
+	_, ok := st.GetCounter("c")
+	assert.False(t, ok)
+
+	val, err := st.AddCounter("c", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), val)
+	val, err = st.AddCounter("c", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), val)
+
+	require.NoError(t, st.SetGauge("g", 1.5))
+	require.NoError(t, st.SetGauge("g", 2.5))
+	g, ok := st.GetGauge("g")
+	assert.True(t, ok)
+	assert.Equal(t, 2.5, g)
+
+	stats := st.List()
+	stats.Counters["c"] = 100
+	c, _ := st.GetCounter("c")
+	assert.Equal(t, int64(5), c, "List must return a copy")
+}
+
+func TestFileStorageClose(t *testing.T) {
+	tmpFile := t.TempDir() + "/stats.json"
+
+	st, err := NewFileStorage(tmpFile, time.Hour, false)
+	require.NoError(t, err)
+	_, err = st.AddCounter("c", 7)
+	require.NoError(t, err)
+	require.NoError(t, st.SetGauge("g", 0.5))
+	require.NoError(t, st.Close())
+
+	restored, err := NewFileStorage(tmpFile, time.Hour, true)
+	require.NoError(t, err)
+	defer restored.Close()
+	assert.Equal(t, Stats{
+		Counters: map[string]int64{"c": 7},
+		Gauges:   map[string]float64{"g": 0.5},
+	}, restored.List())
+}
+
+func TestRoutersDoNotShareStorage(t *testing.T) {
+	ts1 := httptest.NewServer(Router(newTestServer(t, ConfigType{}, NewMemStorage())))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(Router(newTestServer(t, ConfigType{}, NewMemStorage())))
+	defer ts2.Close()
+
+	resp, _ := testRequest(t, ts1, "POST", "/update/counter/shared/1", nil, false)
+	resp.Body.Close()
+
+	resp, _ = testRequest(t, ts2, "GET", "/value/counter/shared", nil, false)
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+}
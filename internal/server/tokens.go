@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// API token scopes. Admin includes read and write
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+)
+
+// tokenRegistry keeps the API tokens by their hashes
+type tokenRegistry interface {
+	// TokenScopes returns the scopes of the token with the hash,
+	// false if the token is unknown
+	TokenScopes(hash string) ([]string, bool)
+}
+
+// TokenHash returns the hex SHA-256 of the token, as kept in the registry
+func TokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// apiToken is a token in the tokens file
+type apiToken struct {
+	// Name is for the logs only
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+}
+
+// fileTokens is the registry kept in a JSON file,
+// [{"name": "grafana", "hash": "<hex SHA-256>", "scopes": ["read"]}, ...].
+// The file is read again when it changes
+type fileTokens struct {
+	path    string
+	tokens  map[string]apiToken
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func newFileTokens(path string) (*fileTokens, error) {
+	f := &fileTokens{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileTokens) load(modTime time.Time) error {
+	buf, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var list []apiToken
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return fmt.Errorf("tokens %s: %w", f.path, err)
+	}
+	tokens := make(map[string]apiToken, len(list))
+	for _, t := range list {
+		if err := checkScopes(t.Scopes); err != nil {
+			return fmt.Errorf("tokens %s: %s: %w", f.path, t.Name, err)
+		}
+		tokens[strings.ToLower(t.Hash)] = t
+	}
+	f.tokens = tokens
+	f.modTime = modTime
+	return nil
+}
+
+// TokenScopes returns the scopes of the token. If the file can't be
+// read again the tokens read before are used
+func (f *fileTokens) TokenScopes(hash string) ([]string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Print(err)
+	} else if !info.ModTime().Equal(f.modTime) {
+		if err := f.load(info.ModTime()); err != nil {
+			log.Print(err)
+		}
+	}
+	t, ok := f.tokens[hash]
+	return t.Scopes, ok
+}
+
+var initTokensStatement = "CREATE TABLE IF NOT EXISTS api_tokens (hash CHAR (64) PRIMARY KEY, name TEXT NOT NULL DEFAULT '', scopes TEXT NOT NULL)"
+
+// dbTokens is the registry kept in the api_tokens table,
+// the scopes are separated with commas. A token with an unknown
+// scope is refused
+type dbTokens struct {
+	db *sql.DB
+}
+
+func newDBTokens(db *sql.DB) (*dbTokens, error) {
+	if _, err := db.Exec(initTokensStatement); err != nil {
+		return nil, fmt.Errorf("failed to init tokens table: %v", err)
+	}
+	return &dbTokens{db: db}, nil
+}
+
+func (k *dbTokens) TokenScopes(hash string) ([]string, bool) {
+	var scopes string
+	err := k.db.QueryRow("SELECT scopes FROM api_tokens WHERE hash = $1", hash).Scan(&scopes)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Print(err)
+		}
+		return nil, false
+	}
+	list, err := parseScopes(scopes)
+	if err != nil {
+		log.Printf("token %s: %v", hash, err)
+		return nil, false
+	}
+	return list, true
+}
+
+// parseScopes splits the comma separated scopes, "read, write"
+func parseScopes(scopes string) ([]string, error) {
+	list := strings.Split(scopes, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	if err := checkScopes(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func checkScopes(scopes []string) error {
+	for _, s := range scopes {
+		switch s {
+		case scopeRead, scopeWrite, scopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+// loadTokens returns the token registry configured, from the database
+// of the storage or from the file, nil if there is none
+func loadTokens(cfg ConfigType, st Storage) (tokenRegistry, error) {
+	switch {
+	case cfg.TokensDB:
+		dbst, ok := st.(*DBStorage)
+		if !ok {
+			return nil, errors.New("tokens in the database require the database storage")
+		}
+		return newDBTokens(dbst.db)
+	case cfg.TokensFile != "":
+		return newFileTokens(cfg.TokensFile)
+	}
+	return nil, nil
+}
+
+var (
+	errNoToken    = errors.New("no API token")
+	errBadToken   = errors.New("unknown API token")
+	errNoScope    = errors.New("API token has no scope")
+	bearerPrefix  = "Bearer "
+	authorization = "authorization"
+)
+
+// authorize checks the Authorization header value grants the scope
+func (s *Server) authorize(header string, scope string) error {
+	if s.apiTokens == nil {
+		return nil
+	}
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return errNoToken
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+	if token == "" {
+		return errNoToken
+	}
+	scopes, ok := s.apiTokens.TokenScopes(TokenHash(token))
+	if !ok {
+		return errBadToken
+	}
+	for _, s := range scopes {
+		if s == scope || s == scopeAdmin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %s", errNoScope, scope)
+}
+
+// RequireScope is chi middleware function checking the bearer token
+// grants the scope. Any request passes if no tokens are configured
+func (s *Server) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			err := s.authorize(r.Header.Get("Authorization"), scope)
+			switch {
+			case err == nil:
+				next.ServeHTTP(rw, r)
+			case errors.Is(err, errNoScope):
+				log.Print(err)
+				http.Error(rw, "Forbidden", http.StatusForbidden)
+			default:
+				log.Print(err)
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// grpcScopes are the scopes of the Metrices methods,
+// other methods require admin
+var grpcScopes = map[string]string{
+	"/grpcint.Metrices/UpdateMetrices": scopeWrite,
+	"/grpcint.Metrices/StreamMetrices": scopeWrite,
+	"/grpcint.Metrices/GetMetric":      scopeRead,
+	"/grpcint.Metrices/ListMetrics":    scopeRead,
+	"/grpcint.Metrices/WatchMetrics":   scopeRead,
+}
+
+// authorizeGRPC checks the bearer token in the authorization metadata
+func (s *Server) authorizeGRPC(ctx context.Context, method string) error {
+	if s.apiTokens == nil {
+		return nil
+	}
+	scope, ok := grpcScopes[method]
+	if !ok {
+		scope = scopeAdmin
+	}
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(authorization); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	err := s.authorize(header, scope)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errNoScope):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+}
+
+// unaryAuth is the gRPC interceptor equivalent of RequireScope
+func (s *Server) unaryAuth(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := s.authorizeGRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuth is the gRPC stream interceptor equivalent of RequireScope
+func (s *Server) streamAuth(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := s.authorizeGRPC(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
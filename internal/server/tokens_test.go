@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/grpcint"
+	pb "github.com/alexey-mavrin/go-musthave-devops/internal/grpcint/proto"
+)
+
+// tokensFile writes the tokens file with the reader, writer and admin
+// tokens and returns its name
+func tokensFile(t *testing.T) string {
+	tokens := fmt.Sprintf(`[
+		{"name": "reader", "hash": %q, "scopes": ["read"]},
+		{"name": "writer", "hash": %q, "scopes": ["write"]},
+		{"name": "admin", "hash": %q, "scopes": ["admin"]}
+	]`, TokenHash("r-token"), TokenHash("w-token"), TokenHash("a-token"))
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(file, []byte(tokens), 0o600))
+	return file
+}
+
+func TestRequireScope(t *testing.T) {
+	st := NewMemStorage()
+	ts := httptest.NewServer(Router(newTestServer(t, ConfigType{}, st)))
+	defer ts.Close()
+
+	request := func(ts *httptest.Server, method, path, token string) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// no tokens configured, no authentication
+	assert.Equal(t, http.StatusOK, request(ts, http.MethodPost, "/update/counter/PollCount/1", ""))
+
+	ts = httptest.NewServer(Router(newTestServer(t, ConfigType{TokensFile: tokensFile(t)}, st)))
+	defer ts.Close()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no token", http.MethodGet, "/value/counter/PollCount", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/value/counter/PollCount", "x-token", http.StatusUnauthorized},
+		{"read", http.MethodGet, "/value/counter/PollCount", "r-token", http.StatusOK},
+		{"read can't write", http.MethodPost, "/update/counter/PollCount/1", "r-token", http.StatusForbidden},
+		{"write", http.MethodPost, "/update/counter/PollCount/1", "w-token", http.StatusOK},
+		{"write can't read", http.MethodGet, "/value/counter/PollCount", "w-token", http.StatusForbidden},
+		{"write can't debug", http.MethodGet, "/debug/pprof/", "w-token", http.StatusForbidden},
+		{"admin reads", http.MethodGet, "/value/counter/PollCount", "a-token", http.StatusOK},
+		{"admin debugs", http.MethodGet, "/debug/pprof/", "a-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, request(ts, tt.method, tt.path, tt.token))
+		})
+	}
+
+	// ping reports the database, no database with the memory storage
+	assert.Equal(t, http.StatusUnauthorized, request(ts, http.MethodGet, "/ping", ""))
+	assert.Equal(t, http.StatusInternalServerError, request(ts, http.MethodGet, "/ping", "r-token"))
+
+	c, _ := st.GetCounter("PollCount")
+	assert.Equal(t, int64(2), c)
+}
+
+func TestGRPCTokens(t *testing.T) {
+	st := NewMemStorage()
+	listen := bufconn.Listen(1 << 20)
+	s, err := newGRPCServer(newTestServer(t, ConfigType{TokensFile: tokensFile(t)}, st))
+	require.NoError(t, err)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	client := func(token string) pb.MetricesClient {
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listen.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(grpcint.TokenCredentials(token)),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return pb.NewMetricesClient(conn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := func(c pb.MetricesClient) error {
+		mm := []*pb.Metrics{{Id: "PollCount", Mtype: pb.Metrics_COUNTER, Delta: 1}}
+		_, err := c.UpdateMetrices(ctx, &pb.UpdateMetricesRequest{Count: 1, Metrices: mm})
+		return err
+	}
+	assert.Equal(t, codes.Unauthenticated, status.Code(update(client(""))))
+	assert.Equal(t, codes.PermissionDenied, status.Code(update(client("r-token"))))
+	assert.NoError(t, update(client("w-token")))
+
+	_, err = client("w-token").ListMetrics(ctx, &pb.ListMetricsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client("r-token").ListMetrics(ctx, &pb.ListMetricsRequest{})
+	assert.NoError(t, err)
+
+	// the stream is checked when it is opened
+	stream, err := client("r-token").StreamMetrices(ctx)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestLoadTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[{"name": "x", "hash": "00", "scopes": ["root"]}]`), 0o600))
+	_, err := NewServer(ConfigType{TokensFile: file}, NewMemStorage())
+	assert.Error(t, err)
+
+	_, err = NewServer(ConfigType{TokensDB: true}, NewMemStorage())
+	assert.Error(t, err)
+}
+
+func TestRequireScopeBeforeDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := newTestServer(t, ConfigType{TokensFile: tokensFile(t)}, NewMemStorage())
+	s.privateKey = key
+	ts := httptest.NewServer(Router(s))
+	defer ts.Close()
+
+	// the body isn't decrypted for the unauthenticated client
+	resp, err := http.Post(ts.URL+"/updates/", "application/json", strings.NewReader("not encrypted"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader("not encrypted"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer w-token")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes("read, write")
+	require.NoError(t, err)
+	assert.Equal(t, []string{scopeRead, scopeWrite}, scopes)
+
+	for _, bad := range []string{"", "read,", "read, root", "Read"} {
+		_, err := parseScopes(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// watchBuffer is the number of updates a slow watcher may lag behind,
+// further updates are dropped for it
+const watchBuffer = 256
+
+// Update is a metric change pushed to watchers.
+// Name is the series key, Delta is the counter value after the change
+type Update struct {
+	Name  string
+	MType string
+	Delta int64
+	Value float64
+}
+
+type watcher struct {
+	ch     chan Update
+	prefix string
+}
+
+// watchers keeps the subscribers for metric updates
+type watchers struct {
+	list map[*watcher]struct{}
+	mu   sync.Mutex
+}
+
+func newWatchers() *watchers {
+	return &watchers{list: make(map[*watcher]struct{})}
+}
+
+// add subscribes for updates of metrics with names starting with prefix.
+// The returned function unsubscribes and closes the channel
+func (ws *watchers) add(prefix string) (<-chan Update, func()) {
+	w := &watcher{
+		ch:     make(chan Update, watchBuffer),
+		prefix: prefix,
+	}
+	ws.mu.Lock()
+	ws.list[w] = struct{}{}
+	ws.mu.Unlock()
+
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			ws.mu.Lock()
+			delete(ws.list, w)
+			ws.mu.Unlock()
+			close(w.ch)
+		})
+	}
+}
+
+// publish sends the update to all matching watchers without blocking
+func (ws *watchers) publish(u Update) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.list {
+		if !strings.HasPrefix(u.Name, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- u:
+		default:
+			log.Printf("watcher is too slow, dropping update of %s", u.Name)
+		}
+	}
+}
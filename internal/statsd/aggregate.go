@@ -0,0 +1,198 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// Percentiles are the timer percentiles reported by Flush
+var Percentiles = []float64{50, 90, 95, 99}
+
+type series struct {
+	name   string
+	labels map[string]string
+}
+
+type counterState struct {
+	series
+	// sum keeps the fraction not flushed yet, sampled counters
+	// are scaled by the sample rate
+	sum float64
+}
+
+type gaugeState struct {
+	series
+	value float64
+}
+
+type timerState struct {
+	series
+	values []float64
+	// count is the number of events scaled by the sample rate
+	count float64
+}
+
+type setState struct {
+	series
+	members map[string]struct{}
+}
+
+// Aggregator accumulates samples between flushes
+type Aggregator struct {
+	counters map[string]*counterState
+	gauges   map[string]*gaugeState
+	timers   map[string]*timerState
+	sets     map[string]*setState
+	mu       sync.Mutex
+}
+
+// NewAggregator returns an empty aggregator
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		counters: make(map[string]*counterState),
+		gauges:   make(map[string]*gaugeState),
+		timers:   make(map[string]*timerState),
+		sets:     make(map[string]*setState),
+	}
+}
+
+// Add accumulates the sample
+func (a *Aggregator) Add(s Sample) {
+	key := common.SeriesKey(s.Name, s.Tags)
+	sr := series{name: s.Name, labels: s.Tags}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch s.Type {
+	case Counter:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counterState{series: sr}
+			a.counters[key] = c
+		}
+		c.sum += s.Value / s.Rate
+	case Gauge:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gaugeState{series: sr}
+			a.gauges[key] = g
+		}
+		if s.Relative {
+			g.value += s.Value
+		} else {
+			g.value = s.Value
+		}
+	case Timer, Histogram:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timerState{series: sr}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, s.Value)
+		t.count += 1 / s.Rate
+	case Set:
+		st, ok := a.sets[key]
+		if !ok {
+			st = &setState{series: sr, members: make(map[string]struct{})}
+			a.sets[key] = st
+		}
+		st.members[s.SetValue] = struct{}{}
+	}
+}
+
+// Flush returns the aggregated metrics and starts the next interval:
+//   - counters are the sum since the last flush,
+//   - gauges are the last value, they are kept between flushes,
+//   - timers are name.count (counter) and name.sum, name.min, name.max,
+//     name.mean, name.p<N> for Percentiles (gauges) of the interval,
+//   - sets are the number of unique members of the interval (gauge).
+//
+// Counters and timers are reported only if they had samples
+func (a *Aggregator) Flush() []common.Metrics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var mm []common.Metrics
+	for _, key := range common.SortedKeys(a.counters) {
+		c := a.counters[key]
+		delta := int64(c.sum)
+		c.sum -= float64(delta)
+		if delta == 0 {
+			continue
+		}
+		mm = append(mm, counter(c.series, "", delta))
+	}
+	for _, key := range common.SortedKeys(a.gauges) {
+		g := a.gauges[key]
+		mm = append(mm, gauge(g.series, "", g.value))
+	}
+	for _, key := range common.SortedKeys(a.timers) {
+		mm = append(mm, a.timers[key].metrics()...)
+	}
+	for _, key := range common.SortedKeys(a.sets) {
+		s := a.sets[key]
+		mm = append(mm, gauge(s.series, "", float64(len(s.members))))
+	}
+
+	// keep the fractions of counters, they become whole later
+	for key, c := range a.counters {
+		if c.sum == 0 {
+			delete(a.counters, key)
+		}
+	}
+	a.timers = make(map[string]*timerState)
+	a.sets = make(map[string]*setState)
+	return mm
+}
+
+func (t *timerState) metrics() []common.Metrics {
+	values := t.values
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mm := []common.Metrics{
+		counter(t.series, ".count", int64(math.Round(t.count))),
+		gauge(t.series, ".sum", sum),
+		gauge(t.series, ".min", values[0]),
+		gauge(t.series, ".max", values[len(values)-1]),
+		gauge(t.series, ".mean", sum/float64(len(values))),
+	}
+	for _, p := range Percentiles {
+		suffix := ".p" + strconv.FormatFloat(p, 'f', -1, 64)
+		mm = append(mm, gauge(t.series, suffix, percentile(values, p)))
+	}
+	return mm
+}
+
+// percentile returns the nearest rank percentile of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func counter(s series, suffix string, delta int64) common.Metrics {
+	return common.Metrics{
+		ID:     s.name + suffix,
+		MType:  common.NameCounter,
+		Delta:  &delta,
+		Labels: s.labels,
+	}
+}
+
+func gauge(s series, suffix string, value float64) common.Metrics {
+	return common.Metrics{
+		ID:     s.name + suffix,
+		MType:  common.NameGauge,
+		Value:  &value,
+		Labels: s.labels,
+	}
+}
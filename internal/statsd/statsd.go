@@ -0,0 +1,139 @@
+// Package statsd parses and aggregates StatsD metrics.
+// Lines are "name:value|type[|@rate][|#tag:value,...]", the tags
+// are the DogStatsD extension and become labels
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alexey-mavrin/go-musthave-devops/internal/common"
+)
+
+// StatsD metric types
+const (
+	Counter   = "c"
+	Gauge     = "g"
+	Timer     = "ms"
+	Histogram = "h"
+	Set       = "s"
+)
+
+// Sample is a parsed StatsD line
+type Sample struct {
+	Name string
+	Type string
+	// Value is the number, unused for sets
+	Value float64
+	// SetValue is the set member
+	SetValue string
+	// Rate is the sample rate of counters and timers, 1 if not set
+	Rate float64
+	// Relative is set for gauges with the explicit sign, they are
+	// added to the current gauge value
+	Relative bool
+	Tags     map[string]string
+}
+
+// ParseLine parses one StatsD line
+func ParseLine(line string) (Sample, error) {
+	s := Sample{Rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return s, fmt.Errorf("bad line %q: no name", line)
+	}
+	if err := common.CheckName(name); err != nil {
+		return s, fmt.Errorf("bad line %q: %w", line, err)
+	}
+	s.Name = name
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return s, fmt.Errorf("bad line %q: no type", line)
+	}
+	value := parts[0]
+	s.Type = parts[1]
+
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, fmt.Errorf("bad line %q: bad sample rate", line)
+			}
+			s.Rate = rate
+		case strings.HasPrefix(p, "#"):
+			s.Tags = parseTags(p[1:])
+			if err := common.CheckLabels(s.Tags); err != nil {
+				return s, fmt.Errorf("bad line %q: %w", line, err)
+			}
+		}
+	}
+
+	var err error
+	switch s.Type {
+	case Set:
+		if value == "" {
+			return s, fmt.Errorf("bad line %q: empty set value", line)
+		}
+		s.SetValue = value
+		return s, nil
+	case Gauge:
+		s.Relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	case Counter, Timer, Histogram:
+	default:
+		return s, fmt.Errorf("bad line %q: unknown type %s", line, s.Type)
+	}
+	if s.Value, err = strconv.ParseFloat(value, 64); err != nil {
+		return s, fmt.Errorf("bad line %q: %w", line, err)
+	}
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return s, fmt.Errorf("bad line %q: value is not finite", line)
+	}
+	return s, nil
+}
+
+// parseTags parses DogStatsD tags "k1:v1,k2". Tags without
+// a value get the empty value
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, t := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(t, ":")
+		if k != "" {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// ParsePacket parses the newline separated lines of a packet.
+// Bad lines are skipped, the error describes the first of them
+func ParsePacket(buf []byte) ([]Sample, error) {
+	var samples []Sample
+	var firstErr error
+	bad := 0
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s, err := ParseLine(line)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			bad++
+			continue
+		}
+		samples = append(samples, s)
+	}
+	if bad > 1 {
+		return samples, fmt.Errorf("%w (and %d more bad lines)", firstErr, bad-1)
+	}
+	return samples, firstErr
+}
@@ -0,0 +1,91 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Sample
+	}{
+		{"hits:1|c", Sample{Name: "hits", Type: Counter, Value: 1, Rate: 1}},
+		{"hits:3|c|@0.5", Sample{Name: "hits", Type: Counter, Value: 3, Rate: 0.5}},
+		{"temp:-2.5|g", Sample{Name: "temp", Type: Gauge, Value: -2.5, Rate: 1, Relative: true}},
+		{"temp:20|g|#room:kitchen,floor:1", Sample{Name: "temp", Type: Gauge, Value: 20, Rate: 1,
+			Tags: map[string]string{"room": "kitchen", "floor": "1"}}},
+		{"req.time:320|ms", Sample{Name: "req.time", Type: Timer, Value: 320, Rate: 1}},
+		{"size:10|h", Sample{Name: "size", Type: Histogram, Value: 10, Rate: 1}},
+		{"users:alice|s", Sample{Name: "users", Type: Set, SetValue: "alice", Rate: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			s, err := ParseLine(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s)
+		})
+	}
+
+	for _, bad := range []string{"hits", ":1|c", "hits:1", "hits:x|c", "hits:1|q", "hits:1|c|@2", "users:|s", "a{b}:1|c", "hits:NaN|c", "hits:Inf|c", "load:-Inf|g", "rt:nan|ms", "temp:1|g|#room.no:1"} {
+		_, err := ParseLine(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	samples, err := ParsePacket([]byte("a:1|c\nbad\n\nb:2|g\nworse\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1 more")
+	require.Len(t, samples, 2)
+	assert.Equal(t, "b", samples[1].Name)
+
+	samples, err = ParsePacket([]byte("a:1|c\n"))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator()
+	for _, line := range []string{
+		"hits:1|c", "hits:1|c|@0.5", "hits:1|c|#path:/",
+		"temp:20|g", "temp:+2|g",
+		"users:alice|s", "users:bob|s", "users:alice|s",
+	} {
+		s, err := ParseLine(line)
+		require.NoError(t, err)
+		a.Add(s)
+	}
+	for i := 1; i <= 100; i++ {
+		a.Add(Sample{Name: "rt", Type: Timer, Value: float64(i), Rate: 1})
+	}
+
+	got := make(map[string]string)
+	for _, m := range a.Flush() {
+		got[m.Key()] = m.String()
+	}
+	assert.Equal(t, "hits:counter:3", got["hits"])
+	assert.Equal(t, `hits{path="/"}:counter:1`, got[`hits{path="/"}`])
+	assert.Equal(t, "temp:gauge:22.000000", got["temp"])
+	assert.Equal(t, "users:gauge:2.000000", got["users"])
+	assert.Equal(t, "rt.count:counter:100", got["rt.count"])
+	assert.Equal(t, "rt.sum:gauge:5050.000000", got["rt.sum"])
+	assert.Equal(t, "rt.min:gauge:1.000000", got["rt.min"])
+	assert.Equal(t, "rt.mean:gauge:50.500000", got["rt.mean"])
+	assert.Equal(t, "rt.p95:gauge:95.000000", got["rt.p95"])
+	assert.Equal(t, "rt.p99:gauge:99.000000", got["rt.p99"])
+
+	// only gauges are kept for the next interval
+	a.Add(Sample{Name: "hits", Type: Counter, Value: 0.5, Rate: 1})
+	mm := a.Flush()
+	require.Len(t, mm, 1)
+	assert.Equal(t, "temp", mm[0].ID)
+
+	// fractions add up
+	a.Add(Sample{Name: "hits", Type: Counter, Value: 0.5, Rate: 1})
+	mm = a.Flush()
+	require.Len(t, mm, 2)
+	assert.Equal(t, int64(1), *mm[0].Delta)
+}